@@ -1,8 +1,16 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -10,37 +18,169 @@ import (
 	"clearoutspaces/internal/database"
 	"clearoutspaces/internal/handlers"
 	"clearoutspaces/internal/llm"
+	"clearoutspaces/internal/logging"
+	"clearoutspaces/internal/metrics"
+	"clearoutspaces/internal/sweeper"
+	"clearoutspaces/internal/version"
 )
 
 func main() {
+	showVersion := flag.Bool("version", false, "print build info and exit")
+	flag.Parse()
+	if *showVersion {
+		v := version.Get()
+		fmt.Printf("clearoutspaces-api %s (commit %s, built %s, %s)\n", v.Version, v.Commit, v.BuiltAt, v.GoVersion)
+		return
+	}
+
 	// 1. Load and validate all environment variables — fail fast if any are missing.
+	logging.Setup(os.Stdout, slog.LevelInfo) // so config errors are JSON too
+	v := version.Get()
+	slog.Info("main: starting", "version", v.Version, "commit", v.Commit, "built_at", v.BuiltAt, "go_version", v.GoVersion)
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("config: %v", err)
+		logging.Fatal("config: invalid configuration", "err", err)
+	}
+	handlers.ApplyReloadable(cfg.Reloadable()) // log level and LLM tuning
+	if cfg.DryRun {
+		slog.Warn("main: DRY_RUN is on; WhatsApp and Slack messages are logged, not sent")
 	}
 
 	// 2. Load and compile the YAML system prompt.
-	llm.LoadPrompt("templates/system_prompt.yaml")
+	if cfg.PromptVariantsDir != "" {
+		llm.LoadPromptVariants(cfg.PromptVariantsDir)
+	} else {
+		llm.LoadPrompt(cfg.SystemPromptPath)
+	}
+	provider, err := llm.NewProvider(cfg.LLMProvider, cfg.OpenAIBaseURL, cfg.OpenAIModel)
+	if err != nil {
+		logging.Fatal("llm: invalid provider", "err", err)
+	}
+	handlers.SetLLMProvider(provider)
+	slog.Info("llm: provider selected", "provider", cfg.LLMProvider)
 
 	// 3. Initialise the SQLite database and run migrations.
 	db := database.Init(cfg.DBPath)
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetWritePolicy(database.WritePolicy{
+		Retries:    cfg.DBWriteRetries,
+		RetryDelay: cfg.DBWriteRetryDelay,
+		Threshold:  cfg.DBWriteFailureThreshold,
+		OnDegraded: handlers.DBDegradedAlert(cfg),
+	})
+	llm.SetVariantStore(db)
+
+	// Background work (inbound processing, workers, sweepers) stops on
+	// SIGINT/SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// 4. Set up the router.
 	r := mux.NewRouter()
 
-	r.HandleFunc("/health", handlers.HealthCheck).Methods(http.MethodGet)
+	r.HandleFunc("/health", handlers.HealthCheck(db)).Methods(http.MethodGet)
+	r.HandleFunc("/ready", handlers.HandleReadiness(db)).Methods(http.MethodGet)
+	r.HandleFunc("/version", handlers.HandleVersion()).Methods(http.MethodGet)
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
 
 	// Meta / WhatsApp routes.
 	r.HandleFunc("/whatsapp/webhook", handlers.VerifyWebhook(cfg)).Methods(http.MethodGet)
-	r.HandleFunc("/whatsapp/webhook", handlers.HandleWhatsAppMessage(db, cfg)).Methods(http.MethodPost)
+	r.HandleFunc("/whatsapp/webhook", handlers.HandleWhatsAppMessage(ctx, db, cfg)).Methods(http.MethodPost)
 
 	// Slack interactive route.
 	r.HandleFunc("/slack/interactive", handlers.HandleSlackInteractive(db, cfg)).Methods(http.MethodPost)
+	r.HandleFunc("/slack/command", handlers.HandleSlackCommand(db, cfg)).Methods(http.MethodPost)
+
+	// Admin routes (bearer ADMIN_TOKEN).
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/completeness", handlers.RequireAdmin(cfg, handlers.HandleCompleteness(db))).Methods(http.MethodGet)
+	admin.HandleFunc("/conversations/{phone}", handlers.RequireAdmin(cfg, handlers.HandleTranscript(db, cfg))).Methods(http.MethodGet)
+	admin.HandleFunc("/conversations/{phone}/language", handlers.RequireAdmin(cfg, handlers.HandleSetLanguage(db, cfg))).Methods(http.MethodPut)
+	admin.HandleFunc("/conversations/{phone}/notes", handlers.RequireAdmin(cfg, handlers.HandleAddNote(db, cfg))).Methods(http.MethodPost)
+	admin.HandleFunc("/conversations/{phone}/handoff", handlers.RequireAdmin(cfg, handlers.HandleManualHandoff(db, cfg))).Methods(http.MethodPost)
+	admin.HandleFunc("/conversations/{phone}/export", handlers.RequireAdmin(cfg, handlers.HandleExportConversation(db, cfg))).Methods(http.MethodGet)
+	admin.HandleFunc("/quote/{phone}", handlers.RequireAdmin(cfg, handlers.HandleQuote(db, cfg))).Methods(http.MethodGet)
+	admin.HandleFunc("/stats", handlers.RequireAdmin(cfg, handlers.HandleStats(db))).Methods(http.MethodGet)
+	admin.HandleFunc("/usage", handlers.RequireAdmin(cfg, handlers.HandleUsage(db))).Methods(http.MethodGet)
+	admin.HandleFunc("/search", handlers.RequireAdmin(cfg, handlers.HandleSearch(db))).Methods(http.MethodGet)
+	admin.HandleFunc("/media/{id}", handlers.RequireAdmin(cfg, handlers.HandleMedia(cfg))).Methods(http.MethodGet)
+	admin.HandleFunc("/messages/{id}/raw", handlers.RequireAdmin(cfg, handlers.HandleMessageRaw(db))).Methods(http.MethodGet)
+	admin.HandleFunc("/failures", handlers.RequireAdmin(cfg, handlers.HandleOutboundFailures(db))).Methods(http.MethodGet)
+	admin.HandleFunc("/events", handlers.RequireAdmin(cfg, handlers.HandleWebhookEvents(db))).Methods(http.MethodGet)
+	admin.HandleFunc("/reload-prompt", handlers.RequireAdmin(cfg, handlers.HandleReloadPrompt())).Methods(http.MethodPost)
+
+	// 5. Start background jobs.
+	var jobs sync.WaitGroup
+	start := func(run func()) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			run()
+		}()
+	}
+	if cfg.AutoResumeAfter > 0 {
+		start(func() { sweeper.RunAutoResume(ctx, db, cfg.AutoResumeInterval, cfg.AutoResumeAfter) })
+	}
+	if cfg.OutboundQueue {
+		start(func() { handlers.RunOutboundWorker(ctx, db, cfg) })
+	}
+	if cfg.MaxActivePerSource > 0 && cfg.OverCapacityAction == config.OverCapacityQueue {
+		start(func() { handlers.RunCapacityQueue(ctx, db, cfg) })
+	}
+	if cfg.RetentionDays > 0 {
+		start(func() { sweeper.RunRetention(ctx, db, time.Hour, time.Duration(cfg.RetentionDays)*24*time.Hour) })
+	}
 
-	// 5. Start the server.
+	// SIGHUP re-reads the prompt and the reloadable config (log level, LLM
+	// tuning); see config.Reloadable. Anything else needs a restart.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				next, err := config.Load()
+				if err != nil {
+					slog.Error("main: reload config, keeping current", "err", err)
+					continue
+				}
+				if err := handlers.Reload(ctx, next); err != nil {
+					slog.Error("main: reload, keeping current", "err", err)
+				}
+			}
+		}
+	}()
+
+	// 6. Start the server and shut it down cleanly on signal.
 	addr := ":8080"
-	log.Printf("server: listening on %s", addr)
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatalf("server: %v", err)
+	srv := &http.Server{Addr: addr, Handler: r}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		slog.Info("server: shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("server: shutdown", "err", err)
+		}
+	}()
+
+	slog.Info("server: listening", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logging.Fatal("server: listen", "err", err)
+	}
+
+	// Close the database once in-flight requests, queued webhooks, debounced
+	// replies and background jobs have finished.
+	<-shutdownDone
+	handlers.Wait()
+	jobs.Wait()
+	if err := db.Close(); err != nil {
+		slog.Error("database: close", "err", err)
+		return
 	}
+	slog.Info("database: closed")
 }
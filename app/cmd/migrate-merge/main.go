@@ -0,0 +1,64 @@
+// migrate-merge finds conversations stored twice under the same number, once
+// with a leading "+" from before phone numbers were normalized, and merges
+// each into its normalized key. Without --apply it only lists them.
+// Run with: go run ./cmd/migrate-merge --db ../data/db.sqlite [--apply]
+// Or merge one pair: go run ./cmd/migrate-merge --db ../data/db.sqlite --apply <from> <into>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"clearoutspaces/internal/database"
+)
+
+func main() {
+	dbPath := flag.String("db", os.Getenv("DB_PATH"), "SQLite database path (default $DB_PATH)")
+	apply := flag.Bool("apply", false, "merge the duplicates instead of only listing them")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: migrate-merge [--db path] [--apply] [<from> <into>]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *dbPath == "" || (flag.NArg() != 0 && flag.NArg() != 2) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db := database.Init(*dbPath)
+	defer db.Close()
+
+	var dups []database.DuplicateConversation
+	if flag.NArg() == 2 {
+		dups = []database.DuplicateConversation{{From: flag.Arg(0), Into: flag.Arg(1)}}
+	} else {
+		var err error
+		if dups, err = db.FindDuplicateConversations(); err != nil {
+			fail("find duplicates: %v", err)
+		}
+		if len(dups) == 0 {
+			fmt.Println("no duplicate conversations")
+			return
+		}
+	}
+
+	for _, d := range dups {
+		if !*apply {
+			fmt.Printf("  found  %s -> %s\n", d.From, d.Into)
+			continue
+		}
+		if err := db.MergeConversations(d.From, d.Into); err != nil {
+			fail("merge %s into %s: %v", d.From, d.Into, err)
+		}
+		fmt.Printf("  merged %s -> %s\n", d.From, d.Into)
+	}
+	if !*apply {
+		fmt.Println("rerun with --apply to merge")
+	}
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "migrate-merge: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,75 @@
+// replay runs a stored conversation through the LLM with the current prompt
+// and prints the response, so prompt changes can be checked offline without
+// messaging real customers. No messages are sent or saved.
+// Run with: go run ./cmd/replay --db ../data/db.sqlite 14165551234
+// Reads DEEPSEEK_API_KEY from the environment (source .env first).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/llm"
+)
+
+func main() {
+	dbPath := flag.String("db", os.Getenv("DB_PATH"), "SQLite database path (default $DB_PATH)")
+	promptPath := flag.String("prompt", "templates/system_prompt.yaml", "system prompt template")
+	limit := flag.Int("limit", 20, "recent messages to replay")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: replay [--db path] [--prompt path] [--limit n] <phone>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *dbPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	phone := strings.TrimPrefix(strings.TrimSpace(flag.Arg(0)), "+")
+	apiKey := os.Getenv("DEEPSEEK_API_KEY")
+	if apiKey == "" {
+		fail("DEEPSEEK_API_KEY is not set")
+	}
+
+	llm.LoadPrompt(*promptPath)
+	db := database.Init(*dbPath)
+	defer db.Close()
+
+	history, err := db.GetRecentMessages(phone, *limit)
+	if err != nil {
+		fail("load history: %v", err)
+	}
+	if len(history) == 0 {
+		fail("no messages stored for %s", phone)
+	}
+
+	fmt.Printf("\n── History (%d messages) ───────────────────────────────────\n", len(history))
+	for _, m := range history {
+		fmt.Printf("  [%s] %s\n", m.Role, m.Content)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	resp, raw, err := llm.Call(ctx, apiKey, phone, history)
+
+	fmt.Println("\n── Raw LLM content ─────────────────────────────────────────")
+	fmt.Printf("  %s\n", raw)
+	fmt.Println("\n── Parsed response ─────────────────────────────────────────")
+	out, _ := json.MarshalIndent(resp, "  ", "  ")
+	fmt.Printf("  %s\n\n", out)
+	if err != nil {
+		fail("llm: %v (parsed response above is the fallback)", err)
+	}
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "replay: "+format+"\n", args...)
+	os.Exit(1)
+}
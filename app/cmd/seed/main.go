@@ -0,0 +1,178 @@
+// seed fills a SQLite database with a handful of realistic conversations in
+// varied states, for trying the admin endpoints locally. Conversations that
+// already exist are left alone, so it is safe to run twice.
+// Run with: go run ./cmd/seed --db ../data/db.sqlite
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/models"
+)
+
+// fixture is one seeded conversation. turns alternate user, assistant,
+// starting with the customer.
+type fixture struct {
+	phone    string
+	name     string
+	language string
+	stage    string
+	pausedBy string // non-empty pauses the conversation
+	quote    *models.ExtractedData
+	turns    []string
+	action   string // action stored on the last assistant turn
+}
+
+func fixtures() []fixture {
+	long := make([]string, 0, 40)
+	for i := 0; i < 20; i++ {
+		long = append(long,
+			fmt.Sprintf("Also there's item %d in the garage, is that okay?", i+1),
+			"No problem, we can take that too. Anything else?",
+		)
+	}
+	return []fixture{
+		{
+			phone: "14165550101", name: "Sam Rivera", language: "en", stage: models.StageCollecting,
+			quote: &models.ExtractedData{Address: "88 Queen St E", ElevatorAccess: "unknown", Stairs: "unknown", Inventory: "a couch"},
+			turns: []string{
+				"Hi, can you pick up an old couch?",
+				"Absolutely! What's the pickup address?",
+				"88 Queen St E",
+				"Thanks! Is there an elevator, or will we be using stairs?",
+			},
+			action: "continue",
+		},
+		{
+			phone: "14165550102", name: "Priya Shah", language: "en", stage: models.StageQuoted, pausedBy: "jordan",
+			quote: &models.ExtractedData{Address: "12 King St W, Unit 1804", ElevatorAccess: "yes", Stairs: "no", Inventory: "sofa, 2 chairs, mattress"},
+			turns: []string{
+				"Moving out Saturday, need a sofa, 2 chairs and a mattress gone from 12 King St W unit 1804",
+				"Happy to help! Is there an elevator?",
+				"Yes, freight elevator, no stairs",
+				"Perfect, our team will send your quote shortly.",
+			},
+			action: "handoff",
+		},
+		{
+			phone: "16045550103", name: "Luc Tremblay", language: "fr", stage: models.StageScheduled,
+			quote: &models.ExtractedData{Address: "300 Rue Saint-Paul", ElevatorAccess: "no", Stairs: "yes", Inventory: "frigo"},
+			turns: []string{
+				"Bonjour, pouvez-vous enlever un vieux frigo?",
+				"Bien sûr ! Quelle est l'adresse ?",
+				"300 Rue Saint-Paul, deuxième étage, pas d'ascenseur",
+				"Merci ! Vous pouvez réserver une évaluation ici.",
+			},
+			action: "schedule",
+		},
+		{
+			phone: "14165550104", name: "Alex Chen", language: "en", stage: models.StageCollecting,
+			turns:  append([]string{"Clearing out my parents' house, lots of stuff", "We can help with that! Let's start with the address."}, long...),
+			action: "continue",
+		},
+		{
+			phone: "14165550105", language: "es", stage: models.StageNew,
+			turns: []string{"Hola, ¿recogen muebles?"},
+		},
+	}
+}
+
+func main() {
+	dbPath := flag.String("db", os.Getenv("DB_PATH"), "SQLite database path (default $DB_PATH)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: seed [--db path]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *dbPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db := database.Init(*dbPath)
+	defer db.Close()
+
+	for _, f := range fixtures() {
+		if _, err := db.GetConversation(f.phone); err == nil {
+			fmt.Printf("  skip  +%s (already exists)\n", f.phone)
+			continue
+		} else if !errors.Is(err, database.ErrConversationNotFound) {
+			fail("look up %s: %v", f.phone, err)
+		}
+		if err := seed(db, f); err != nil {
+			fail("seed %s: %v", f.phone, err)
+		}
+		fmt.Printf("  added +%s (%d messages, %s)\n", f.phone, len(f.turns), f.stage)
+	}
+}
+
+// seed writes one fixture. The conversation row goes first; messages and
+// quote data reference it.
+func seed(db *database.DB, f fixture) error {
+	if err := db.UpsertConversation(f.phone); err != nil {
+		return err
+	}
+	if f.name != "" {
+		if err := db.SetConversationDisplayName(f.phone, f.name); err != nil {
+			return err
+		}
+	}
+	if f.language != "" {
+		if err := db.SetConversationLanguage(f.phone, f.language); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now().Add(-time.Duration(len(f.turns)) * 3 * time.Minute)
+	for i, content := range f.turns {
+		m := &models.Message{
+			ID:             fmt.Sprintf("seed-%s-%02d", f.phone, i),
+			ConversationID: f.phone,
+			Role:           "user",
+			Content:        content,
+			SentAt:         start.Add(time.Duration(i) * 3 * time.Minute),
+		}
+		if i%2 == 1 {
+			m.Role = "assistant"
+			m.Action = "continue"
+			if i == len(f.turns)-1 && f.action != "" {
+				m.Action = f.action
+			}
+		}
+		if err := db.InsertMessage(m); err != nil {
+			return err
+		}
+	}
+
+	if f.quote != nil {
+		dataJSON, err := json.Marshal(f.quote)
+		if err != nil {
+			return err
+		}
+		if err := db.UpsertQuoteData(f.phone, string(dataJSON)); err != nil {
+			return err
+		}
+	}
+	if f.stage != "" && f.stage != models.StageNew {
+		if err := db.SetStage(f.phone, f.stage); err != nil {
+			return err
+		}
+	}
+	if f.pausedBy != "" {
+		if err := db.PauseConversation(f.phone, f.pausedBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "seed: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -56,12 +56,12 @@ func checkHealth() error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("expected 200, got %d", resp.StatusCode)
 	}
-	var body map[string]string
+	var body map[string]any
 	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
 		return fmt.Errorf("invalid JSON: %w", err)
 	}
 	if body["status"] != "healthy" {
-		return fmt.Errorf("expected status=healthy, got %q", body["status"])
+		return fmt.Errorf("expected status=healthy, got %v", body["status"])
 	}
 	return nil
 }
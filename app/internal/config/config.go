@@ -2,12 +2,49 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"clearoutspaces/internal/logging"
+	"clearoutspaces/internal/models"
 )
 
+// SlackRoute maps a phone prefix (area code, e.g. "416") to a Slack webhook.
+type SlackRoute struct {
+	Prefix string
+	URL    string
+}
+
+// BusinessHours is the daily window staff answer in, as offsets from
+// midnight in Location. Close before Open spans midnight. A nil Location
+// means always open.
+type BusinessHours struct {
+	Open, Close time.Duration
+	Location    *time.Location
+}
+
 type Config struct {
 	DBPath string
 
+	// DBMaxOpenConns is the SQLite connection pool size. Above 1, reads run
+	// concurrently under WAL while writes still serialize. Default: 1.
+	DBMaxOpenConns int
+
+	// LogLevel is the minimum level of the JSON logs, from LOG_LEVEL
+	// (debug, info, warn, error). Default: info.
+	LogLevel slog.Level
+
+	// DryRun runs the full pipeline but logs WhatsApp sends and Slack posts
+	// instead of making them, for load tests and demos. Default: false.
+	DryRun bool
+
 	MetaVerifyToken   string
 	MetaAppSecret     string
 	MetaAccessToken   string
@@ -15,12 +52,257 @@ type Config struct {
 
 	DeepSeekAPIKey string
 
-	SlackWebhookURL    string
+	SlackWebhookURL string
+	// SlackWebhookRoutes send handoffs for matching area codes to another
+	// channel, longest prefix first; SlackWebhookURL is the fallback.
+	SlackWebhookRoutes []SlackRoute
 	SlackSigningSecret string
+	// SlackBotToken, with SlackChannel, posts handoffs via chat.postMessage
+	// so each conversation's handoffs share one thread. Optional — when empty
+	// handoffs go to the incoming webhook as separate messages. Can't be
+	// combined with SlackWebhookRoutes.
+	SlackBotToken string
+	SlackChannel  string
+
+	// BookingURL is the assessment booking link sent with the schedule action.
+	BookingURL string
+
+	// BaseURL is where this service is reachable (e.g.
+	// https://bot.example.com), used to build absolute links such as the
+	// transcript link on Slack handoffs. Optional — links are left out when
+	// it or AdminToken is empty.
+	BaseURL string
+
+	// AdminToken guards the /admin endpoints. Optional — when empty the
+	// admin API rejects every request.
+	AdminToken string
+
+	// SanitizeReplies strips leaked schema/prompt fragments and internal
+	// links from assistant replies before sending. Default: true.
+	SanitizeReplies bool
+	// ReplyBlockedPatterns are extra regexes removed from assistant replies,
+	// read from REPLY_BLOCKED_PATTERNS (semicolon-separated).
+	ReplyBlockedPatterns []*regexp.Regexp
+
+	// LanguageLock pins each conversation to the first language detected so
+	// replies don't flip when a customer code-switches. Default: false.
+	LanguageLock bool
+
+	// TypingIndicator shows the customer a typing indicator along with the
+	// read receipt while the reply is generated. Default: false.
+	TypingIndicator bool
+
+	// SenderFooter is appended to assistant-written WhatsApp replies so
+	// customers can tell them from staff messages; static replies don't get
+	// it. Only used when SenderFooterEnabled. Default: "— ClearoutSpaces Assistant".
+	SenderFooter        string
+	SenderFooterEnabled bool
+
+	// TemplateLanguage is the language code of approved WhatsApp message
+	// templates, used for outreach outside the 24h window. Default: en.
+	TemplateLanguage string
+
+	// DebounceWindow is how long to wait for further messages from the same
+	// customer before replying to the batch (0 = reply to each message).
+	DebounceWindow time.Duration
+
+	// LockTimeout bounds how long a message waits for its conversation's
+	// lock while another is being answered; on timeout it is saved without
+	// a reply and picked up with the history next time (0 = wait forever).
+	// Must outlast an LLM call; the default covers a summary and a reply.
+	LockTimeout time.Duration
+
+	// RateLimitPerMinute caps inbound messages per phone number that reach
+	// the LLM (0 = unlimited), allowing bursts of up to RateLimitBurst.
+	// Messages over the limit are saved but get no reply.
+	RateLimitPerMinute int
+	RateLimitBurst     int
+
+	// MessageMaxFutureSkew and MessageMaxAge bound how far a Meta message
+	// timestamp may sit from the receive time before it is clamped to it.
+	MessageMaxFutureSkew time.Duration
+	MessageMaxAge        time.Duration
+
+	// MaxMessageChars caps inbound text length (0 = unlimited). Longer
+	// messages are saved truncated with a marker, then either answered from
+	// the truncated text or rejected with a polite reply, per
+	// OversizeMessageAction.
+	MaxMessageChars       int
+	OversizeMessageAction string
+
+	// EmptyMessageAction decides what happens to a text message that is
+	// empty or only whitespace: "reply" asks the customer whether they meant
+	// to send something, "ignore" drops it. Neither calls the LLM.
+	EmptyMessageAction string
+
+	// MaxActivePerSource caps concurrently-active bot conversations per
+	// receiving business number (0 = unlimited). A conversation is active when
+	// the bot replied within ActiveWindow. New conversations over the cap get a
+	// high-volume reply and are either queued (saved, answered once capacity
+	// frees) or closed (saved, never answered), per OverCapacityAction.
+	MaxActivePerSource int
+	ActiveWindow       time.Duration
+	OverCapacityAction string
+
+	// PendingApprovalBehavior decides what happens to a message that arrives
+	// while an assistant draft awaits staff approval: "queue" saves it for the
+	// next draft, "update" regenerates the pending draft with the new context.
+	PendingApprovalBehavior string
+
+	// HandoffAfterTurns forces a handoff once the bot has replied this many
+	// times in a conversation that hasn't been handed off yet, so a human
+	// always steps in eventually (0 = never). Default: 8.
+	HandoffAfterTurns int
+
+	// AutoResumeAfter hands a PAUSED conversation back to the bot once it has
+	// been paused this long (0 = never), checked every AutoResumeInterval.
+	AutoResumeAfter    time.Duration
+	AutoResumeInterval time.Duration
+
+	// RetentionDays moves messages older than this many days into
+	// archived_messages and deletes older webhook events, checked hourly
+	// (0 = keep everything).
+	RetentionDays int
+
+	// Allowlist, when set, limits bot replies to these numbers; others are
+	// saved without a reply. Blocklist numbers are saved and ignored. Both
+	// hold E.164 numbers without the "+".
+	Allowlist []string
+	Blocklist []string
+
+	// DefaultCountryCode (digits, e.g. "1") is prefixed to bare local numbers
+	// entered in the admin API or imported from lists. Slack and inbound
+	// numbers are taken as already international. Optional.
+	DefaultCountryCode string
+
+	// DB write failure handling: persistent errors (disk full, read-only
+	// mount) are retried DBWriteRetries times, and after
+	// DBWriteFailureThreshold consecutive failed writes Slack gets one ops
+	// alert and /ready reports unavailable until a write succeeds.
+	DBWriteRetries          int
+	DBWriteRetryDelay       time.Duration
+	DBWriteFailureThreshold int
+
+	// InboundWorkers process verified webhooks from a queue of
+	// InboundQueueSize; bodies arriving while it is full are saved to
+	// deferred_inbound and processed once there is room. Defaults: 8, 200.
+	InboundWorkers   int
+	InboundQueueSize int
+
+	// OutboundQueue persists customer replies in outbound_messages and has a
+	// worker deliver them, retrying failures up to OutboundMaxAttempts with
+	// exponential backoff from OutboundRetryBaseDelay. Default: true.
+	OutboundQueue          bool
+	OutboundMaxAttempts    int
+	OutboundRetryBaseDelay time.Duration
+
+	// LLMProvider selects the LLM backend: "deepseek" (default) or "openai"
+	// for any OpenAI-compatible API at OpenAIBaseURL serving OpenAIModel.
+	// LLMAPIKey is the key for the selected provider.
+	LLMProvider   string
+	OpenAIAPIKey  string
+	OpenAIBaseURL string
+	OpenAIModel   string
+	LLMAPIKey     string
+
+	// LLMMaxAttempts and LLMRetryBaseDelay control retries of transient
+	// DeepSeek failures (network errors, 429, 5xx).
+	LLMMaxAttempts    int
+	LLMRetryBaseDelay time.Duration
+	// LLMTimeout bounds each LLM HTTP attempt; the reply's overall deadline
+	// is slightly longer so a slow provider fails as a clean timeout.
+	// Default: 30s.
+	LLMTimeout time.Duration
+
+	// HistoryLimit is how many recent messages are sent to DeepSeek, and
+	// LLMMaxPromptTokens the rough token budget (~4 chars/token) the request
+	// is trimmed to by dropping the oldest turns (0 = unlimited).
+	HistoryLimit       int
+	LLMMaxPromptTokens int
+
+	// LLMSummarize replaces older history with a cached LLM summary: once
+	// more than LLMSummarizeAfter messages aren't covered by it, all but the
+	// latest HistoryLimit are folded into the summary, which is sent ahead
+	// of the rest. Defaults: false, 40.
+	LLMSummarize      bool
+	LLMSummarizeAfter int
+
+	// LLMStream streams DeepSeek responses and sends the reply as soon as
+	// reply_to_user is complete, before the rest of the JSON arrives. Off
+	// hours replies wait for the action, as a handoff's is replaced.
+	LLMStream bool
+
+	// LLMJSONSchema sends the response's full JSON schema as a strict
+	// json_schema response_format instead of plain json_object, for
+	// providers that support structured output. Default: false.
+	LLMJSONSchema bool
+
+	// LLMDedupe shares one LLM call between concurrent requests with the
+	// same conversation and messages, such as a retried webhook racing the
+	// original. Default: true.
+	LLMDedupe bool
+
+	// SystemPromptPath is the YAML system prompt template. A relative path is
+	// resolved against the executable's directory, falling back to the
+	// working directory. Default: templates/system_prompt.yaml.
+	SystemPromptPath string
+
+	// ScheduleRequiredFields are the quote fields (JSON names) that must be
+	// known before an LLM "schedule" is honored with the booking link;
+	// otherwise it is downgraded to "continue". Default: address,inventory.
+	ScheduleRequiredFields []string
+
+	// BusinessHours, from BUSINESS_HOURS ("09:00-18:00") in
+	// BUSINESS_HOURS_TZ (default UTC), is when staff pick up handoffs. A
+	// handoff outside it tells the customer when to expect a reply. Unset
+	// means always open.
+	BusinessHours BusinessHours
+
+	// PromptVariantsDir, when set, loads every *.yaml in it as a system
+	// prompt variant for A/B tests instead of the single default prompt.
+	// Each conversation is pinned to one variant. Optional.
+	PromptVariantsDir string
 }
 
-// Load reads all required environment variables. Fails fast if any are missing.
+// OverCapacityAction values.
+const (
+	OverCapacityQueue = "queue"
+	OverCapacityClose = "close"
+)
+
+// OversizeMessageAction values.
+const (
+	OversizeTruncate = "truncate"
+	OversizeReject   = "reject"
+)
+
+// EmptyMessageAction values.
+const (
+	EmptyMessageReply  = "reply"
+	EmptyMessageIgnore = "ignore"
+)
+
+// LLMProvider values.
+const (
+	LLMProviderDeepSeek = "deepseek"
+	LLMProviderOpenAI   = "openai"
+)
+
+// PendingApprovalBehavior values.
+const (
+	PendingApprovalQueue  = "queue"
+	PendingApprovalUpdate = "update"
+)
+
+// Load reads all required environment variables, falling back to an optional
+// .env file (see loadEnvFile). Fails fast if any are missing.
 func Load() (*Config, error) {
+	var err error
+
+	if err := loadEnvFile(); err != nil {
+		return nil, err
+	}
+
 	dbPath := os.Getenv("DB_PATH")
 	if dbPath == "" {
 		dbPath = "/data/db.sqlite" // default: Docker volume path
@@ -35,16 +317,241 @@ func Load() (*Config, error) {
 		DeepSeekAPIKey:     os.Getenv("DEEPSEEK_API_KEY"),
 		SlackWebhookURL:    os.Getenv("SLACK_WEBHOOK_URL"),
 		SlackSigningSecret: os.Getenv("SLACK_SIGNING_SECRET"),
+		SlackBotToken:      os.Getenv("SLACK_BOT_TOKEN"),
+		SlackChannel:       os.Getenv("SLACK_CHANNEL"),
+		BookingURL:         os.Getenv("BOOKING_URL"),
+		AdminToken:         os.Getenv("ADMIN_TOKEN"),
+		BaseURL:            strings.TrimRight(os.Getenv("BASE_URL"), "/"),
+		SystemPromptPath:   resolveBesideExecutable(envString("SYSTEM_PROMPT_PATH", "templates/system_prompt.yaml")),
+		PromptVariantsDir:  os.Getenv("PROMPT_VARIANTS_DIR"),
+		TemplateLanguage:   envString("WHATSAPP_TEMPLATE_LANGUAGE", "en"),
+		SenderFooter:       envString("WHATSAPP_FOOTER", "— ClearoutSpaces Assistant"),
+	}
+
+	if c.DryRun, err = envBool("DRY_RUN", false); err != nil {
+		return nil, err
+	}
+	if c.SanitizeReplies, err = envBool("REPLY_SANITIZE", true); err != nil {
+		return nil, err
+	}
+	if c.LanguageLock, err = envBool("LANGUAGE_LOCK", false); err != nil {
+		return nil, err
+	}
+	if c.LLMStream, err = envBool("LLM_STREAM", false); err != nil {
+		return nil, err
+	}
+	if c.LLMJSONSchema, err = envBool("LLM_JSON_SCHEMA", false); err != nil {
+		return nil, err
+	}
+	if c.LLMDedupe, err = envBool("LLM_DEDUPE", true); err != nil {
+		return nil, err
+	}
+	if c.TypingIndicator, err = envBool("WHATSAPP_TYPING_INDICATOR", false); err != nil {
+		return nil, err
+	}
+	if c.SenderFooterEnabled, err = envBool("WHATSAPP_FOOTER_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if c.LogLevel, err = logging.ParseLevel(os.Getenv("LOG_LEVEL")); err != nil {
+		return nil, err
+	}
+	if c.DebounceWindow, err = envDuration("DEBOUNCE_WINDOW", 4*time.Second); err != nil {
+		return nil, err
+	}
+	if c.RateLimitPerMinute, err = envInt("RATE_LIMIT_PER_MINUTE", 10); err != nil {
+		return nil, err
+	}
+	if c.RateLimitBurst, err = envInt("RATE_LIMIT_BURST", c.RateLimitPerMinute); err != nil {
+		return nil, err
+	}
+	if c.MessageMaxFutureSkew, err = envDuration("MESSAGE_MAX_FUTURE_SKEW", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if c.MessageMaxAge, err = envDuration("MESSAGE_MAX_AGE", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if c.MaxMessageChars, err = envInt("MAX_MESSAGE_CHARS", 4000); err != nil {
+		return nil, err
+	}
+	c.OversizeMessageAction = envString("OVERSIZE_MESSAGE_ACTION", OversizeTruncate)
+	if c.OversizeMessageAction != OversizeTruncate && c.OversizeMessageAction != OversizeReject {
+		return nil, fmt.Errorf("invalid OVERSIZE_MESSAGE_ACTION %q: must be %q or %q", c.OversizeMessageAction, OversizeTruncate, OversizeReject)
+	}
+	c.EmptyMessageAction = envString("EMPTY_MESSAGE_ACTION", EmptyMessageReply)
+	if c.EmptyMessageAction != EmptyMessageReply && c.EmptyMessageAction != EmptyMessageIgnore {
+		return nil, fmt.Errorf("invalid EMPTY_MESSAGE_ACTION %q: must be %q or %q", c.EmptyMessageAction, EmptyMessageReply, EmptyMessageIgnore)
+	}
+	if c.MaxActivePerSource, err = envInt("MAX_ACTIVE_CONVERSATIONS_PER_SOURCE", 0); err != nil {
+		return nil, err
+	}
+	if c.ActiveWindow, err = envDuration("ACTIVE_CONVERSATION_WINDOW", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	c.OverCapacityAction = envString("OVER_CAPACITY_ACTION", OverCapacityQueue)
+	if c.OverCapacityAction != OverCapacityQueue && c.OverCapacityAction != OverCapacityClose {
+		return nil, fmt.Errorf("invalid OVER_CAPACITY_ACTION %q: must be %q or %q", c.OverCapacityAction, OverCapacityQueue, OverCapacityClose)
+	}
+	if c.DBMaxOpenConns, err = envInt("DB_MAX_OPEN_CONNS", 1); err != nil {
+		return nil, err
+	}
+	if c.DBMaxOpenConns < 1 {
+		return nil, fmt.Errorf("invalid DB_MAX_OPEN_CONNS %d: must be at least 1", c.DBMaxOpenConns)
+	}
+	if c.DBWriteRetries, err = envInt("DB_WRITE_RETRIES", 2); err != nil {
+		return nil, err
+	}
+	if c.DBWriteRetryDelay, err = envDuration("DB_WRITE_RETRY_DELAY", 250*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if c.DBWriteFailureThreshold, err = envInt("DB_WRITE_FAILURE_THRESHOLD", 3); err != nil {
+		return nil, err
+	}
+	if c.InboundWorkers, err = envInt("INBOUND_WORKERS", 8); err != nil {
+		return nil, err
+	}
+	if c.InboundWorkers < 1 {
+		return nil, fmt.Errorf("invalid INBOUND_WORKERS %d: must be at least 1", c.InboundWorkers)
+	}
+	if c.InboundQueueSize, err = envInt("INBOUND_QUEUE_SIZE", 200); err != nil {
+		return nil, err
+	}
+	if c.InboundQueueSize < 1 {
+		return nil, fmt.Errorf("invalid INBOUND_QUEUE_SIZE %d: must be at least 1", c.InboundQueueSize)
+	}
+	if c.OutboundQueue, err = envBool("OUTBOUND_QUEUE", true); err != nil {
+		return nil, err
+	}
+	if c.OutboundMaxAttempts, err = envInt("OUTBOUND_MAX_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	if c.OutboundMaxAttempts < 1 {
+		return nil, fmt.Errorf("invalid OUTBOUND_MAX_ATTEMPTS %d: must be at least 1", c.OutboundMaxAttempts)
+	}
+	if c.OutboundRetryBaseDelay, err = envDuration("OUTBOUND_RETRY_BASE_DELAY", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if c.LLMMaxAttempts, err = envInt("LLM_MAX_ATTEMPTS", 3); err != nil {
+		return nil, err
+	}
+	if c.LLMRetryBaseDelay, err = envDuration("LLM_RETRY_BASE_DELAY", 500*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if c.LLMTimeout, err = envDuration("LLM_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if c.LLMTimeout <= 0 {
+		return nil, fmt.Errorf("invalid LLM_TIMEOUT %s: must be positive", c.LLMTimeout)
+	}
+	// A wait shorter than one LLM call would leave every follow-up sent
+	// while the bot is answering without a reply.
+	if c.LockTimeout, err = envDuration("CONVERSATION_LOCK_TIMEOUT", defaultLockTimeout(c.LLMTimeout)); err != nil {
+		return nil, err
+	}
+	if c.LockTimeout > 0 && c.LockTimeout <= c.LLMTimeout+llmCallMargin {
+		return nil, fmt.Errorf("invalid CONVERSATION_LOCK_TIMEOUT %s: must exceed the LLM call deadline (LLM_TIMEOUT + %s)", c.LockTimeout, llmCallMargin)
+	}
+	if c.HistoryLimit, err = envInt("HISTORY_LIMIT", 20); err != nil {
+		return nil, err
+	}
+	if c.HistoryLimit < 1 {
+		return nil, fmt.Errorf("invalid HISTORY_LIMIT %d: must be at least 1", c.HistoryLimit)
+	}
+	if c.LLMMaxPromptTokens, err = envInt("LLM_MAX_PROMPT_TOKENS", 8000); err != nil {
+		return nil, err
 	}
+	if c.LLMSummarize, err = envBool("LLM_SUMMARIZE", false); err != nil {
+		return nil, err
+	}
+	if c.LLMSummarizeAfter, err = envInt("LLM_SUMMARIZE_AFTER", 40); err != nil {
+		return nil, err
+	}
+	if c.LLMSummarize && c.LLMSummarizeAfter <= c.HistoryLimit {
+		return nil, fmt.Errorf("invalid LLM_SUMMARIZE_AFTER %d: must be greater than HISTORY_LIMIT (%d)", c.LLMSummarizeAfter, c.HistoryLimit)
+	}
+	if c.HandoffAfterTurns, err = envInt("HANDOFF_AFTER_TURNS", 8); err != nil {
+		return nil, err
+	}
+	if c.HandoffAfterTurns < 0 {
+		return nil, fmt.Errorf("invalid HANDOFF_AFTER_TURNS %d: must not be negative", c.HandoffAfterTurns)
+	}
+	if c.AutoResumeAfter, err = envDuration("AUTO_RESUME_AFTER", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if c.AutoResumeInterval, err = envDuration("AUTO_RESUME_INTERVAL", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if c.AutoResumeAfter > 0 && c.AutoResumeInterval <= 0 {
+		return nil, fmt.Errorf("invalid AUTO_RESUME_INTERVAL %s: must be positive", c.AutoResumeInterval)
+	}
+	if c.RetentionDays, err = envInt("RETENTION_DAYS", 0); err != nil {
+		return nil, err
+	}
+	if c.RetentionDays < 0 {
+		return nil, fmt.Errorf("invalid RETENTION_DAYS %d: must not be negative", c.RetentionDays)
+	}
+	c.DefaultCountryCode = strings.TrimPrefix(strings.TrimSpace(os.Getenv("DEFAULT_COUNTRY_CODE")), "+")
+	if c.DefaultCountryCode != "" {
+		if _, err := strconv.Atoi(c.DefaultCountryCode); err != nil || len(c.DefaultCountryCode) > 3 {
+			return nil, fmt.Errorf("invalid DEFAULT_COUNTRY_CODE %q: must be 1-3 digits", c.DefaultCountryCode)
+		}
+	}
+	c.PendingApprovalBehavior = envString("PENDING_APPROVAL_BEHAVIOR", PendingApprovalQueue)
+	if c.PendingApprovalBehavior != PendingApprovalQueue && c.PendingApprovalBehavior != PendingApprovalUpdate {
+		return nil, fmt.Errorf("invalid PENDING_APPROVAL_BEHAVIOR %q: must be %q or %q", c.PendingApprovalBehavior, PendingApprovalQueue, PendingApprovalUpdate)
+	}
+
+	c.ScheduleRequiredFields = splitList(envString("SCHEDULE_REQUIRED_FIELDS", "address,inventory"), ",")
+	known := models.ExtractedData{}.Fields()
+	for _, f := range c.ScheduleRequiredFields {
+		if _, ok := known[f]; !ok {
+			return nil, fmt.Errorf("invalid SCHEDULE_REQUIRED_FIELDS entry %q: must be address, elevator_access, stairs or inventory", f)
+		}
+	}
+	if c.BusinessHours, err = parseBusinessHours(os.Getenv("BUSINESS_HOURS"), envString("BUSINESS_HOURS_TZ", "UTC")); err != nil {
+		return nil, err
+	}
+
+	for _, expr := range splitList(os.Getenv("REPLY_BLOCKED_PATTERNS"), ";") {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REPLY_BLOCKED_PATTERNS entry %q: %w", expr, err)
+		}
+		c.ReplyBlockedPatterns = append(c.ReplyBlockedPatterns, re)
+	}
+
+	if c.Allowlist, err = parsePhoneList("ALLOWLIST", os.Getenv("ALLOWLIST")); err != nil {
+		return nil, err
+	}
+	if c.Blocklist, err = parsePhoneList("BLOCKLIST", os.Getenv("BLOCKLIST")); err != nil {
+		return nil, err
+	}
+	if c.SlackWebhookRoutes, err = parseSlackRoutes(os.Getenv("SLACK_WEBHOOK_ROUTES")); err != nil {
+		return nil, err
+	}
+
+	c.LLMProvider = envString("LLM_PROVIDER", LLMProviderDeepSeek)
+	c.OpenAIAPIKey = os.Getenv("OPENAI_API_KEY")
+	c.OpenAIBaseURL = envString("OPENAI_BASE_URL", "https://api.openai.com/v1")
+	c.OpenAIModel = envString("OPENAI_MODEL", "gpt-4o-mini")
 
 	required := map[string]string{
 		"META_VERIFY_TOKEN":    c.MetaVerifyToken,
 		"META_APP_SECRET":      c.MetaAppSecret,
 		"META_ACCESS_TOKEN":    c.MetaAccessToken,
 		"META_PHONE_NUMBER_ID": c.MetaPhoneNumberID,
-		"DEEPSEEK_API_KEY":     c.DeepSeekAPIKey,
 		"SLACK_WEBHOOK_URL":    c.SlackWebhookURL,
 		"SLACK_SIGNING_SECRET": c.SlackSigningSecret,
+		"BOOKING_URL":          c.BookingURL,
+	}
+	switch c.LLMProvider {
+	case LLMProviderDeepSeek:
+		required["DEEPSEEK_API_KEY"] = c.DeepSeekAPIKey
+		c.LLMAPIKey = c.DeepSeekAPIKey
+	case LLMProviderOpenAI:
+		required["OPENAI_API_KEY"] = c.OpenAIAPIKey
+		c.LLMAPIKey = c.OpenAIAPIKey
+	default:
+		return nil, fmt.Errorf("invalid LLM_PROVIDER %q: must be %q or %q", c.LLMProvider, LLMProviderDeepSeek, LLMProviderOpenAI)
 	}
 
 	for key, val := range required {
@@ -52,6 +559,259 @@ func Load() (*Config, error) {
 			return nil, fmt.Errorf("missing required environment variable: %s", key)
 		}
 	}
+	if u, err := url.Parse(c.BookingURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid BOOKING_URL %q: must be an absolute http(s) URL", c.BookingURL)
+	}
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid BASE_URL %q: must be an absolute http(s) URL", c.BaseURL)
+		}
+	}
+	if err := checkFormats(c); err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
+
+// Reloadable is the part of Config that can change while running, on a
+// SIGHUP. Everything else — secrets, paths, ports, worker pools — stays as
+// loaded until a restart.
+type Reloadable struct {
+	LogLevel           slog.Level
+	LLMMaxAttempts     int
+	LLMRetryBaseDelay  time.Duration
+	LLMTimeout         time.Duration
+	LLMMaxPromptTokens int
+	LLMJSONSchema      bool
+	LLMDedupe          bool
+}
+
+// Reloadable returns c's reloadable settings.
+func (c *Config) Reloadable() Reloadable {
+	return Reloadable{
+		LogLevel:           c.LogLevel,
+		LLMMaxAttempts:     c.LLMMaxAttempts,
+		LLMRetryBaseDelay:  c.LLMRetryBaseDelay,
+		LLMTimeout:         c.LLMTimeout,
+		LLMMaxPromptTokens: c.LLMMaxPromptTokens,
+		LLMJSONSchema:      c.LLMJSONSchema,
+		LLMDedupe:          c.LLMDedupe,
+	}
+}
+
+// Changes describes each setting that differs from prev as
+// "VAR: old -> new", for logging a reload.
+func (r Reloadable) Changes(prev Reloadable) []string {
+	var changes []string
+	diff := func(key string, old, cur any) {
+		if old != cur {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", key, old, cur))
+		}
+	}
+	diff("LOG_LEVEL", prev.LogLevel, r.LogLevel)
+	diff("LLM_MAX_ATTEMPTS", prev.LLMMaxAttempts, r.LLMMaxAttempts)
+	diff("LLM_RETRY_BASE_DELAY", prev.LLMRetryBaseDelay, r.LLMRetryBaseDelay)
+	diff("LLM_TIMEOUT", prev.LLMTimeout, r.LLMTimeout)
+	diff("LLM_MAX_PROMPT_TOKENS", prev.LLMMaxPromptTokens, r.LLMMaxPromptTokens)
+	diff("LLM_JSON_SCHEMA", prev.LLMJSONSchema, r.LLMJSONSchema)
+	diff("LLM_DEDUPE", prev.LLMDedupe, r.LLMDedupe)
+	return changes
+}
+
+// minSecretLen is the shortest signing secret accepted; Meta and Slack both
+// issue 32-character secrets, so anything much shorter is a paste error.
+const minSecretLen = 16
+
+// checkFormats catches values that are present but can't be right, such as
+// a Slack bot token pasted in place of the webhook URL. Errors name the
+// variable but never echo secrets.
+func checkFormats(c *Config) error {
+	if u, err := url.Parse(c.SlackWebhookURL); err != nil || u.Scheme != "https" || u.Host != "hooks.slack.com" {
+		return fmt.Errorf("invalid SLACK_WEBHOOK_URL: must be an https://hooks.slack.com/... incoming webhook URL")
+	}
+	for key, secret := range map[string]string{
+		"META_APP_SECRET":      c.MetaAppSecret,
+		"SLACK_SIGNING_SECRET": c.SlackSigningSecret,
+	} {
+		if len(secret) < minSecretLen {
+			return fmt.Errorf("invalid %s: too short (%d characters, want at least %d)", key, len(secret), minSecretLen)
+		}
+	}
+	if c.SlackBotToken != "" {
+		if !strings.HasPrefix(c.SlackBotToken, "xoxb-") {
+			return fmt.Errorf("invalid SLACK_BOT_TOKEN: must be a bot token (xoxb-...)")
+		}
+		if c.SlackChannel == "" {
+			return fmt.Errorf("missing SLACK_CHANNEL: required with SLACK_BOT_TOKEN")
+		}
+		// Threaded handoffs all go to SLACK_CHANNEL; routes would be ignored.
+		if len(c.SlackWebhookRoutes) > 0 {
+			return fmt.Errorf("invalid SLACK_WEBHOOK_ROUTES: not supported with SLACK_BOT_TOKEN")
+		}
+	}
+	if _, err := strconv.ParseUint(c.MetaPhoneNumberID, 10, 64); err != nil {
+		return fmt.Errorf("invalid META_PHONE_NUMBER_ID %q: must be numeric", c.MetaPhoneNumberID)
+	}
+	return nil
+}
+
+// parseSlackRoutes parses "416:https://…,604:https://…" into routes sorted
+// longest prefix first so "4165" wins over "416".
+func parseSlackRoutes(raw string) ([]SlackRoute, error) {
+	var routes []SlackRoute
+	for _, entry := range splitList(raw, ",") {
+		prefix, hook, ok := strings.Cut(entry, ":")
+		prefix = strings.TrimSpace(prefix)
+		hook = strings.TrimSpace(hook)
+		if _, err := strconv.Atoi(prefix); !ok || err != nil || strings.HasPrefix(prefix, "-") {
+			return nil, fmt.Errorf("invalid SLACK_WEBHOOK_ROUTES entry %q: want <digits>:<url>", entry)
+		}
+		// Same rule as SLACK_WEBHOOK_URL; the URL is a secret, so only the
+		// prefix is echoed.
+		if u, err := url.Parse(hook); err != nil || u.Scheme != "https" || u.Host != "hooks.slack.com" {
+			return nil, fmt.Errorf("invalid SLACK_WEBHOOK_ROUTES entry for %q: must be an https://hooks.slack.com/... incoming webhook URL", prefix)
+		}
+		routes = append(routes, SlackRoute{Prefix: prefix, URL: hook})
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].Prefix) > len(routes[j].Prefix) })
+	return routes, nil
+}
+
+// parseBusinessHours parses "09:00-18:00" in the IANA zone tz. An empty
+// spec means always open.
+func parseBusinessHours(spec, tz string) (BusinessHours, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return BusinessHours{}, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return BusinessHours{}, fmt.Errorf("invalid BUSINESS_HOURS_TZ %q: %w", tz, err)
+	}
+	open, closing, ok := strings.Cut(spec, "-")
+	h := BusinessHours{Location: loc}
+	if ok {
+		h.Open, ok = parseClock(open)
+	}
+	if ok {
+		h.Close, ok = parseClock(closing)
+	}
+	if !ok || h.Open == h.Close {
+		return BusinessHours{}, fmt.Errorf("invalid BUSINESS_HOURS %q: want HH:MM-HH:MM with different times", spec)
+	}
+	return h, nil
+}
+
+// parseClock parses "HH:MM" as an offset from midnight.
+func parseClock(s string) (time.Duration, bool) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, true
+}
+
+// envBool reads a boolean environment variable, returning def when unset.
+func envBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return b, nil
+}
+
+// envString reads an environment variable, returning def when unset.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt reads an integer environment variable, returning def when unset.
+func envInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// llmCallMargin is the margin llm.CallTimeout adds to LLM_TIMEOUT.
+const llmCallMargin = 5 * time.Second
+
+// defaultLockTimeout covers a summary call and a reply call at their full
+// deadline, plus 20s for the WhatsApp and Slack posts: 90s at the default
+// LLM_TIMEOUT.
+func defaultLockTimeout(llmTimeout time.Duration) time.Duration {
+	return 2*(llmTimeout+llmCallMargin) + 20*time.Second
+}
+
+// envDuration reads a Go duration (e.g. "90s", "24h"), returning def when unset.
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// executable locates the running binary. A var so tests can stand in a
+// directory of their own.
+var executable = os.Executable
+
+// resolveBesideExecutable resolves a relative path against the directory
+// holding the binary, so files shipped next to it are found whatever the
+// working directory. If nothing is there (e.g. under go run) the path is left
+// relative to the working directory.
+func resolveBesideExecutable(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	exe, err := executable()
+	if err != nil {
+		return path
+	}
+	candidate := filepath.Join(filepath.Dir(exe), path)
+	if _, err := os.Stat(candidate); err != nil {
+		return path
+	}
+	return candidate
+}
+
+// parsePhoneList parses comma-separated E.164 numbers, dropping the "+".
+func parsePhoneList(key, raw string) ([]string, error) {
+	var phones []string
+	for _, entry := range splitList(raw, ",") {
+		phone := strings.TrimPrefix(entry, "+")
+		if _, err := strconv.ParseUint(phone, 10, 64); err != nil || len(phone) < 8 || len(phone) > 15 {
+			return nil, fmt.Errorf("invalid %s entry %q: want an E.164 number like +14165551234", key, entry)
+		}
+		phones = append(phones, phone)
+	}
+	return phones, nil
+}
+
+// splitList splits a separated list, trimming whitespace and dropping blanks.
+func splitList(raw, sep string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
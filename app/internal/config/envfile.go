@@ -0,0 +1,69 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// defaultEnvFile is read when ENV_FILE is unset; it's fine for it to be missing.
+const defaultEnvFile = ".env"
+
+// fromEnvFile records the variables loadEnvFile set, which a later call (a
+// config reload) may update from the file again.
+var fromEnvFile = map[string]bool{}
+
+// loadEnvFile fills environment variables that are unset or empty from the
+// KEY=VALUE file named by ENV_FILE (default .env). Variables already set in
+// the real environment always win. A missing default file is not an error;
+// a missing ENV_FILE is.
+func loadEnvFile() error {
+	path, explicit := os.LookupEnv("ENV_FILE")
+	if !explicit || path == "" {
+		path, explicit = defaultEnvFile, false
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("env file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("env file %s:%d: want KEY=VALUE", path, n)
+		}
+		if os.Getenv(key) != "" && !fromEnvFile[key] {
+			continue
+		}
+		if err := os.Setenv(key, unquote(strings.TrimSpace(val))); err != nil {
+			return fmt.Errorf("env file %s:%d: %w", path, n, err)
+		}
+		fromEnvFile[key] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("env file %s: %w", path, err)
+	}
+	return nil
+}
+
+// unquote strips one pair of matching surrounding quotes.
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
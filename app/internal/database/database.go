@@ -2,34 +2,66 @@ package database
 
 import (
 	"database/sql"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 
+	"clearoutspaces/internal/logging"
 	"clearoutspaces/internal/models"
 )
 
 type DB struct {
-	conn *sql.DB
+	conn      *sql.DB
+	memory    bool // ":memory:": every connection would be a separate database
+	closeOnce sync.Once
+
+	writeMu     sync.Mutex
+	policy      WritePolicy
+	failures    int   // consecutive persistent write failures
+	degradedErr error // non-nil while writes are persistently failing
+}
+
+// WritePolicy controls how persistent write failures (disk full, read-only
+// database, I/O errors) are retried and escalated. Transient lock contention
+// (SQLITE_BUSY/LOCKED) never counts towards degradation.
+type WritePolicy struct {
+	Retries    int           // extra attempts for a write failing persistently
+	RetryDelay time.Duration // pause between attempts
+	Threshold  int           // consecutive failed writes before degrading
+	OnDegraded func(error)   // called once each time the DB becomes degraded
 }
 
+// ErrConversationNotFound is returned when no conversation exists for a phone
+// number.
+var ErrConversationNotFound = errors.New("database: conversation not found")
+
+// DefaultWritePolicy degrades after three consecutive failed writes.
+var DefaultWritePolicy = WritePolicy{Threshold: 3}
+
 // Init opens the SQLite database, applies WAL mode, and runs migrations.
 func Init(path string) *DB {
 	conn, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on")
 	if err != nil {
-		log.Fatalf("database: failed to open: %v", err)
+		logging.Fatal("database: failed to open", "err", err)
 	}
 	if err := conn.Ping(); err != nil {
-		log.Fatalf("database: failed to ping: %v", err)
+		logging.Fatal("database: failed to ping", "err", err)
 	}
 
-	// Limit concurrent writers to avoid SQLITE_BUSY beyond the busy_timeout.
+	// One connection by default; see SetMaxOpenConns.
 	conn.SetMaxOpenConns(1)
 
-	db := &DB{conn: conn}
+	db := &DB{conn: conn, memory: path == ":memory:", policy: DefaultWritePolicy}
 	db.migrate()
-	log.Println("database: ready")
+	slog.Info("database: ready", "path", path)
 	return db
 }
 
@@ -54,103 +86,1857 @@ conversation_id TEXT PRIMARY KEY,
 json_dump       TEXT,
 updated_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
 FOREIGN KEY(conversation_id) REFERENCES conversations(id)
+)`,
+		`CREATE TABLE IF NOT EXISTS pending_replies (
+conversation_id    TEXT PRIMARY KEY,
+trigger_message_id TEXT NOT NULL,
+reply              TEXT NOT NULL,
+created_at         DATETIME DEFAULT CURRENT_TIMESTAMP,
+updated_at         DATETIME DEFAULT CURRENT_TIMESTAMP,
+FOREIGN KEY(conversation_id) REFERENCES conversations(id)
+)`,
+		`CREATE TABLE IF NOT EXISTS outbound_failures (
+id              INTEGER PRIMARY KEY AUTOINCREMENT,
+conversation_id TEXT NOT NULL,
+body            TEXT NOT NULL,
+status_code     INTEGER NOT NULL DEFAULT 0,
+error           TEXT,
+created_at      DATETIME DEFAULT CURRENT_TIMESTAMP
+)`,
+		`CREATE TABLE IF NOT EXISTS outbound_messages (
+id              INTEGER PRIMARY KEY AUTOINCREMENT,
+conversation_id TEXT NOT NULL,
+body            TEXT NOT NULL,
+status          TEXT NOT NULL DEFAULT 'pending',
+attempts        INTEGER NOT NULL DEFAULT 0,
+next_attempt_at DATETIME NOT NULL,
+last_error      TEXT,
+created_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+updated_at      DATETIME DEFAULT CURRENT_TIMESTAMP
+)`,
+		`CREATE INDEX IF NOT EXISTS idx_outbound_due ON outbound_messages(status, next_attempt_at)`,
+		`CREATE TABLE IF NOT EXISTS message_status (
+wamid              TEXT PRIMARY KEY,
+status             TEXT NOT NULL,
+wa_conversation_id TEXT,
+pricing_category   TEXT,
+updated_at         DATETIME DEFAULT CURRENT_TIMESTAMP
+)`,
+		`CREATE TABLE IF NOT EXISTS conversation_notes (
+id              INTEGER PRIMARY KEY AUTOINCREMENT,
+conversation_id TEXT NOT NULL,
+author          TEXT NOT NULL,
+note            TEXT NOT NULL,
+created_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+FOREIGN KEY(conversation_id) REFERENCES conversations(id)
+)`,
+		`CREATE TABLE IF NOT EXISTS archived_messages (
+id               TEXT PRIMARY KEY,
+conversation_id  TEXT NOT NULL,
+role             TEXT NOT NULL,
+content          TEXT NOT NULL,
+created_at       DATETIME,
+sent_at          DATETIME,
+raw_llm_response TEXT,
+action           TEXT,
+archived_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+FOREIGN KEY(conversation_id) REFERENCES conversations(id)
+)`,
+		`CREATE TABLE IF NOT EXISTS webhook_events (
+id              INTEGER PRIMARY KEY AUTOINCREMENT,
+raw_body        TEXT NOT NULL,
+signature_valid INTEGER NOT NULL,
+received_at     DATETIME DEFAULT CURRENT_TIMESTAMP
+)`,
+		`CREATE TABLE IF NOT EXISTS deferred_inbound (
+id         INTEGER PRIMARY KEY AUTOINCREMENT,
+raw_body   TEXT NOT NULL,
+created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`,
+		`CREATE TABLE IF NOT EXISTS inbound_types (
+wamid       TEXT PRIMARY KEY,
+type        TEXT NOT NULL,
+received_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`,
+		`CREATE TABLE IF NOT EXISTS llm_usage (
+conversation_id   TEXT PRIMARY KEY,
+calls             INTEGER NOT NULL DEFAULT 0,
+prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+completion_tokens INTEGER NOT NULL DEFAULT 0,
+updated_at        DATETIME DEFAULT CURRENT_TIMESTAMP,
+FOREIGN KEY(conversation_id) REFERENCES conversations(id)
 )`,
 	}
 
 	for _, stmt := range migrations {
 		if _, err := db.conn.Exec(stmt); err != nil {
-			log.Fatalf("database: migration failed: %v", err)
+			logging.Fatal("database: migration failed", "err", err)
+		}
+	}
+
+	// Additive column migrations. SQLite has no ADD COLUMN IF NOT EXISTS, so
+	// each column is only added when missing from the table. This list is
+	// frozen at the schema versioned migrations started from; add new
+	// columns to versionedMigrations instead.
+	columns := []struct{ table, column, decl string }{
+		{"conversations", "language", "TEXT"},
+		{"messages", "sent_at", "DATETIME"},
+		{"conversations", "source_id", "TEXT"},
+		{"conversations", "paused_by", "TEXT"},
+		{"conversations", "paused_at", "DATETIME"},
+		{"messages", "raw_llm_response", "TEXT"},
+		{"conversations", "display_name", "TEXT"},
+		{"conversations", "stage", "TEXT NOT NULL DEFAULT 'NEW'"},
+		{"messages", "action", "TEXT"},
+		{"conversations", "handoff_failed_at", "DATETIME"},
+		{"conversations", "slack_thread_ts", "TEXT"},
+		{"conversations", "prompt_variant", "TEXT"},
+		{"message_status", "error_code", "INTEGER"},
+		{"message_status", "error_title", "TEXT"},
+		{"conversations", "summary", "TEXT"},
+		{"conversations", "summary_through", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := db.addColumnIfMissing(c.table, c.column, c.decl); err != nil {
+			logging.Fatal("database: migration failed", "err", err)
+		}
+	}
+
+	if err := db.applyMigrations(versionedMigrations); err != nil {
+		logging.Fatal("database: migration failed", "err", err)
+	}
+}
+
+// migration is a schema change applied exactly once, recorded by version in
+// schema_migrations.
+type migration struct {
+	version int
+	name    string
+	stmts   []string
+}
+
+// versionedMigrations run once each, in order, after the idempotent tables
+// and columns above. Every new column, index, backfill or table rebuild goes
+// here. Append with the next version; never edit or renumber one that has
+// shipped.
+var versionedMigrations = []migration{
+	{1, "index messages by conversation", []string{
+		`CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, created_at)`,
+	}},
+	{2, "record forwarded messages", []string{
+		`ALTER TABLE messages ADD COLUMN forwarded TEXT`,
+		`ALTER TABLE archived_messages ADD COLUMN forwarded TEXT`,
+	}},
+	{3, "add conversation flags", []string{
+		`ALTER TABLE conversations ADD COLUMN flags TEXT`, // JSON array
+	}},
+	{4, "add conversation timezone", []string{
+		`ALTER TABLE conversations ADD COLUMN timezone TEXT`,
+	}},
+	{5, "record message wamids and reply targets", []string{
+		`ALTER TABLE messages ADD COLUMN reply_to TEXT`,
+		`ALTER TABLE messages ADD COLUMN wamid TEXT`,
+		`ALTER TABLE archived_messages ADD COLUMN reply_to TEXT`,
+		`ALTER TABLE archived_messages ADD COLUMN wamid TEXT`,
+		`ALTER TABLE outbound_messages ADD COLUMN message_id TEXT`,
+		`ALTER TABLE outbound_messages ADD COLUMN wamid TEXT`,
+	}},
+	{6, "keep trace ids on deferred inbound", []string{
+		`ALTER TABLE deferred_inbound ADD COLUMN trace_id TEXT`,
+	}},
+	{7, "keep trace ids on queued replies", []string{
+		`ALTER TABLE outbound_messages ADD COLUMN trace_id TEXT`,
+	}},
+	{8, "add conversation queued_at", []string{
+		`ALTER TABLE conversations ADD COLUMN queued_at DATETIME`,
+	}},
+}
+
+// applyMigrations runs each migration in list not yet recorded in
+// schema_migrations. Versions must be strictly increasing.
+func (db *DB) applyMigrations(list []migration) error {
+	if _, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+version    INTEGER PRIMARY KEY,
+name       TEXT NOT NULL,
+applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`); err != nil {
+		return err
+	}
+
+	rows, err := db.conn.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := 1; i < len(list); i++ {
+		if list[i].version <= list[i-1].version {
+			return fmt.Errorf("migration %d (%s) is out of order after %d", list[i].version, list[i].name, list[i-1].version)
+		}
+	}
+	for _, m := range list {
+		if applied[m.version] {
+			continue
+		}
+		if err := db.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		slog.Info("database: applied migration", "version", m.version, "name", m.name)
+	}
+	return nil
+}
+
+// applyMigration runs m and records it in one transaction, so a failed
+// migration leaves nothing behind and is retried on the next start.
+func (db *DB) applyMigration(m migration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations(version, name) VALUES(?, ?)`, m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) addColumnIfMissing(table, column, decl string) error {
+	rows, err := db.conn.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.conn.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + decl)
+	return err
+}
+
+// SetWritePolicy replaces the persistent write failure policy.
+func (db *DB) SetWritePolicy(p WritePolicy) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.policy = p
+}
+
+// SetMaxOpenConns sets the connection pool size. With WAL, readers on extra
+// connections don't block each other or the writer; concurrent writes still
+// serialize in SQLite, waiting up to the busy_timeout. In-memory databases
+// always stay at one connection.
+func (db *DB) SetMaxOpenConns(n int) {
+	if n < 1 || db.memory {
+		n = 1
+	}
+	db.conn.SetMaxOpenConns(n)
+	db.conn.SetMaxIdleConns(n)
+}
+
+// Stats returns the connection pool statistics.
+func (db *DB) Stats() sql.DBStats {
+	return db.conn.Stats()
+}
+
+// Close checkpoints the WAL into the main database file, so nothing is left
+// only in the -wal file, then closes the connection pool. Calls after the
+// first do nothing and return nil.
+func (db *DB) Close() error {
+	var err error
+	db.closeOnce.Do(func() {
+		if !db.memory {
+			if _, cerr := db.conn.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); cerr != nil {
+				err = fmt.Errorf("database: wal checkpoint: %w", cerr)
+			}
+		}
+		err = errors.Join(err, db.conn.Close())
+	})
+	return err
+}
+
+// WriteHealth returns nil while writes succeed, or the last persistent error
+// once the failure threshold has been crossed. It clears on the next
+// successful write.
+func (db *DB) WriteHealth() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	return db.degradedErr
+}
+
+// Ping verifies the database answers a trivial query.
+func (db *DB) Ping() error {
+	if err := db.conn.Ping(); err != nil {
+		return err
+	}
+	var one int
+	return db.conn.QueryRow(`SELECT 1`).Scan(&one)
+}
+
+// SetReadOnlyForTest makes every write fail with SQLITE_READONLY, as a
+// read-only mount would. Only call this from tests.
+func (db *DB) SetReadOnlyForTest(on bool) error {
+	_, err := db.conn.Exec(fmt.Sprintf(`PRAGMA query_only = %t`, on))
+	return err
+}
+
+// IsPersistentWriteError reports whether err means writes will keep failing
+// until an operator intervenes, as opposed to transient lock contention.
+func IsPersistentWriteError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrFull, sqlite3.ErrReadonly, sqlite3.ErrIoErr, sqlite3.ErrCorrupt, sqlite3.ErrCantOpen, sqlite3.ErrNotADB:
+		return true
+	}
+	return false
+}
+
+// IsDuplicateKey reports whether err is a primary key or unique constraint
+// violation, i.e. the row was already written.
+func IsDuplicateKey(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// exec runs a write statement, retrying persistent failures per the write
+// policy and tracking them so a full disk or read-only mount surfaces as a
+// degraded database rather than an endless stream of per-message errors.
+func (db *DB) exec(query string, args ...any) (sql.Result, error) {
+	db.writeMu.Lock()
+	p := db.policy
+	db.writeMu.Unlock()
+
+	res, err := db.execBusy(query, args...)
+	for attempt := 0; attempt < p.Retries && IsPersistentWriteError(err); attempt++ {
+		time.Sleep(p.RetryDelay)
+		res, err = db.execBusy(query, args...)
+	}
+	db.observeWrite(err)
+	return res, err
+}
+
+// Lock retry policy: busy_timeout covers most contention, but a WAL
+// checkpoint can still surface SQLITE_BUSY. Vars so tests can shrink them.
+var (
+	busyRetries    = 3
+	busyRetryDelay = 50 * time.Millisecond
+)
+
+// sqlExec runs one statement; a var so tests can inject lock errors.
+var sqlExec = (*sql.DB).Exec
+
+// execBusy runs a statement, retrying a few times while the database is
+// locked. The last error is returned unwrapped so callers can still match
+// the sqlite3.Error.
+func (db *DB) execBusy(query string, args ...any) (sql.Result, error) {
+	res, err := sqlExec(db.conn, query, args...)
+	for attempt := 0; attempt < busyRetries && IsBusy(err); attempt++ {
+		time.Sleep(busyRetryDelay)
+		res, err = sqlExec(db.conn, query, args...)
+	}
+	if IsBusy(err) {
+		slog.Warn("database: write still locked after retries", "retries", busyRetries, "err", err)
+	}
+	return res, err
+}
+
+// IsBusy reports whether err is transient lock contention (SQLITE_BUSY or
+// SQLITE_LOCKED).
+func IsBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+func (db *DB) observeWrite(err error) {
+	if err != nil && !IsPersistentWriteError(err) {
+		return
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	if err == nil {
+		if db.degradedErr != nil {
+			slog.Info("database: writes recovered", "event", "db_recovered")
 		}
+		db.failures = 0
+		db.degradedErr = nil
+		return
+	}
+
+	db.failures++
+	if db.degradedErr == nil && db.failures >= db.policy.Threshold {
+		db.degradedErr = err
+		slog.Error("database: DEGRADED after consecutive write failures", "failures", db.failures, "event", "db_degraded", "err", err)
+		if db.policy.OnDegraded != nil {
+			go db.policy.OnDegraded(err)
+		}
+	}
+}
+
+// sqlTime formats t like SQLite's CURRENT_TIMESTAMP (UTC, second precision)
+// so stored values compare correctly against column defaults. Zero is NULL.
+func sqlTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
 	}
+	return t.UTC().Format("2006-01-02 15:04:05")
 }
 
 // ─── Conversation ─────────────────────────────────────────────────────────────
 
 // UpsertConversation creates a conversation row if it doesn't exist.
 func (db *DB) UpsertConversation(phoneNumber string) error {
-	_, err := db.conn.Exec(
+	_, err := db.exec(
 		`INSERT INTO conversations(id) VALUES(?) ON CONFLICT(id) DO NOTHING`,
 		phoneNumber,
 	)
 	return err
 }
 
-// GetConversationStatus returns "ACTIVE" or "PAUSED".
+// GetConversationStatus returns "ACTIVE" or "PAUSED", or
+// ErrConversationNotFound if the conversation doesn't exist.
 func (db *DB) GetConversationStatus(phoneNumber string) (string, error) {
 	var status string
 	err := db.conn.QueryRow(
 		`SELECT status FROM conversations WHERE id = ?`, phoneNumber,
 	).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrConversationNotFound
+	}
 	return status, err
 }
 
-// PauseConversation sets a conversation's status to PAUSED.
-func (db *DB) PauseConversation(phoneNumber string) error {
-	_, err := db.conn.Exec(
-		`UPDATE conversations SET status = 'PAUSED', updated_at = ? WHERE id = ?`,
-		time.Now(), phoneNumber,
-	)
+// GetConversation returns the full conversation row, or
+// ErrConversationNotFound if it doesn't exist.
+func (db *DB) GetConversation(phoneNumber string) (*models.Conversation, error) {
+	c := &models.Conversation{}
+	var lang, tz, source, pausedBy, name, variant, flags sql.NullString
+	var pausedAt, handoffFailedAt sql.NullTime
+	err := db.conn.QueryRow(
+		`SELECT id, status, stage, language, timezone, source_id, paused_by, paused_at, display_name, handoff_failed_at, prompt_variant, flags, created_at, updated_at
+		 FROM conversations WHERE id = ?`, phoneNumber,
+	).Scan(&c.ID, &c.Status, &c.Stage, &lang, &tz, &source, &pausedBy, &pausedAt, &name, &handoffFailedAt, &variant, &flags, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Language, c.Timezone, c.SourceID, c.PausedBy, c.PausedAt = lang.String, tz.String, source.String, pausedBy.String, pausedAt.Time
+	c.DisplayName, c.HandoffFailedAt, c.PromptVariant = name.String, handoffFailedAt.Time, variant.String
+	if flags.Valid {
+		if err := json.Unmarshal([]byte(flags.String), &c.Flags); err != nil {
+			return nil, fmt.Errorf("decode flags: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// AddConversationFlags records business rule flags on the conversation,
+// keeping ones already there. Returns ErrConversationNotFound if missing.
+func (db *DB) AddConversationFlags(phoneNumber string, flags []string) error {
+	c, err := db.GetConversation(phoneNumber)
+	if err != nil {
+		return err
+	}
+	merged := c.Flags
+	for _, f := range flags {
+		if !slices.Contains(merged, f) {
+			merged = append(merged, f)
+		}
+	}
+	if len(merged) == len(c.Flags) {
+		return nil
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	_, err = db.exec(`UPDATE conversations SET flags = ?, updated_at = ? WHERE id = ?`, string(data), time.Now(), phoneNumber)
 	return err
 }
 
-// ─── Messages ─────────────────────────────────────────────────────────────────
+// PromptVariant returns the prompt variant the conversation is pinned to,
+// or "" if none has been assigned yet.
+func (db *DB) PromptVariant(phoneNumber string) (string, error) {
+	var variant sql.NullString
+	err := db.conn.QueryRow(
+		`SELECT prompt_variant FROM conversations WHERE id = ?`, phoneNumber,
+	).Scan(&variant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrConversationNotFound
+	}
+	return variant.String, err
+}
 
-// MessageExists checks if a wamid has already been processed (idempotency).
-func (db *DB) MessageExists(id string) (bool, error) {
-	var count int
-	err := db.conn.QueryRow(`SELECT COUNT(1) FROM messages WHERE id = ?`, id).Scan(&count)
-	return count > 0, err
+// SetPromptVariant pins the conversation to a prompt variant. Returns
+// ErrConversationNotFound if it doesn't exist.
+func (db *DB) SetPromptVariant(phoneNumber, variant string) error {
+	res, err := db.exec(
+		`UPDATE conversations SET prompt_variant = ? WHERE id = ?`,
+		variant, phoneNumber,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
 }
 
-// InsertMessage saves a single message row.
-func (db *DB) InsertMessage(m *models.Message) error {
-	_, err := db.conn.Exec(
-		`INSERT INTO messages(id, conversation_id, role, content) VALUES(?, ?, ?, ?)`,
-		m.ID, m.ConversationID, m.Role, m.Content,
+// GetSummary returns the conversation's cached history summary and the id
+// of the last message it covers, both "" if it has none.
+func (db *DB) GetSummary(phoneNumber string) (summary, throughID string, err error) {
+	var text, through sql.NullString
+	err = db.conn.QueryRow(
+		`SELECT summary, summary_through FROM conversations WHERE id = ?`, phoneNumber,
+	).Scan(&text, &through)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", ErrConversationNotFound
+	}
+	return text.String, through.String, err
+}
+
+// SetSummary caches a summary of the conversation's messages up to and
+// including throughID. Returns ErrConversationNotFound if it doesn't exist.
+func (db *DB) SetSummary(phoneNumber, summary, throughID string) error {
+	res, err := db.exec(
+		`UPDATE conversations SET summary = ?, summary_through = ? WHERE id = ?`,
+		summary, throughID, phoneNumber,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// MarkHandoffFailed flags a conversation whose Slack handoff failed, so it
+// shows up in ListFailedHandoffs. Returns ErrConversationNotFound if missing.
+func (db *DB) MarkHandoffFailed(phoneNumber string) error {
+	now := time.Now()
+	res, err := db.exec(
+		`UPDATE conversations SET handoff_failed_at = ?, updated_at = ? WHERE id = ?`,
+		now, now, phoneNumber,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// ClearHandoffFailed removes the flag once a handoff goes through.
+func (db *DB) ClearHandoffFailed(phoneNumber string) error {
+	_, err := db.exec(
+		`UPDATE conversations SET handoff_failed_at = NULL WHERE id = ? AND handoff_failed_at IS NOT NULL`,
+		phoneNumber,
 	)
 	return err
 }
 
-// GetRecentMessages returns the last n messages for a conversation, oldest first.
-func (db *DB) GetRecentMessages(conversationID string, limit int) ([]models.Message, error) {
+// GetSlackThreadTS returns the ts of the conversation's Slack handoff
+// thread, or "" before its first threaded handoff.
+func (db *DB) GetSlackThreadTS(phoneNumber string) (string, error) {
+	var ts sql.NullString
+	err := db.conn.QueryRow(
+		`SELECT slack_thread_ts FROM conversations WHERE id = ?`, phoneNumber,
+	).Scan(&ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrConversationNotFound
+	}
+	return ts.String, err
+}
+
+// SetSlackThreadTS records the ts of the conversation's first handoff post
+// so later handoffs reply in its thread.
+func (db *DB) SetSlackThreadTS(phoneNumber, ts string) error {
+	res, err := db.exec(
+		`UPDATE conversations SET slack_thread_ts = ? WHERE id = ?`,
+		ts, phoneNumber,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// ListFailedHandoffs returns flagged conversations, most recent failure first.
+func (db *DB) ListFailedHandoffs(limit int) ([]models.FailedHandoff, error) {
 	rows, err := db.conn.Query(
-		`SELECT id, conversation_id, role, content, created_at
-		 FROM messages
-		 WHERE conversation_id = ?
-		 ORDER BY created_at DESC, rowid DESC
+		`SELECT id, COALESCE(display_name, ''), stage, handoff_failed_at
+		 FROM conversations
+		 WHERE handoff_failed_at IS NOT NULL
+		 ORDER BY handoff_failed_at DESC
 		 LIMIT ?`,
-		conversationID, limit,
+		limit,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var msgs []models.Message
+	var handoffs []models.FailedHandoff
 	for rows.Next() {
-		var m models.Message
-		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.CreatedAt); err != nil {
+		var h models.FailedHandoff
+		if err := rows.Scan(&h.ConversationID, &h.DisplayName, &h.Stage, &h.FailedAt); err != nil {
 			return nil, err
 		}
-		msgs = append(msgs, m)
+		handoffs = append(handoffs, h)
 	}
+	return handoffs, rows.Err()
+}
 
-	// Reverse to get chronological order.
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
+// SetStage moves a conversation to a funnel stage (models.Stage*). Any valid
+// stage may be set; callers decide whether to allow moving backwards.
+func (db *DB) SetStage(phoneNumber, stage string) error {
+	if models.StageRank(stage) < 0 {
+		return fmt.Errorf("database: invalid stage %q", stage)
 	}
-	return msgs, rows.Err()
+	res, err := db.exec(
+		`UPDATE conversations SET stage = ?, updated_at = ? WHERE id = ?`,
+		stage, time.Now(), phoneNumber,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
 }
 
-// ─── Quote Data ───────────────────────────────────────────────────────────────
+// PauseConversation sets a conversation's status to PAUSED, recording who
+// took over and when.
+func (db *DB) PauseConversation(phoneNumber, pausedBy string) error {
+	now := time.Now()
+	_, err := db.exec(
+		`UPDATE conversations SET status = 'PAUSED', paused_by = ?, paused_at = ?, updated_at = ? WHERE id = ?`,
+		pausedBy, sqlTime(now), now, phoneNumber,
+	)
+	return err
+}
 
-// UpsertQuoteData saves extracted JSON data for a conversation.
-func (db *DB) UpsertQuoteData(conversationID, jsonDump string) error {
-	_, err := db.conn.Exec(
-		`INSERT INTO quote_data(conversation_id, json_dump, updated_at)
-		 VALUES(?, ?, ?)
-		 ON CONFLICT(conversation_id) DO UPDATE SET json_dump = excluded.json_dump, updated_at = excluded.updated_at`,
-		conversationID, jsonDump, time.Now(),
+// ResumeConversation hands a conversation back to the assistant (ACTIVE).
+func (db *DB) ResumeConversation(phoneNumber string) error {
+	_, err := db.exec(
+		`UPDATE conversations SET status = 'ACTIVE', updated_at = ? WHERE id = ?`,
+		time.Now(), phoneNumber,
+	)
+	return err
+}
+
+// ListStalePausedConversations returns PAUSED conversations whose status last
+// changed before olderThan. datetime() normalises the stored timestamps,
+// which may carry a local UTC offset, before comparing.
+func (db *DB) ListStalePausedConversations(olderThan time.Time) ([]string, error) {
+	rows, err := db.conn.Query(
+		`SELECT id FROM conversations
+		 WHERE status = 'PAUSED' AND datetime(updated_at) < datetime(?)
+		 ORDER BY updated_at`,
+		sqlTime(olderThan),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetConversationLanguage returns the stored language code, or "" if unset.
+func (db *DB) GetConversationLanguage(phoneNumber string) (string, error) {
+	var lang sql.NullString
+	err := db.conn.QueryRow(
+		`SELECT language FROM conversations WHERE id = ?`, phoneNumber,
+	).Scan(&lang)
+	return lang.String, err
+}
+
+// SetConversationLanguage stores the conversation's language code. An empty
+// code clears it.
+func (db *DB) SetConversationLanguage(phoneNumber, lang string) error {
+	_, err := db.exec(
+		`UPDATE conversations SET language = NULLIF(?, '') WHERE id = ?`,
+		lang, phoneNumber,
+	)
+	return err
+}
+
+// SetConversationLocale stores the conversation's language code and IANA
+// timezone. An empty value leaves the stored one unchanged.
+func (db *DB) SetConversationLocale(phoneNumber, lang, tz string) error {
+	_, err := db.exec(
+		`UPDATE conversations SET language = COALESCE(NULLIF(?, ''), language), timezone = COALESCE(NULLIF(?, ''), timezone) WHERE id = ?`,
+		lang, tz, phoneNumber,
+	)
+	return err
+}
+
+// SetConversationSource records the business number a conversation arrived
+// on. The first source sticks.
+func (db *DB) SetConversationSource(phoneNumber, sourceID string) error {
+	_, err := db.exec(
+		`UPDATE conversations SET source_id = ? WHERE id = ? AND source_id IS NULL`,
+		sourceID, phoneNumber,
+	)
+	return err
+}
+
+// SetConversationDisplayName stores the customer's WhatsApp profile name.
+// Customers can rename themselves, so the latest name wins.
+func (db *DB) SetConversationDisplayName(phoneNumber, name string) error {
+	_, err := db.exec(
+		`UPDATE conversations SET display_name = ? WHERE id = ? AND display_name IS NOT ?`,
+		name, phoneNumber, name,
+	)
+	return err
+}
+
+// CountActiveConversations counts ACTIVE conversations on sourceID that the
+// bot has replied to since the given time.
+func (db *DB) CountActiveConversations(sourceID string, since time.Time) (int, error) {
+	var count int
+	err := db.conn.QueryRow(
+		`SELECT COUNT(DISTINCT c.id)
+		 FROM conversations c
+		 JOIN messages m ON m.conversation_id = c.id
+		 WHERE c.source_id = ? AND c.status = 'ACTIVE'
+		   AND m.role = 'assistant' AND m.created_at >= ?`,
+		sourceID, sqlTime(since),
+	).Scan(&count)
+	return count, err
+}
+
+// QueueConversation marks a conversation as waiting for capacity under
+// MaxActivePerSource. Requeueing keeps its place.
+func (db *DB) QueueConversation(phoneNumber string) error {
+	_, err := db.exec(
+		`UPDATE conversations SET queued_at = COALESCE(queued_at, ?) WHERE id = ?`,
+		sqlTime(time.Now()), phoneNumber,
 	)
 	return err
 }
+
+// DequeueConversation clears the mark set by QueueConversation.
+func (db *DB) DequeueConversation(phoneNumber string) error {
+	_, err := db.exec(`UPDATE conversations SET queued_at = NULL WHERE id = ? AND queued_at IS NOT NULL`, phoneNumber)
+	return err
+}
+
+// QueuedConversations returns up to limit queued conversation ids, longest
+// waiting first.
+func (db *DB) QueuedConversations(limit int) ([]string, error) {
+	rows, err := db.conn.Query(
+		`SELECT id FROM conversations WHERE queued_at IS NOT NULL ORDER BY queued_at, id LIMIT ?`, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// HasAssistantReplySince reports whether the bot replied in the conversation
+// since the given time.
+func (db *DB) HasAssistantReplySince(phoneNumber string, since time.Time) (bool, error) {
+	var exists bool
+	err := db.conn.QueryRow(
+		`SELECT EXISTS(
+		   SELECT 1 FROM messages
+		   WHERE conversation_id = ? AND role = 'assistant' AND created_at >= ?
+		 )`,
+		phoneNumber, sqlTime(since),
+	).Scan(&exists)
+	return exists, err
+}
+
+// DuplicateConversation is a pair of conversation rows for the same number,
+// one keyed with a leading "+" from before phone numbers were normalized.
+type DuplicateConversation struct {
+	From string // the "+"-prefixed key
+	Into string // the normalized key
+}
+
+// FindDuplicateConversations lists conversations whose key is another
+// conversation's key with a leading "+".
+func (db *DB) FindDuplicateConversations() ([]DuplicateConversation, error) {
+	rows, err := db.conn.Query(
+		`SELECT a.id, b.id
+		 FROM conversations a
+		 JOIN conversations b ON b.id = ltrim(a.id, '+')
+		 WHERE a.id != b.id
+		 ORDER BY b.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dups []DuplicateConversation
+	for rows.Next() {
+		var d DuplicateConversation
+		if err := rows.Scan(&d.From, &d.Into); err != nil {
+			return nil, err
+		}
+		dups = append(dups, d)
+	}
+	return dups, rows.Err()
+}
+
+// mergedTables hold rows that simply move to the surviving conversation.
+var mergedTables = []string{"messages", "archived_messages", "conversation_notes", "outbound_failures", "outbound_messages"}
+
+// MergeConversations moves everything recorded under from onto into, in one
+// transaction, and deletes from. When both have quote data the newest wins;
+// a pending reply on into is kept over one on from, and LLM usage and flags
+// are combined. into keeps its own name, language, timezone, source, prompt
+// variant and Slack thread, taking from's only where it has none, as well as
+// its status, stage and takeover, since it is the number the customer
+// writes from now. A failed handoff or queued place on either carries over.
+// into's cached history summary is cleared since its history changed.
+// Returns ErrConversationNotFound if either conversation doesn't exist.
+func (db *DB) MergeConversations(from, into string) error {
+	if from == into {
+		return fmt.Errorf("merge %s into itself", from)
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM conversations WHERE id IN (?, ?)`, from, into).Scan(&found); err != nil {
+		return err
+	}
+	if found != 2 {
+		return ErrConversationNotFound
+	}
+
+	for _, table := range mergedTables {
+		if _, err := tx.Exec(`UPDATE `+table+` SET conversation_id = ? WHERE conversation_id = ?`, into, from); err != nil {
+			return fmt.Errorf("move %s: %w", table, err)
+		}
+	}
+
+	if err := mergeQuoteData(tx, from, into); err != nil {
+		return fmt.Errorf("merge quote data: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE OR IGNORE pending_replies SET conversation_id = ? WHERE conversation_id = ?`, into, from); err != nil {
+		return fmt.Errorf("move pending reply: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM pending_replies WHERE conversation_id = ?`, from); err != nil {
+		return fmt.Errorf("drop pending reply: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO llm_usage(conversation_id, calls, prompt_tokens, completion_tokens)
+		 SELECT ?, calls, prompt_tokens, completion_tokens FROM llm_usage WHERE conversation_id = ?
+		 ON CONFLICT(conversation_id) DO UPDATE SET
+		   calls             = calls + excluded.calls,
+		   prompt_tokens     = prompt_tokens + excluded.prompt_tokens,
+		   completion_tokens = completion_tokens + excluded.completion_tokens,
+		   updated_at        = CURRENT_TIMESTAMP`,
+		into, from,
+	); err != nil {
+		return fmt.Errorf("merge llm usage: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM llm_usage WHERE conversation_id = ?`, from); err != nil {
+		return fmt.Errorf("drop llm usage: %w", err)
+	}
+
+	if err := mergeFlags(tx, from, into); err != nil {
+		return fmt.Errorf("merge flags: %w", err)
+	}
+	if _, err := tx.Exec(
+		`UPDATE conversations AS c SET
+		   display_name      = COALESCE(c.display_name, f.display_name),
+		   language          = COALESCE(c.language, f.language),
+		   timezone          = COALESCE(c.timezone, f.timezone),
+		   source_id         = COALESCE(c.source_id, f.source_id),
+		   prompt_variant    = COALESCE(c.prompt_variant, f.prompt_variant),
+		   slack_thread_ts   = COALESCE(c.slack_thread_ts, f.slack_thread_ts),
+		   handoff_failed_at = COALESCE(c.handoff_failed_at, f.handoff_failed_at),
+		   queued_at         = COALESCE(MIN(c.queued_at, f.queued_at), c.queued_at, f.queued_at),
+		   summary           = NULL,
+		   summary_through   = NULL,
+		   updated_at        = ?
+		 FROM (SELECT * FROM conversations WHERE id = ?) AS f
+		 WHERE c.id = ?`,
+		time.Now(), from, into,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM conversations WHERE id = ?`, from); err != nil {
+		return fmt.Errorf("delete conversation: %w", err)
+	}
+	return tx.Commit()
+}
+
+// mergeFlags gives into the union of both conversations' flags, its own
+// first.
+func mergeFlags(tx *sql.Tx, from, into string) error {
+	var merged []string
+	for _, id := range []string{into, from} {
+		var raw sql.NullString
+		if err := tx.QueryRow(`SELECT flags FROM conversations WHERE id = ?`, id).Scan(&raw); err != nil {
+			return err
+		}
+		var flags []string
+		if raw.Valid {
+			if err := json.Unmarshal([]byte(raw.String), &flags); err != nil {
+				return fmt.Errorf("decode flags: %w", err)
+			}
+		}
+		for _, f := range flags {
+			if !slices.Contains(merged, f) {
+				merged = append(merged, f)
+			}
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE conversations SET flags = ? WHERE id = ?`, string(data), into)
+	return err
+}
+
+// mergeQuoteData keeps the most recently updated quote of from and into,
+// under into.
+func mergeQuoteData(tx *sql.Tx, from, into string) error {
+	var fromAt, intoAt sql.NullTime
+	if err := tx.QueryRow(`SELECT updated_at FROM quote_data WHERE conversation_id = ?`, from).Scan(&fromAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	err := tx.QueryRow(`SELECT updated_at FROM quote_data WHERE conversation_id = ?`, into).Scan(&intoAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case !fromAt.Time.After(intoAt.Time):
+		_, err = tx.Exec(`DELETE FROM quote_data WHERE conversation_id = ?`, from)
+		return err
+	default:
+		if _, err := tx.Exec(`DELETE FROM quote_data WHERE conversation_id = ?`, into); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`UPDATE quote_data SET conversation_id = ? WHERE conversation_id = ?`, into, from)
+	return err
+}
+
+// ─── Messages ─────────────────────────────────────────────────────────────────
+
+// CountMessagesByRole counts a conversation's messages from role ("user" or
+// "assistant"). Archived messages are not counted.
+func (db *DB) CountMessagesByRole(phoneNumber, role string) (int, error) {
+	var count int
+	err := db.conn.QueryRow(
+		`SELECT COUNT(*) FROM messages WHERE conversation_id = ? AND role = ?`,
+		phoneNumber, role,
+	).Scan(&count)
+	return count, err
+}
+
+// MessageExists checks if a wamid has already been processed (idempotency),
+// including messages since archived.
+func (db *DB) MessageExists(id string) (bool, error) {
+	var exists bool
+	err := db.conn.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM messages WHERE id = ?) OR EXISTS(SELECT 1 FROM archived_messages WHERE id = ?)`, id, id,
+	).Scan(&exists)
+	return exists, err
+}
+
+// ArchiveOldMessages moves messages created before the cutoff into
+// archived_messages, in one transaction, and returns how many moved.
+// Conversations and quote data are kept.
+func (db *DB) ArchiveOldMessages(before time.Time) (int, error) {
+	cutoff := sqlTime(before)
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT OR IGNORE INTO archived_messages(id, conversation_id, role, content, created_at, sent_at, raw_llm_response, action, forwarded, reply_to, wamid)
+		 SELECT id, conversation_id, role, content, created_at, sent_at, raw_llm_response, action, forwarded, reply_to, wamid
+		 FROM messages WHERE created_at < ?`, cutoff,
+	); err != nil {
+		return 0, fmt.Errorf("copy to archive: %w", err)
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE created_at < ?`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("delete archived: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), tx.Commit()
+}
+
+// InsertMessage saves a single message row.
+// The wamid falls back to one the outbound worker already recorded, in case
+// a queued reply was delivered before its message row was written.
+func (db *DB) InsertMessage(m *models.Message) error {
+	_, err := db.exec(
+		`INSERT INTO messages(id, conversation_id, role, content, sent_at, raw_llm_response, action, forwarded, reply_to, wamid)
+		 VALUES(?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''),
+		        COALESCE(NULLIF(?, ''), (SELECT wamid FROM outbound_messages WHERE message_id = ? AND wamid IS NOT NULL ORDER BY id LIMIT 1)))`,
+		m.ID, m.ConversationID, m.Role, m.Content, sqlTime(m.SentAt), m.RawLLMResponse, m.Action, m.Forwarded, m.ReplyTo, m.Wamid, m.ID,
+	)
+	return err
+}
+
+// SetMessageWamid records the wamid Meta assigned when an assistant reply
+// was sent, so a customer quoting or reacting to it can be resolved. The
+// first wamid sticks.
+func (db *DB) SetMessageWamid(id, wamid string) error {
+	_, err := db.exec(`UPDATE messages SET wamid = ? WHERE id = ? AND wamid IS NULL`, wamid, id)
+	return err
+}
+
+// ListForwardedMessages returns up to limit of the conversation's latest
+// forwarded messages, oldest first.
+func (db *DB) ListForwardedMessages(conversationID string, limit int) ([]models.Message, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, content, forwarded, sent_at, created_at
+		 FROM messages
+		 WHERE conversation_id = ? AND forwarded IS NOT NULL
+		 ORDER BY COALESCE(sent_at, created_at) DESC, rowid DESC
+		 LIMIT ?`,
+		conversationID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []models.Message
+	for rows.Next() {
+		m := models.Message{ConversationID: conversationID, Role: "user"}
+		var sentAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.Content, &m.Forwarded, &sentAt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.SentAt = sentAt.Time
+		msgs = append(msgs, m)
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, rows.Err()
+}
+
+// ActivityStats counts conversations started, messages, and conversations
+// handed off or scheduled since the given time.
+func (db *DB) ActivityStats(since time.Time) (models.ActivityStats, error) {
+	st := models.ActivityStats{Since: since.UTC()}
+	ts := sqlTime(since)
+	err := db.conn.QueryRow(
+		`SELECT
+		   (SELECT COUNT(*) FROM conversations WHERE created_at >= ?),
+		   (SELECT COUNT(*) FROM messages WHERE created_at >= ?),
+		   (SELECT COUNT(DISTINCT conversation_id) FROM messages WHERE action = 'handoff' AND created_at >= ?),
+		   (SELECT COUNT(DISTINCT conversation_id) FROM messages WHERE action = 'schedule' AND created_at >= ?)`,
+		ts, ts, ts, ts,
+	).Scan(&st.Conversations, &st.Messages, &st.Handoffs, &st.Schedules)
+	if err != nil {
+		return st, err
+	}
+	if st.MessageTypes, err = db.InboundTypeCounts(since); err != nil {
+		return st, err
+	}
+	st.Variants, err = db.VariantStats(since)
+	return st, err
+}
+
+// VariantStats counts conversations started since the given time by prompt
+// variant, with how many of each were handed off or scheduled.
+func (db *DB) VariantStats(since time.Time) (map[string]models.VariantStats, error) {
+	rows, err := db.conn.Query(
+		`SELECT c.prompt_variant, COUNT(*),
+		   SUM(EXISTS(SELECT 1 FROM messages m WHERE m.conversation_id = c.id AND m.action = 'handoff')),
+		   SUM(EXISTS(SELECT 1 FROM messages m WHERE m.conversation_id = c.id AND m.action = 'schedule'))
+		 FROM conversations c
+		 WHERE c.prompt_variant IS NOT NULL AND c.prompt_variant != '' AND c.created_at >= ?
+		 GROUP BY c.prompt_variant`,
+		sqlTime(since),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]models.VariantStats)
+	for rows.Next() {
+		var name string
+		var v models.VariantStats
+		if err := rows.Scan(&name, &v.Conversations, &v.Handoffs, &v.Schedules); err != nil {
+			return nil, err
+		}
+		stats[name] = v
+	}
+	return stats, rows.Err()
+}
+
+// RecordInboundType notes the WhatsApp type of an inbound message. It
+// reports false for a wamid already recorded, so webhook retries count once.
+func (db *DB) RecordInboundType(wamid, msgType string) (bool, error) {
+	res, err := db.exec(`INSERT OR IGNORE INTO inbound_types(wamid, type) VALUES(?, ?)`, wamid, msgType)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// InboundTypeCounts counts inbound messages by type since the given time.
+func (db *DB) InboundTypeCounts(since time.Time) (map[string]int, error) {
+	rows, err := db.conn.Query(
+		`SELECT type, COUNT(*) FROM inbound_types WHERE received_at >= ? GROUP BY type`,
+		sqlTime(since),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var t string
+		var n int
+		if err := rows.Scan(&t, &n); err != nil {
+			return nil, err
+		}
+		counts[t] = n
+	}
+	return counts, rows.Err()
+}
+
+// GetMessageRaw returns the raw DeepSeek content stored with an assistant
+// message ("" for other messages). Returns sql.ErrNoRows if id is unknown.
+func (db *DB) GetMessageRaw(id string) (string, error) {
+	var raw sql.NullString
+	err := db.conn.QueryRow(`SELECT raw_llm_response FROM messages WHERE id = ?`, id).Scan(&raw)
+	return raw.String, err
+}
+
+// GetMessageContent returns the content of message id within a conversation.
+// Returns sql.ErrNoRows if the conversation has no such message.
+func (db *DB) GetMessageContent(conversationID, id string) (string, error) {
+	var content string
+	err := db.conn.QueryRow(
+		`SELECT content FROM messages WHERE (id = ? OR wamid = ?) AND conversation_id = ? LIMIT 1`, id, id, conversationID,
+	).Scan(&content)
+	return content, err
+}
+
+// GetRecentMessages returns the last n messages for a conversation, oldest
+// first. Messages are ordered by Meta's send time where we have it, so a
+// debounced or deferred insert doesn't move a message later than it was sent.
+func (db *DB) GetRecentMessages(conversationID string, limit int) ([]models.Message, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, conversation_id, role, content, COALESCE(reply_to, ''), sent_at, created_at
+		 FROM messages
+		 WHERE conversation_id = ?
+		 ORDER BY COALESCE(sent_at, created_at) DESC, rowid DESC
+		 LIMIT ?`,
+		conversationID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []models.Message
+	for rows.Next() {
+		var m models.Message
+		var sentAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.ReplyTo, &sentAt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.SentAt = sentAt.Time
+		msgs = append(msgs, m)
+	}
+
+	// Reverse to get chronological order.
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, rows.Err()
+}
+
+// GetMessagesPage returns up to limit messages older than beforeRowID (0 for
+// the newest), oldest first, plus the cursor to pass for the next older page.
+// The cursor is 0 once the start of the history has been reached.
+func (db *DB) GetMessagesPage(conversationID string, beforeRowID int64, limit int) ([]models.Message, int64, error) {
+	if limit <= 0 {
+		return nil, 0, fmt.Errorf("database: page limit must be positive, got %d", limit)
+	}
+	if beforeRowID <= 0 {
+		beforeRowID = math.MaxInt64
+	}
+	rows, err := db.conn.Query(
+		`SELECT rowid, id, conversation_id, role, content, sent_at, created_at
+		 FROM messages
+		 WHERE conversation_id = ? AND rowid < ?
+		 ORDER BY rowid DESC
+		 LIMIT ?`,
+		conversationID, beforeRowID, limit+1,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var msgs []models.Message
+	var rowIDs []int64
+	for rows.Next() {
+		var m models.Message
+		var rowID int64
+		var sentAt sql.NullTime
+		if err := rows.Scan(&rowID, &m.ID, &m.ConversationID, &m.Role, &m.Content, &sentAt, &m.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		m.SentAt = sentAt.Time
+		msgs = append(msgs, m)
+		rowIDs = append(rowIDs, rowID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// The extra row only signals that an older page exists.
+	var next int64
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+		next = rowIDs[limit-1]
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, next, nil
+}
+
+// likeEscaper escapes LIKE wildcards so a search matches them literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchMessages returns messages across all conversations whose content
+// contains query (case-insensitive for ASCII), newest first.
+func (db *DB) SearchMessages(query string, limit int) ([]models.Message, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, conversation_id, role, content, sent_at, created_at
+		 FROM messages
+		 WHERE content LIKE ? ESCAPE '\'
+		 ORDER BY created_at DESC, rowid DESC
+		 LIMIT ?`,
+		"%"+likeEscaper.Replace(query)+"%", limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []models.Message
+	for rows.Next() {
+		var m models.Message
+		var sentAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &sentAt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.SentAt = sentAt.Time
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// GetLastInboundTime returns when the customer last wrote — Meta's send
+// time when known, otherwise when we stored it. Zero if they never have.
+func (db *DB) GetLastInboundTime(conversationID string) (time.Time, error) {
+	var sentAt sql.NullTime
+	var createdAt time.Time
+	err := db.conn.QueryRow(
+		`SELECT sent_at, created_at FROM messages
+		 WHERE conversation_id = ? AND role = 'user'
+		 ORDER BY created_at DESC, rowid DESC
+		 LIMIT 1`,
+		conversationID,
+	).Scan(&sentAt, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if sentAt.Valid {
+		return sentAt.Time, nil
+	}
+	return createdAt, nil
+}
+
+// GetAllMessages returns every message in a conversation, oldest first,
+// ordered like GetRecentMessages.
+func (db *DB) GetAllMessages(conversationID string) ([]models.Message, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, conversation_id, role, content, COALESCE(reply_to, ''), sent_at, created_at
+		 FROM messages
+		 WHERE conversation_id = ?
+		 ORDER BY COALESCE(sent_at, created_at), rowid`,
+		conversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []models.Message
+	for rows.Next() {
+		var m models.Message
+		var sentAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.ReplyTo, &sentAt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.SentAt = sentAt.Time
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// ─── Quote Data ───────────────────────────────────────────────────────────────
+
+// GetQuoteData returns the latest extracted quote for a conversation, or nil
+// if none has been stored.
+func (db *DB) GetQuoteData(conversationID string) (*models.ExtractedData, error) {
+	var dump sql.NullString
+	err := db.conn.QueryRow(
+		`SELECT json_dump FROM quote_data WHERE conversation_id = ?`, conversationID,
+	).Scan(&dump)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && dump.String == "") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data models.ExtractedData
+	if err := json.Unmarshal([]byte(dump.String), &data); err != nil {
+		return nil, fmt.Errorf("database: malformed quote data for %s: %w", conversationID, err)
+	}
+	return &data, nil
+}
+
+// UpsertQuoteData saves extracted JSON data for a conversation.
+func (db *DB) UpsertQuoteData(conversationID, jsonDump string) error {
+	_, err := db.exec(
+		`INSERT INTO quote_data(conversation_id, json_dump, updated_at)
+		 VALUES(?, ?, ?)
+		 ON CONFLICT(conversation_id) DO UPDATE SET json_dump = excluded.json_dump, updated_at = excluded.updated_at`,
+		conversationID, jsonDump, time.Now(),
+	)
+	return err
+}
+
+// QuoteCompleteness returns, for every ACTIVE conversation, a histogram of how
+// many quote fields are filled (0..models.QuoteFieldCount). Conversations with
+// no stored quote data count as having zero fields filled.
+func (db *DB) QuoteCompleteness() (map[int]int, error) {
+	rows, err := db.conn.Query(
+		`SELECT q.json_dump
+		 FROM conversations c
+		 LEFT JOIN quote_data q ON q.conversation_id = c.id
+		 WHERE c.status = 'ACTIVE'`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int, models.QuoteFieldCount+1)
+	for i := 0; i <= models.QuoteFieldCount; i++ {
+		counts[i] = 0
+	}
+	for rows.Next() {
+		var dump sql.NullString
+		if err := rows.Scan(&dump); err != nil {
+			return nil, err
+		}
+		var data models.ExtractedData
+		if dump.Valid && dump.String != "" {
+			if err := json.Unmarshal([]byte(dump.String), &data); err != nil {
+				slog.Warn("database: skipping malformed quote data", "err", err)
+			}
+		}
+		counts[data.FilledCount()]++
+	}
+	return counts, rows.Err()
+}
+
+// ─── Pending replies ──────────────────────────────────────────────────────────
+
+// SetPendingReply stores (or replaces) the draft awaiting approval for a
+// conversation.
+func (db *DB) SetPendingReply(conversationID, triggerMessageID, reply string) error {
+	_, err := db.exec(
+		`INSERT INTO pending_replies(conversation_id, trigger_message_id, reply)
+		 VALUES(?, ?, ?)
+		 ON CONFLICT(conversation_id) DO UPDATE SET
+		   trigger_message_id = excluded.trigger_message_id,
+		   reply = excluded.reply,
+		   updated_at = CURRENT_TIMESTAMP`,
+		conversationID, triggerMessageID, reply,
+	)
+	return err
+}
+
+// GetPendingReply returns the draft awaiting approval, or nil if none.
+func (db *DB) GetPendingReply(conversationID string) (*models.PendingReply, error) {
+	p := &models.PendingReply{}
+	err := db.conn.QueryRow(
+		`SELECT conversation_id, trigger_message_id, reply, created_at, updated_at
+		 FROM pending_replies WHERE conversation_id = ?`, conversationID,
+	).Scan(&p.ConversationID, &p.TriggerMessageID, &p.Reply, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ClearPendingReply removes the draft once it has been approved or rejected.
+func (db *DB) ClearPendingReply(conversationID string) error {
+	_, err := db.exec(`DELETE FROM pending_replies WHERE conversation_id = ?`, conversationID)
+	return err
+}
+
+// ─── Outbound failures ────────────────────────────────────────────────────────
+
+// InsertOutboundFailure records a WhatsApp send that failed or was rejected.
+func (db *DB) InsertOutboundFailure(f *models.OutboundFailure) error {
+	_, err := db.exec(
+		`INSERT INTO outbound_failures(conversation_id, body, status_code, error) VALUES(?, ?, ?, ?)`,
+		f.ConversationID, f.Body, f.StatusCode, f.Error,
+	)
+	return err
+}
+
+// ListOutboundFailures returns the most recent failed sends, newest first.
+func (db *DB) ListOutboundFailures(limit int) ([]models.OutboundFailure, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, conversation_id, body, status_code, COALESCE(error, ''), created_at
+		 FROM outbound_failures
+		 ORDER BY id DESC
+		 LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []models.OutboundFailure
+	for rows.Next() {
+		var f models.OutboundFailure
+		if err := rows.Scan(&f.ID, &f.ConversationID, &f.Body, &f.StatusCode, &f.Error, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// ─── Webhook events ───────────────────────────────────────────────────────────
+
+// InsertWebhookEvent archives a raw inbound webhook body and whether its
+// signature checked out.
+func (db *DB) InsertWebhookEvent(rawBody string, signatureValid bool) error {
+	_, err := db.exec(
+		`INSERT INTO webhook_events(raw_body, signature_valid) VALUES(?, ?)`,
+		rawBody, signatureValid,
+	)
+	return err
+}
+
+// DeleteWebhookEvents removes webhook events received before the cutoff and
+// returns how many were removed.
+func (db *DB) DeleteWebhookEvents(before time.Time) (int, error) {
+	res, err := db.exec(`DELETE FROM webhook_events WHERE received_at < ?`, sqlTime(before))
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// ListWebhookEvents returns the most recent webhook events, newest first.
+func (db *DB) ListWebhookEvents(limit int) ([]models.WebhookEvent, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, raw_body, signature_valid, received_at
+		 FROM webhook_events
+		 ORDER BY id DESC
+		 LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.WebhookEvent
+	for rows.Next() {
+		var e models.WebhookEvent
+		if err := rows.Scan(&e.ID, &e.RawBody, &e.SignatureValid, &e.ReceivedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// DeferInbound saves a verified webhook body the processing queue had no
+// room for, to be picked up by TakeDeferredInbound.
+func (db *DB) DeferInbound(rawBody, traceID string) error {
+	_, err := db.exec(`INSERT INTO deferred_inbound(raw_body, trace_id) VALUES(?, NULLIF(?, ''))`, rawBody, traceID)
+	return err
+}
+
+// TakeDeferredInbound removes and returns up to limit deferred webhook
+// bodies, oldest first.
+func (db *DB) TakeDeferredInbound(limit int) ([]models.DeferredInbound, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, raw_body, COALESCE(trace_id, '') FROM deferred_inbound ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	var lastID int64
+	var bodies []models.DeferredInbound
+	for rows.Next() {
+		var d models.DeferredInbound
+		if err := rows.Scan(&lastID, &d.Body, &d.TraceID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bodies = append(bodies, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(bodies) == 0 {
+		return nil, err
+	}
+
+	// Ids only grow, so everything up to the last one read was returned.
+	if _, err := tx.Exec(`DELETE FROM deferred_inbound WHERE id <= ?`, lastID); err != nil {
+		return nil, fmt.Errorf("delete taken: %w", err)
+	}
+	return bodies, tx.Commit()
+}
+
+// ─── LLM usage ────────────────────────────────────────────────────────────────
+
+// AddUsage adds one LLM call's token counts to the conversation's totals.
+func (db *DB) AddUsage(phoneNumber string, promptTokens, completionTokens int) error {
+	_, err := db.exec(
+		`INSERT INTO llm_usage(conversation_id, calls, prompt_tokens, completion_tokens)
+		 VALUES(?, 1, ?, ?)
+		 ON CONFLICT(conversation_id) DO UPDATE SET
+		   calls             = calls + 1,
+		   prompt_tokens     = prompt_tokens + excluded.prompt_tokens,
+		   completion_tokens = completion_tokens + excluded.completion_tokens,
+		   updated_at        = CURRENT_TIMESTAMP`,
+		phoneNumber, promptTokens, completionTokens,
+	)
+	return err
+}
+
+// TotalUsage sums LLM usage across every conversation.
+func (db *DB) TotalUsage() (calls, promptTokens, completionTokens int, err error) {
+	err = db.conn.QueryRow(
+		`SELECT COALESCE(SUM(calls), 0), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0)
+		 FROM llm_usage`,
+	).Scan(&calls, &promptTokens, &completionTokens)
+	return calls, promptTokens, completionTokens, err
+}
+
+// ListUsage returns the conversations that have used the most tokens, up to
+// limit, heaviest first.
+func (db *DB) ListUsage(limit int) ([]models.ConversationUsage, error) {
+	rows, err := db.conn.Query(
+		`SELECT conversation_id, calls, prompt_tokens, completion_tokens, updated_at
+		 FROM llm_usage
+		 ORDER BY prompt_tokens + completion_tokens DESC, conversation_id
+		 LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []models.ConversationUsage
+	for rows.Next() {
+		var u models.ConversationUsage
+		if err := rows.Scan(&u.ConversationID, &u.Calls, &u.PromptTokens, &u.CompletionTokens, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// ─── Message status ───────────────────────────────────────────────────────────
+
+// RecordStatus stores the latest status Meta reported for an outbound wamid.
+// The billing conversation and category are kept from earlier updates when a
+// later one (e.g. "read") omits them.
+func (db *DB) RecordStatus(wamid, status, waConversationID, category string) error {
+	_, err := db.exec(
+		`INSERT INTO message_status(wamid, status, wa_conversation_id, pricing_category)
+		 VALUES(?, ?, NULLIF(?, ''), NULLIF(?, ''))
+		 ON CONFLICT(wamid) DO UPDATE SET
+		   status             = excluded.status,
+		   wa_conversation_id = COALESCE(excluded.wa_conversation_id, wa_conversation_id),
+		   pricing_category   = COALESCE(excluded.pricing_category, pricing_category),
+		   updated_at         = CURRENT_TIMESTAMP`,
+		wamid, status, waConversationID, category,
+	)
+	return err
+}
+
+// RecordSendError stores why Meta failed to deliver an outbound wamid and
+// marks it failed.
+func (db *DB) RecordSendError(wamid string, code int, title string) error {
+	_, err := db.exec(
+		`INSERT INTO message_status(wamid, status, error_code, error_title)
+		 VALUES(?, 'failed', ?, ?)
+		 ON CONFLICT(wamid) DO UPDATE SET
+		   status      = 'failed',
+		   error_code  = excluded.error_code,
+		   error_title = excluded.error_title,
+		   updated_at  = CURRENT_TIMESTAMP`,
+		wamid, code, title,
+	)
+	return err
+}
+
+// GetMessageStatus returns the recorded status of a wamid. Returns
+// sql.ErrNoRows if none was reported.
+func (db *DB) GetMessageStatus(wamid string) (models.MessageStatus, error) {
+	var s models.MessageStatus
+	err := db.conn.QueryRow(
+		`SELECT wamid, status, COALESCE(wa_conversation_id, ''), COALESCE(pricing_category, ''),
+		        COALESCE(error_code, 0), COALESCE(error_title, ''), updated_at
+		 FROM message_status WHERE wamid = ?`,
+		wamid,
+	).Scan(&s.WAMID, &s.Status, &s.WAConversationID, &s.PricingCategory, &s.ErrorCode, &s.ErrorTitle, &s.UpdatedAt)
+	return s, err
+}
+
+// ─── Outbound queue ───────────────────────────────────────────────────────────
+
+// Outbound message statuses.
+const (
+	OutboundPending = "pending"
+	OutboundSent    = "sent"
+	OutboundFailed  = "failed"
+)
+
+// EnqueueOutbound queues a reply for immediate delivery and returns its id.
+// messageID names the assistant message being delivered, or is "" for
+// static replies; traceID is the inbound request's, for the worker's logs.
+func (db *DB) EnqueueOutbound(conversationID, body, messageID, traceID string) (int64, error) {
+	res, err := db.exec(
+		`INSERT INTO outbound_messages(conversation_id, body, message_id, trace_id, status, next_attempt_at) VALUES(?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?)`,
+		conversationID, body, messageID, traceID, OutboundPending, sqlTime(time.Now()),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// DueOutbound returns up to limit pending messages due by now, oldest first.
+// A message waits while an older one to the same conversation is still
+// pending, so retries never reorder a conversation.
+func (db *DB) DueOutbound(now time.Time, limit int) ([]models.OutboundMessage, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, conversation_id, body, COALESCE(message_id, ''), COALESCE(trace_id, ''), status, attempts, next_attempt_at, COALESCE(last_error, ''), created_at
+		 FROM outbound_messages o
+		 WHERE status = ? AND next_attempt_at <= ?
+		   AND NOT EXISTS (
+		     SELECT 1 FROM outbound_messages e
+		     WHERE e.conversation_id = o.conversation_id AND e.status = ? AND e.id < o.id
+		   )
+		 ORDER BY id
+		 LIMIT ?`,
+		OutboundPending, sqlTime(now), OutboundPending, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []models.OutboundMessage
+	for rows.Next() {
+		m, err := scanOutbound(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// GetOutboundMessage returns a queued message. Returns sql.ErrNoRows if id is unknown.
+func (db *DB) GetOutboundMessage(id int64) (models.OutboundMessage, error) {
+	row := db.conn.QueryRow(
+		`SELECT id, conversation_id, body, COALESCE(message_id, ''), COALESCE(trace_id, ''), status, attempts, next_attempt_at, COALESCE(last_error, ''), created_at
+		 FROM outbound_messages WHERE id = ?`,
+		id,
+	)
+	return scanOutbound(row)
+}
+
+func scanOutbound(row interface{ Scan(...any) error }) (models.OutboundMessage, error) {
+	var m models.OutboundMessage
+	err := row.Scan(&m.ID, &m.ConversationID, &m.Body, &m.MessageID, &m.TraceID, &m.Status, &m.Attempts, &m.NextAttemptAt, &m.LastError, &m.CreatedAt)
+	return m, err
+}
+
+// MarkOutboundSent records a successful delivery and the wamid Meta gave it.
+func (db *DB) MarkOutboundSent(id int64, wamid string) error {
+	_, err := db.exec(
+		`UPDATE outbound_messages SET status = ?, wamid = NULLIF(?, ''), attempts = attempts + 1, last_error = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		OutboundSent, wamid, id,
+	)
+	return err
+}
+
+// RetryOutbound records a failed attempt and schedules the next one.
+func (db *DB) RetryOutbound(id int64, next time.Time, errText string) error {
+	_, err := db.exec(
+		`UPDATE outbound_messages SET attempts = attempts + 1, next_attempt_at = ?, last_error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		sqlTime(next), errText, id,
+	)
+	return err
+}
+
+// MarkOutboundFailed records a final failed attempt; the message is not retried.
+func (db *DB) MarkOutboundFailed(id int64, errText string) error {
+	_, err := db.exec(
+		`UPDATE outbound_messages SET status = ?, attempts = attempts + 1, last_error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		OutboundFailed, errText, id,
+	)
+	return err
+}
+
+// ─── Notes ────────────────────────────────────────────────────────────────────
+
+// AddNote attaches a staff note to a conversation and returns its id.
+func (db *DB) AddNote(conversationID, author, note string) (int64, error) {
+	res, err := db.exec(
+		`INSERT INTO conversation_notes(conversation_id, author, note) VALUES(?, ?, ?)`,
+		conversationID, author, note,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetNotes returns a conversation's notes, oldest first.
+func (db *DB) GetNotes(conversationID string) ([]models.ConversationNote, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, conversation_id, author, note, created_at
+		 FROM conversation_notes
+		 WHERE conversation_id = ?
+		 ORDER BY id`,
+		conversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.ConversationNote
+	for rows.Next() {
+		var n models.ConversationNote
+		if err := rows.Scan(&n.ID, &n.ConversationID, &n.Author, &n.Note, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
@@ -1,8 +1,18 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 
 	"clearoutspaces/internal/models"
 )
@@ -11,10 +21,110 @@ import (
 func newTestDB(t *testing.T) *DB {
 	t.Helper()
 	db := Init(":memory:")
-	t.Cleanup(func() { db.conn.Close() })
+	t.Cleanup(func() { db.Close() })
 	return db
 }
 
+func TestSetMaxOpenConns_ConcurrentReads(t *testing.T) {
+	db := Init(filepath.Join(t.TempDir(), "pool.sqlite"))
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(4)
+
+	phone := "14165551234"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := db.InsertMessage(&models.Message{ID: fmt.Sprintf("p%d", i), ConversationID: phone, Role: "user", Content: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Hold one read open; with a single connection the next read would block.
+	rows, err := db.conn.Query(`SELECT id FROM messages`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal("expected rows")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := db.GetRecentMessages(phone, 10); err != nil {
+				errs <- err
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if err := db.InsertMessage(&models.Message{ID: fmt.Sprintf("w%d", i), ConversationID: phone, Role: "user", Content: "more"}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reads blocked behind an open read")
+	}
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent query failed: %v", err)
+	}
+	if st := db.Stats(); st.MaxOpenConnections != 4 || st.OpenConnections < 2 {
+		t.Errorf("expected a pool of up to 4 with several open, got %+v", st)
+	}
+}
+
+func TestSetMaxOpenConns_MemoryStaysSingle(t *testing.T) {
+	db := newTestDB(t)
+	db.SetMaxOpenConns(8)
+	if n := db.Stats().MaxOpenConnections; n != 1 {
+		t.Errorf("expected :memory: to keep one connection, got %d", n)
+	}
+}
+
+func TestClose_CheckpointsAndIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "close.sqlite")
+	db := Init(path)
+	if err := db.UpsertConversation("14165551234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if info, err := os.Stat(path + "-wal"); err == nil && info.Size() > 0 {
+		t.Errorf("expected the WAL checkpointed on close, still %d bytes", info.Size())
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("expected a second Close to be a no-op, got %v", err)
+	}
+
+	reopened := Init(path)
+	defer reopened.Close()
+	if _, err := reopened.GetConversation("14165551234"); err != nil {
+		t.Errorf("expected the write to survive close, got %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Ping: unexpected error: %v", err)
+	}
+	db.Close()
+	if err := db.Ping(); err == nil {
+		t.Error("expected Ping to fail on a closed database")
+	}
+}
+
 // ─── Conversation tests ───────────────────────────────────────────────────────
 
 func TestUpsertConversation_CreatesNew(t *testing.T) {
@@ -40,7 +150,7 @@ func TestUpsertConversation_Idempotent(t *testing.T) {
 	if err := db.UpsertConversation("14165551234"); err != nil {
 		t.Fatal(err)
 	}
-	if err := db.PauseConversation("14165551234"); err != nil {
+	if err := db.PauseConversation("14165551234", "staff"); err != nil {
 		t.Fatal(err)
 	}
 	// Upsert again must not reset the status back to ACTIVE.
@@ -61,8 +171,11 @@ func TestGetConversationStatus_NotFound(t *testing.T) {
 	db := newTestDB(t)
 
 	_, err := db.GetConversationStatus("nonexistent")
-	if err == nil {
-		t.Error("expected error for nonexistent conversation, got nil")
+	if !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+	if _, err := db.GetConversation("nonexistent"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("GetConversation: expected ErrConversationNotFound, got %v", err)
 	}
 }
 
@@ -72,7 +185,7 @@ func TestPauseConversation(t *testing.T) {
 	if err := db.UpsertConversation("14165551234"); err != nil {
 		t.Fatal(err)
 	}
-	if err := db.PauseConversation("14165551234"); err != nil {
+	if err := db.PauseConversation("14165551234", "staff"); err != nil {
 		t.Fatalf("PauseConversation: unexpected error: %v", err)
 	}
 
@@ -85,6 +198,64 @@ func TestPauseConversation(t *testing.T) {
 	}
 }
 
+func TestGetConversation_PauseAudit(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.UpsertConversation("14165551234"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := db.GetConversation("14165551234")
+	if err != nil {
+		t.Fatalf("GetConversation: unexpected error: %v", err)
+	}
+	if c.Status != "ACTIVE" || c.PausedBy != "" || !c.PausedAt.IsZero() {
+		t.Errorf("expected fresh ACTIVE conversation with no pause audit, got %+v", c)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := db.PauseConversation("14165551234", "adrian"); err != nil {
+		t.Fatal(err)
+	}
+	c, err = db.GetConversation("14165551234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Status != "PAUSED" || c.PausedBy != "adrian" {
+		t.Errorf("expected PAUSED by adrian, got %+v", c)
+	}
+	if c.PausedAt.Before(before) || c.PausedAt.After(time.Now().Add(time.Second)) {
+		t.Errorf("expected paused_at around now, got %v", c.PausedAt)
+	}
+}
+
+func TestGetConversation_NotFound(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.GetConversation("nonexistent"); err == nil {
+		t.Error("expected error for nonexistent conversation, got nil")
+	}
+}
+
+func TestResumeConversation(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.UpsertConversation("14165551234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PauseConversation("14165551234", "staff"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ResumeConversation("14165551234"); err != nil {
+		t.Fatalf("ResumeConversation: unexpected error: %v", err)
+	}
+
+	status, err := db.GetConversationStatus("14165551234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "ACTIVE" {
+		t.Errorf("expected ACTIVE, got %s", status)
+	}
+}
+
 // ─── Message tests ───────────────────────────────────────────────────────────
 
 func TestInsertMessage_AndExists(t *testing.T) {
@@ -179,6 +350,48 @@ func TestGetRecentMessages_Order(t *testing.T) {
 	}
 }
 
+func TestGetRecentMessages_OrdersByMetaSentAt(t *testing.T) {
+	db := newTestDB(t)
+	phone := "14165551234"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inserted out of order, as when a deferred webhook lands after a newer
+	// message. The assistant turn has no Meta time and sorts by insert time.
+	now := time.Now().UTC()
+	msgs := []models.Message{
+		{ID: "m2", ConversationID: phone, Role: "user", Content: "second", SentAt: now.Add(-2 * time.Minute)},
+		{ID: "m1", ConversationID: phone, Role: "user", Content: "first", SentAt: now.Add(-5 * time.Minute)},
+		{ID: "m3", ConversationID: phone, Role: "assistant", Content: "reply"},
+	}
+	for i := range msgs {
+		if err := db.InsertMessage(&msgs[i]); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	got, err := db.GetRecentMessages(phone, 10)
+	if err != nil {
+		t.Fatalf("GetRecentMessages: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(got))
+	}
+	for i, want := range []string{"first", "second", "reply"} {
+		if got[i].Content != want {
+			t.Errorf("message[%d]: expected %q, got %q", i, want, got[i].Content)
+		}
+	}
+	all, err := db.GetAllMessages(phone)
+	if err != nil {
+		t.Fatalf("GetAllMessages: %v", err)
+	}
+	if len(all) != 3 || all[0].Content != "first" {
+		t.Errorf("expected the transcript ordered by send time, got %+v", all)
+	}
+}
+
 func TestGetRecentMessages_Limit(t *testing.T) {
 	db := newTestDB(t)
 	phone := "14165551234"
@@ -219,8 +432,285 @@ func TestGetRecentMessages_Empty(t *testing.T) {
 	}
 }
 
+func TestSetStage(t *testing.T) {
+	db := newTestDB(t)
+	phone := "14165551234"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+
+	conv, err := db.GetConversation(phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Stage != models.StageNew {
+		t.Errorf("expected new conversations at %s, got %q", models.StageNew, conv.Stage)
+	}
+
+	if err := db.SetStage(phone, models.StageScheduled); err != nil {
+		t.Fatalf("SetStage: %v", err)
+	}
+	if conv, _ = db.GetConversation(phone); conv.Stage != models.StageScheduled {
+		t.Errorf("expected %s, got %q", models.StageScheduled, conv.Stage)
+	}
+
+	if err := db.SetStage(phone, "ARCHIVED"); err == nil {
+		t.Error("expected an error for an unknown stage")
+	}
+	if err := db.SetStage("14165550000", models.StageClosed); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+}
+
+func TestActivityStats(t *testing.T) {
+	db := newTestDB(t)
+	seed := []struct {
+		phone  string
+		id     string
+		role   string
+		action string
+		old    bool
+	}{
+		{"14165551111", "a1", "user", "", false},
+		{"14165551111", "a2", "assistant", "handoff", false},
+		{"14165551111", "a3", "assistant", "handoff", false}, // same conversation
+		{"14165552222", "b1", "user", "", false},
+		{"14165552222", "b2", "assistant", "schedule", false},
+		{"14165553333", "c1", "user", "", true},
+		{"14165553333", "c2", "assistant", "handoff", true},
+	}
+	for _, m := range seed {
+		if err := db.UpsertConversation(m.phone); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertMessage(&models.Message{ID: m.id, ConversationID: m.phone, Role: m.role, Content: "x", Action: m.action}); err != nil {
+			t.Fatal(err)
+		}
+		if m.old {
+			old := sqlTime(time.Now().AddDate(0, 0, -30))
+			if _, err := db.conn.Exec(`UPDATE messages SET created_at = ? WHERE id = ?`, old, m.id); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.conn.Exec(`UPDATE conversations SET created_at = ? WHERE id = ?`, old, m.phone); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	st, err := db.ActivityStats(time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		t.Fatalf("ActivityStats: %v", err)
+	}
+	if st.Conversations != 2 || st.Messages != 5 || st.Handoffs != 1 || st.Schedules != 1 {
+		t.Errorf("unexpected 7-day stats: %+v", st)
+	}
+
+	st, err = db.ActivityStats(time.Now().AddDate(0, 0, -60))
+	if err != nil {
+		t.Fatalf("ActivityStats: %v", err)
+	}
+	if st.Conversations != 3 || st.Messages != 7 || st.Handoffs != 2 || st.Schedules != 1 {
+		t.Errorf("unexpected 60-day stats: %+v", st)
+	}
+}
+
+func TestActivityStats_ByVariant(t *testing.T) {
+	db := newTestDB(t)
+	for phone, variant := range map[string]string{"14165551111": "control", "14165552222": "control", "14165553333": "concise", "14165554444": ""} {
+		if err := db.UpsertConversation(phone); err != nil {
+			t.Fatal(err)
+		}
+		if variant != "" {
+			if err := db.SetPromptVariant(phone, variant); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, m := range []models.Message{
+		{ID: "a1", ConversationID: "14165551111", Role: "assistant", Content: "x", Action: "handoff"},
+		{ID: "a2", ConversationID: "14165551111", Role: "assistant", Content: "x", Action: "handoff"},
+		{ID: "c1", ConversationID: "14165553333", Role: "assistant", Content: "x", Action: "schedule"},
+		{ID: "d1", ConversationID: "14165554444", Role: "assistant", Content: "x", Action: "handoff"},
+	} {
+		if err := db.InsertMessage(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := db.ActivityStats(time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		t.Fatalf("ActivityStats: %v", err)
+	}
+	want := map[string]models.VariantStats{
+		"control": {Conversations: 2, Handoffs: 1},
+		"concise": {Conversations: 1, Schedules: 1},
+	}
+	if !reflect.DeepEqual(st.Variants, want) {
+		t.Errorf("variant stats = %+v, want %+v", st.Variants, want)
+	}
+}
+
+// ─── Message status tests ─────────────────────────────────────────────────────
+
+func TestRecordStatus(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.GetMessageStatus("wamid.s1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows before any status, got %v", err)
+	}
+	if err := db.RecordStatus("wamid.s1", "delivered", "conv-1", "marketing"); err != nil {
+		t.Fatalf("RecordStatus: %v", err)
+	}
+	if err := db.RecordStatus("wamid.s1", "read", "", ""); err != nil {
+		t.Fatalf("RecordStatus: %v", err)
+	}
+
+	st, err := db.GetMessageStatus("wamid.s1")
+	if err != nil {
+		t.Fatalf("GetMessageStatus: %v", err)
+	}
+	want := models.MessageStatus{WAMID: "wamid.s1", Status: "read", WAConversationID: "conv-1", PricingCategory: "marketing"}
+	st.UpdatedAt = time.Time{}
+	if st != want {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+}
+
+func TestGetMessagesPage(t *testing.T) {
+	db := newTestDB(t)
+	phone := "14165551234"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := db.InsertMessage(&models.Message{ID: fmt.Sprintf("m%d", i), ConversationID: phone, Role: "user", Content: fmt.Sprintf("msg %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(msgs []models.Message) string {
+		var s []string
+		for _, m := range msgs {
+			s = append(s, m.ID)
+		}
+		return strings.Join(s, ",")
+	}
+
+	// First page: the newest two, oldest first.
+	page, next, err := db.GetMessagesPage(phone, 0, 2)
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if got := ids(page); got != "m4,m5" || next == 0 {
+		t.Fatalf("first page: got %s, cursor %d", got, next)
+	}
+
+	// Middle page.
+	page, next, err = db.GetMessagesPage(phone, next, 2)
+	if err != nil {
+		t.Fatalf("middle page: %v", err)
+	}
+	if got := ids(page); got != "m2,m3" || next == 0 {
+		t.Fatalf("middle page: got %s, cursor %d", got, next)
+	}
+
+	// Last page is short and ends the history.
+	page, next, err = db.GetMessagesPage(phone, next, 2)
+	if err != nil {
+		t.Fatalf("last page: %v", err)
+	}
+	if got := ids(page); got != "m1" || next != 0 {
+		t.Errorf("last page: got %s, cursor %d", got, next)
+	}
+
+	// An exact fit also reports the end rather than an empty extra page.
+	page, next, err = db.GetMessagesPage(phone, 0, 5)
+	if err != nil || len(page) != 5 || next != 0 {
+		t.Errorf("exact fit: got %d messages, cursor %d, err %v", len(page), next, err)
+	}
+
+	if _, _, err := db.GetMessagesPage(phone, 0, 0); err == nil {
+		t.Error("expected an error for a zero limit")
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	db := newTestDB(t)
+	msgs := []*models.Message{
+		{ID: "s1", ConversationID: "14165551111", Role: "user", Content: "We have an upright Piano on the 2nd floor"},
+		{ID: "s2", ConversationID: "14165552222", Role: "user", Content: "Just a couch, 100% clear"},
+		{ID: "s3", ConversationID: "14165552222", Role: "user", Content: "unit_4 at the back"},
+		{ID: "s4", ConversationID: "14165553333", Role: "user", Content: "100 boxes, unit 4"},
+	}
+	for _, m := range msgs {
+		if err := db.UpsertConversation(m.ConversationID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"piano", []string{"s1"}},      // case-insensitive hit
+		{"harpsichord", nil},           // miss
+		{"%", []string{"s2"}},          // literal percent, not a wildcard
+		{"unit_4", []string{"s3"}},     // literal underscore
+		{`\`, nil},                     // escape character itself
+		{"100%", []string{"s2"}},       // wildcard mid-query
+		{"unit", []string{"s4", "s3"}}, // newest first
+	}
+	for _, c := range cases {
+		got, err := db.SearchMessages(c.query, 10)
+		if err != nil {
+			t.Fatalf("SearchMessages(%q): %v", c.query, err)
+		}
+		var ids []string
+		for _, m := range got {
+			ids = append(ids, m.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(c.want, ",") {
+			t.Errorf("SearchMessages(%q) = %v, want %v", c.query, ids, c.want)
+		}
+	}
+}
+
 // ─── Quote data tests ─────────────────────────────────────────────────────────
 
+func TestGetLastInboundTime(t *testing.T) {
+	db := newTestDB(t)
+	phone := "14165551234"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := db.GetLastInboundTime(phone)
+	if err != nil || !last.IsZero() {
+		t.Fatalf("expected zero time before any message, got %v, %v", last, err)
+	}
+
+	sent := time.Now().Add(-30 * time.Hour).UTC().Truncate(time.Second)
+	msgs := []*models.Message{
+		{ID: "in-1", ConversationID: phone, Role: "user", Content: "hi", SentAt: sent},
+		{ID: "out-1", ConversationID: phone, Role: "assistant", Content: "hello"},
+	}
+	for _, m := range msgs {
+		if err := db.InsertMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last, err = db.GetLastInboundTime(phone)
+	if err != nil {
+		t.Fatalf("GetLastInboundTime: %v", err)
+	}
+	if !last.Equal(sent) {
+		t.Errorf("expected customer's send time %v, got %v", sent, last)
+	}
+}
+
 func TestUpsertQuoteData(t *testing.T) {
 	db := newTestDB(t)
 	if err := db.UpsertConversation("14165551234"); err != nil {
@@ -250,3 +740,431 @@ func TestUpsertQuoteData(t *testing.T) {
 		t.Errorf("expected %q, got %q", json2, stored)
 	}
 }
+
+func TestQuoteCompleteness(t *testing.T) {
+	db := newTestDB(t)
+
+	seed := map[string]string{
+		"1000": "", // no quote data yet
+		"1001": `{"address":"unknown","elevator_access":"unknown","stairs":"unknown","inventory":"unknown"}`,
+		"1002": `{"address":"123 Main St","elevator_access":"unknown","stairs":"","inventory":"1 couch"}`,
+		"1003": `{"address":"123 Main St","elevator_access":"yes","stairs":"none","inventory":"1 couch"}`,
+		"1004": `{"address":"9 King St","elevator_access":"no","stairs":"2 flights","inventory":"fridge"}`,
+	}
+	for phone, dump := range seed {
+		if err := db.UpsertConversation(phone); err != nil {
+			t.Fatal(err)
+		}
+		if dump != "" {
+			if err := db.UpsertQuoteData(phone, dump); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Paused conversations are excluded even when complete.
+	if err := db.UpsertConversation("1005"); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.UpsertQuoteData("1005", `{"address":"a","elevator_access":"b","stairs":"c","inventory":"d"}`)
+	if err := db.PauseConversation("1005", "staff"); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := db.QuoteCompleteness()
+	if err != nil {
+		t.Fatalf("QuoteCompleteness: %v", err)
+	}
+	want := map[int]int{0: 2, 1: 0, 2: 1, 3: 0, 4: 2}
+	for filled, n := range want {
+		if counts[filled] != n {
+			t.Errorf("filled=%d: expected %d conversations, got %d", filled, n, counts[filled])
+		}
+	}
+}
+
+// ─── Pending reply tests ──────────────────────────────────────────────────────
+
+func TestNotes(t *testing.T) {
+	db := newTestDB(t)
+	phone := "14165551234"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []string{"quoted $400", "called back, booked Friday"} {
+		if _, err := db.AddNote(phone, "adrian", n); err != nil {
+			t.Fatalf("AddNote: %v", err)
+		}
+	}
+
+	notes, err := db.GetNotes(phone)
+	if err != nil {
+		t.Fatalf("GetNotes: %v", err)
+	}
+	if len(notes) != 2 || notes[0].Note != "quoted $400" || notes[1].Author != "adrian" {
+		t.Errorf("expected both notes oldest first, got %+v", notes)
+	}
+	if notes[0].CreatedAt.IsZero() {
+		t.Error("expected created_at to be set")
+	}
+}
+
+func TestPendingReply_Lifecycle(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.UpsertConversation("14165551234"); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := db.GetPendingReply("14165551234"); err != nil || p != nil {
+		t.Fatalf("expected no pending reply, got %v (err=%v)", p, err)
+	}
+
+	if err := db.SetPendingReply("14165551234", "wamid.1", "first draft"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPendingReply("14165551234", "wamid.2", "second draft"); err != nil {
+		t.Fatal(err)
+	}
+	p, err := db.GetPendingReply("14165551234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.Reply != "second draft" || p.TriggerMessageID != "wamid.2" {
+		t.Errorf("expected latest draft, got %+v", p)
+	}
+
+	if err := db.ClearPendingReply("14165551234"); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := db.GetPendingReply("14165551234"); p != nil {
+		t.Errorf("expected pending reply cleared, got %+v", p)
+	}
+}
+
+// ─── Write health tests ───────────────────────────────────────────────────────
+
+func TestPersistentWriteFailure_DegradesAndAlertsOnce(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.UpsertConversation("14165551234"); err != nil {
+		t.Fatal(err)
+	}
+
+	alerts := make(chan error, 10)
+	db.SetWritePolicy(WritePolicy{Retries: 1, Threshold: 2, OnDegraded: func(err error) { alerts <- err }})
+
+	// Simulate a read-only database: every write fails with SQLITE_READONLY.
+	if _, err := db.conn.Exec(`PRAGMA query_only = ON`); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		err := db.InsertMessage(&models.Message{
+			ID: fmt.Sprintf("msg-%d", i), ConversationID: "14165551234", Role: "user", Content: "hi",
+		})
+		if !IsPersistentWriteError(err) {
+			t.Fatalf("write %d: expected persistent write error, got %v", i, err)
+		}
+	}
+
+	if db.WriteHealth() == nil {
+		t.Fatal("expected write health to report degraded")
+	}
+	select {
+	case <-alerts:
+	case <-time.After(time.Second):
+		t.Fatal("expected an alert when the database degraded")
+	}
+	select {
+	case err := <-alerts:
+		t.Fatalf("expected exactly one alert, got another: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Writes recover once the underlying problem is fixed.
+	if _, err := db.conn.Exec(`PRAGMA query_only = OFF`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertQuoteData("14165551234", `{}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteHealth(); err != nil {
+		t.Errorf("expected write health to recover, got %v", err)
+	}
+}
+
+func TestIsPersistentWriteError_IgnoresConstraintErrors(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.UpsertConversation("14165551234"); err != nil {
+		t.Fatal(err)
+	}
+	msg := &models.Message{ID: "dup", ConversationID: "14165551234", Role: "user", Content: "hi"}
+	_ = db.InsertMessage(msg)
+	err := db.InsertMessage(msg)
+	if err == nil || IsPersistentWriteError(err) {
+		t.Errorf("expected a non-persistent constraint error, got %v", err)
+	}
+	if !IsDuplicateKey(err) || IsDuplicateKey(errors.New("other")) {
+		t.Errorf("expected IsDuplicateKey to match only the constraint error, got %v", err)
+	}
+	if db.WriteHealth() != nil {
+		t.Error("constraint errors must not degrade write health")
+	}
+}
+
+func TestExec_RetriesWhileBusy(t *testing.T) {
+	db := newTestDB(t)
+	origExec, origDelay := sqlExec, busyRetryDelay
+	t.Cleanup(func() { sqlExec, busyRetryDelay = origExec, origDelay })
+	busyRetryDelay = time.Millisecond
+
+	locked := 2
+	sqlExec = func(conn *sql.DB, query string, args ...any) (sql.Result, error) {
+		if locked > 0 {
+			locked--
+			return nil, sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+		return origExec(conn, query, args...)
+	}
+	if err := db.UpsertConversation("14165551234"); err != nil {
+		t.Fatalf("expected the write to succeed once unlocked, got %v", err)
+	}
+	if _, err := db.GetConversation("14165551234"); err != nil {
+		t.Errorf("expected the conversation written, got %v", err)
+	}
+
+	// A lock that never clears comes back as the typed sqlite3 error.
+	locked = busyRetries + 1
+	err := db.PauseConversation("14165551234", "staff")
+	var sqliteErr sqlite3.Error
+	if !IsBusy(err) || !errors.As(err, &sqliteErr) || locked != 0 {
+		t.Errorf("expected SQLITE_BUSY after %d retries, got %v (%d attempts left)", busyRetries, err, locked)
+	}
+	if db.WriteHealth() != nil {
+		t.Error("lock contention must not degrade write health")
+	}
+}
+
+func TestArchiveOldMessages(t *testing.T) {
+	db := newTestDB(t)
+	const phone = "14165554545"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertQuoteData(phone, `{"address":"1 Main St"}`); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"old1", "old2", "new1"} {
+		if err := db.InsertMessage(&models.Message{ID: id, ConversationID: phone, Role: "user", Content: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := sqlTime(time.Now().AddDate(-1, 0, 0))
+	if _, err := db.conn.Exec(`UPDATE messages SET created_at = ? WHERE id IN ('old1', 'old2')`, old); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.ArchiveOldMessages(time.Now().AddDate(0, 0, -90))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 messages archived, got %d (%v)", n, err)
+	}
+	msgs, _ := db.GetRecentMessages(phone, 10)
+	if len(msgs) != 1 || msgs[0].ID != "new1" {
+		t.Errorf("expected only the recent message left, got %+v", msgs)
+	}
+	var archived int
+	_ = db.conn.QueryRow(`SELECT COUNT(*) FROM archived_messages WHERE conversation_id = ?`, phone).Scan(&archived)
+	if archived != 2 {
+		t.Errorf("expected 2 archived rows, got %d", archived)
+	}
+
+	// Idempotency still sees archived wamids; the conversation and quote stay.
+	for _, id := range []string{"old1", "new1"} {
+		if exists, err := db.MessageExists(id); err != nil || !exists {
+			t.Errorf("MessageExists(%s) = %v, %v; want true", id, exists, err)
+		}
+	}
+	if exists, _ := db.MessageExists("never"); exists {
+		t.Error("expected an unknown id not to exist")
+	}
+	if data, err := db.GetQuoteData(phone); err != nil || data == nil || data.Address != "1 Main St" {
+		t.Errorf("expected quote data kept, got %+v, %v", data, err)
+	}
+	if _, err := db.GetConversation(phone); err != nil {
+		t.Errorf("expected the conversation kept, got %v", err)
+	}
+
+	if n, err := db.ArchiveOldMessages(time.Now().AddDate(0, 0, -90)); err != nil || n != 0 {
+		t.Errorf("expected a second pass to archive nothing, got %d (%v)", n, err)
+	}
+}
+
+func TestDeferredInbound_TakeOldestFirst(t *testing.T) {
+	db := newTestDB(t)
+	for i, body := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		if err := db.DeferInbound(body, fmt.Sprintf("trace-%d", i+1)); err != nil {
+			t.Fatalf("defer: %v", err)
+		}
+	}
+
+	got, err := db.TakeDeferredInbound(2)
+	if err != nil || len(got) != 2 || got[0].Body != `{"n":1}` || got[1].Body != `{"n":2}` {
+		t.Fatalf("expected the two oldest, got %v, %v", got, err)
+	}
+	if got[0].TraceID != "trace-1" {
+		t.Errorf("expected the trace id kept, got %q", got[0].TraceID)
+	}
+	got, err = db.TakeDeferredInbound(10)
+	if err != nil || len(got) != 1 || got[0].Body != `{"n":3}` {
+		t.Fatalf("expected the remaining one, got %v, %v", got, err)
+	}
+	if got, _ := db.TakeDeferredInbound(10); len(got) != 0 {
+		t.Errorf("expected nothing left, got %v", got)
+	}
+}
+
+func TestMergeConversations(t *testing.T) {
+	db := newTestDB(t)
+	from, into := "+14165551234", "14165551234"
+	for _, phone := range []string{from, into} {
+		if err := db.UpsertConversation(phone); err != nil {
+			t.Fatalf("UpsertConversation(%s): %v", phone, err)
+		}
+	}
+	for i, m := range []models.Message{
+		{ID: "wamid.old1", ConversationID: from, Role: "user", Content: "couch pickup?"},
+		{ID: "assistant-wamid.old1", ConversationID: from, Role: "assistant", Content: "Sure, where?"},
+		{ID: "wamid.new1", ConversationID: into, Role: "user", Content: "88 Queen St E"},
+	} {
+		m.SentAt = time.Now().Add(time.Duration(i) * time.Minute)
+		if err := db.InsertMessage(&m); err != nil {
+			t.Fatalf("InsertMessage: %v", err)
+		}
+	}
+	if err := db.UpsertQuoteData(into, `{"address":"old"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertQuoteData(from, `{"address":"88 Queen St E"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddUsage(from, 100, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddUsage(into, 50, 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		db.SetSlackThreadTS(from, "1700000000.000100"),
+		db.SetPromptVariant(from, "b"),
+		db.SetPromptVariant(into, "a"),
+		db.AddConversationFlags(from, []string{"hazardous", "piano"}),
+		db.AddConversationFlags(into, []string{"piano", "same_day"}),
+		db.SetConversationLocale(from, "fr", "America/Toronto"),
+		db.MarkHandoffFailed(from),
+		db.QueueConversation(from),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dups, err := db.FindDuplicateConversations()
+	if err != nil || len(dups) != 1 || dups[0] != (DuplicateConversation{From: from, Into: into}) {
+		t.Fatalf("FindDuplicateConversations = %+v, %v", dups, err)
+	}
+
+	if err := db.MergeConversations(from, into); err != nil {
+		t.Fatalf("MergeConversations: %v", err)
+	}
+
+	msgs, err := db.GetAllMessages(into)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[0].ID != "wamid.old1" || msgs[2].ID != "wamid.new1" {
+		t.Errorf("expected all 3 messages under %s in send order, got %+v", into, msgs)
+	}
+	if msgs, _ := db.GetAllMessages(from); len(msgs) != 0 {
+		t.Errorf("expected no messages left under %s, got %d", from, len(msgs))
+	}
+	if q, err := db.GetQuoteData(into); err != nil || q == nil || q.Address != "88 Queen St E" {
+		t.Errorf("expected the newest quote to survive, got %+v, %v", q, err)
+	}
+	if _, err := db.GetConversation(from); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected %s to be deleted, got err %v", from, err)
+	}
+	if calls, prompt, _, err := db.TotalUsage(); err != nil || calls != 2 || prompt != 150 {
+		t.Errorf("expected usage to be summed, got %d calls, %d prompt tokens, %v", calls, prompt, err)
+	}
+	if dups, _ := db.FindDuplicateConversations(); len(dups) != 0 {
+		t.Errorf("expected no duplicates after merging, got %+v", dups)
+	}
+	conv, err := db.GetConversation(into)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.PromptVariant != "a" || conv.Timezone != "America/Toronto" || conv.HandoffFailedAt.IsZero() {
+		t.Errorf("expected into's variant kept and from's timezone and failed handoff taken, got %+v", conv)
+	}
+	if want := []string{"piano", "same_day", "hazardous"}; !reflect.DeepEqual(conv.Flags, want) {
+		t.Errorf("expected flags %v, got %v", want, conv.Flags)
+	}
+	if ts, _ := db.GetSlackThreadTS(into); ts != "1700000000.000100" {
+		t.Errorf("expected from's Slack thread taken, got %q", ts)
+	}
+	if queued, _ := db.QueuedConversations(10); len(queued) != 1 || queued[0] != into {
+		t.Errorf("expected into queued in from's place, got %v", queued)
+	}
+
+	if err := db.MergeConversations(from, into); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("merging a missing conversation: got %v, want ErrConversationNotFound", err)
+	}
+}
+
+func TestApplyMigrations_RunsEachVersionOnce(t *testing.T) {
+	db := newTestDB(t)
+	// Versions past the built-in ones, which Init has already applied.
+	list := []migration{
+		{1001, "create runs", []string{`CREATE TABLE runs (version INTEGER)`, `INSERT INTO runs VALUES (1)`}},
+		{1002, "record second", []string{`INSERT INTO runs VALUES (2)`}},
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.applyMigrations(list); err != nil {
+			t.Fatalf("applyMigrations run %d: %v", i+1, err)
+		}
+	}
+	var runs int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM runs`).Scan(&runs); err != nil || runs != 2 {
+		t.Errorf("expected each migration to run once (2 rows), got %d (%v)", runs, err)
+	}
+
+	// A failing migration rolls back and isn't recorded; earlier ones stand.
+	list = append(list, migration{1003, "broken", []string{`INSERT INTO runs VALUES (3)`, `INSERT INTO missing VALUES (1)`}})
+	if err := db.applyMigrations(list); err == nil || !strings.Contains(err.Error(), "migration 1003") {
+		t.Fatalf("expected migration 1003 to fail, got %v", err)
+	}
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM runs`).Scan(&runs); err != nil || runs != 2 {
+		t.Errorf("expected the failed migration to roll back, got %d rows (%v)", runs, err)
+	}
+	var versions int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version > 1000`).Scan(&versions); err != nil || versions != 2 {
+		t.Errorf("expected versions 1001 and 1002 recorded, got %d (%v)", versions, err)
+	}
+
+	// Init has already applied the built-in migrations; rerunning is a no-op.
+	db.migrate()
+	var latest int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, versionedMigrations[len(versionedMigrations)-1].version).Scan(&latest); err != nil || latest != 1 {
+		t.Errorf("expected the latest built-in migration recorded once, got %d (%v)", latest, err)
+	}
+}
+
+func TestApplyMigrations_RejectsOutOfOrderVersions(t *testing.T) {
+	db := newTestDB(t)
+	err := db.applyMigrations([]migration{{1002, "b", nil}, {1001, "a", nil}})
+	if err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Errorf("expected an out of order error, got %v", err)
+	}
+	var n int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version > 1000`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("expected nothing applied from a misordered list, got %d (%v)", n, err)
+	}
+}
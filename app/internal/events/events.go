@@ -0,0 +1,102 @@
+// Package events is a small in-process publish/subscribe bus for message
+// lifecycle events. Handlers publish; cross-cutting features (metrics, audit,
+// alerts) subscribe without being threaded through the handler code.
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Type identifies a lifecycle event.
+type Type string
+
+const (
+	MessageReceived Type = "message_received" // inbound customer message saved
+	ReplySent       Type = "reply_sent"       // assistant reply sent to the customer
+	Handoff         Type = "handoff"          // conversation handed off to staff in Slack
+	StatusChanged   Type = "status_changed"   // conversation status changed (e.g. PAUSED)
+)
+
+// Event describes something that happened to a conversation. Fields that
+// don't apply to the event type are left empty.
+type Event struct {
+	Type      Type
+	Phone     string
+	MessageID string
+	Text      string // message body or reply text
+	Status    string // new status, for StatusChanged
+	Actor     string // who caused the change, for StatusChanged
+	At        time.Time
+}
+
+// Handler receives published events. Handlers run synchronously on the
+// publisher's goroutine, so they must be quick and hand off slow work.
+type Handler func(Event)
+
+// Bus fans events out to every subscriber.
+type Bus struct {
+	mu   sync.RWMutex
+	next int
+	subs map[int]Handler
+}
+
+// NewBus returns an empty bus.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]Handler)}
+}
+
+// Subscribe registers h and returns a function that unregisters it.
+func (b *Bus) Subscribe(h Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = h
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Publish delivers e to every subscriber, stamping At if unset. A panicking
+// subscriber is logged and does not affect the others or the publisher.
+func (b *Bus) Publish(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.subs))
+	for _, h := range b.subs {
+		handlers = append(handlers, h)
+	}
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		deliver(h, e)
+	}
+}
+
+func deliver(h Handler, e Event) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Error("events: subscriber panicked", "event", e.Type, "err", rec)
+		}
+	}()
+	h(e)
+}
+
+// Default is the process-wide bus used by the handlers.
+var Default = NewBus()
+
+// Subscribe registers h on the Default bus.
+func Subscribe(h Handler) (unsubscribe func()) {
+	return Default.Subscribe(h)
+}
+
+// Publish delivers e on the Default bus.
+func Publish(e Event) {
+	Default.Publish(e)
+}
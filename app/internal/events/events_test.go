@@ -0,0 +1,22 @@
+package events
+
+import "testing"
+
+func TestBus_PublishSubscribe(t *testing.T) {
+	bus := NewBus()
+
+	var got []Event
+	unsubscribe := bus.Subscribe(func(e Event) { got = append(got, e) })
+	bus.Subscribe(func(Event) { panic("bad subscriber") })
+
+	bus.Publish(Event{Type: MessageReceived, Phone: "14165551234"})
+	unsubscribe()
+	bus.Publish(Event{Type: ReplySent, Phone: "14165551234"})
+
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event before unsubscribe, got %d", len(got))
+	}
+	if got[0].Type != MessageReceived || got[0].At.IsZero() {
+		t.Errorf("unexpected event: %+v", got[0])
+	}
+}
@@ -0,0 +1,834 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"clearoutspaces/internal/config"
+	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/events"
+	"clearoutspaces/internal/llm"
+	"clearoutspaces/internal/logging"
+	"clearoutspaces/internal/metrics"
+	"clearoutspaces/internal/models"
+)
+
+// RequireAdmin wraps an admin handler with bearer-token authentication against
+// ADMIN_TOKEN, or for GETs a link signed by signedAdminLink. When no token is
+// configured every request is rejected.
+func RequireAdmin(cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		authorized := subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1 || validAdminLink(cfg, r, time.Now())
+		if cfg.AdminToken == "" || !authorized {
+			slog.Warn("admin: unauthorized request", "path", r.URL.Path, "event", "admin_unauthorized")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminLinkTTL is how long a signed admin link keeps working. A var so
+// tests can change it.
+var adminLinkTTL = 7 * 24 * time.Hour
+
+// signedAdminLink returns the absolute URL of the admin GET endpoint at path,
+// signed so it opens in a browser without the bearer token, e.g. from Slack.
+// It returns "" without BaseURL or ADMIN_TOKEN.
+func signedAdminLink(cfg *config.Config, path string, now time.Time) string {
+	if cfg.BaseURL == "" || cfg.AdminToken == "" {
+		return ""
+	}
+	expires := strconv.FormatInt(now.Add(adminLinkTTL).Unix(), 10)
+	q := url.Values{"expires": {expires}, "sig": {adminLinkSignature(cfg.AdminToken, path, expires)}}
+	return cfg.BaseURL + path + "?" + q.Encode()
+}
+
+// validAdminLink reports whether r is a GET carrying an unexpired signature
+// for its path.
+func validAdminLink(cfg *config.Config, r *http.Request, now time.Time) bool {
+	expires, sig := r.URL.Query().Get("expires"), r.URL.Query().Get("sig")
+	if r.Method != http.MethodGet || cfg.AdminToken == "" || expires == "" || sig == "" {
+		return false
+	}
+	if unix, err := strconv.ParseInt(expires, 10, 64); err != nil || now.Unix() >= unix {
+		return false
+	}
+	want := adminLinkSignature(cfg.AdminToken, r.URL.Path, expires)
+	return hmac.Equal([]byte(sig), []byte(want))
+}
+
+func adminLinkSignature(token, path, expires string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(path + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ─── GET /admin/completeness ──────────────────────────────────────────────────
+
+// HandleCompleteness reports how many ACTIVE conversations have each number
+// of quote fields filled, so operators can gauge how often collection succeeds.
+func HandleCompleteness(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		counts, err := db.QuoteCompleteness()
+		if err != nil {
+			slog.Error("admin: quote completeness", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		total := 0
+		byFilled := make(map[string]int, len(counts))
+		for filled, n := range counts {
+			byFilled[strconv.Itoa(filled)] = n
+			total += n
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{
+			"total":           total,
+			"required_fields": models.QuoteFieldCount,
+			"complete":        counts[models.QuoteFieldCount],
+			"by_filled":       byFilled,
+		})
+	}
+}
+
+// ─── PUT /admin/conversations/{phone}/language ────────────────────────────────
+
+var languageCodeRe = regexp.MustCompile(`^[a-z]{2}$`)
+
+// HandleSetLanguage overrides a conversation's stored (and, with
+// LANGUAGE_LOCK, locked) language. An empty language clears it so the next
+// inbound message is detected afresh.
+func HandleSetLanguage(db *database.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, err := normalizePhone(mux.Vars(r)["phone"], cfg.DefaultCountryCode)
+		if err != nil {
+			http.Error(w, "invalid phone number", http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Language string `json:"language"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		lang := strings.ToLower(strings.TrimSpace(body.Language))
+		if lang != "" && !languageCodeRe.MatchString(lang) {
+			http.Error(w, "language must be a two-letter ISO 639-1 code", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := db.GetConversationStatus(phone); err != nil {
+			if errors.Is(err, database.ErrConversationNotFound) {
+				http.Error(w, "conversation not found", http.StatusNotFound)
+				return
+			}
+			slog.Error("admin: get conversation", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := db.SetConversationLanguage(phone, lang); err != nil {
+			slog.Error("admin: set language", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("admin: conversation language set", "phone", phone, "language", lang)
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"phone": phone, "language": lang})
+	}
+}
+
+// ─── GET /admin/messages/{id}/raw ─────────────────────────────────────────────
+
+// HandleMessageRaw returns what DeepSeek actually sent for an assistant
+// message, to diagnose odd replies and JSON-parse fallbacks.
+func HandleMessageRaw(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		raw, err := db.GetMessageRaw(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "message not found", http.StatusNotFound)
+				return
+			}
+			slog.Error("admin: get raw message", "wamid", id, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"id": id, "raw_llm_response": raw})
+	}
+}
+
+// ─── GET /admin/media/{id} ────────────────────────────────────────────────────
+
+// HandleMedia streams a customer's photo or document from Meta, so Slack
+// notices can link to it without exposing the Meta access token.
+func HandleMedia(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		resp, err := fetchMedia(r.Context(), cfg, id)
+		if err != nil {
+			slog.Error("admin: fetch media", "media_id", id, "err", err)
+			http.Error(w, "media unavailable", http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		for _, h := range []string{"Content-Type", "Content-Length"} {
+			if v := resp.Header.Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		// The customer chose the bytes and Meta the type, so never let the
+		// browser render them on our origin.
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", mediaDisposition(resp.Header.Get("Content-Disposition")))
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			slog.Warn("admin: stream media", "media_id", id, "err", err)
+		}
+	}
+}
+
+// mediaDisposition forces a download, keeping the filename from Meta's
+// Content-Disposition header when it sent one.
+func mediaDisposition(upstream string) string {
+	if _, params, err := mime.ParseMediaType(upstream); err == nil && params["filename"] != "" {
+		if v := mime.FormatMediaType("attachment", map[string]string{"filename": params["filename"]}); v != "" {
+			return v
+		}
+	}
+	return "attachment"
+}
+
+// ─── GET /admin/failures ──────────────────────────────────────────────────────
+
+// HandleOutboundFailures lists recent WhatsApp sends Meta didn't accept so
+// ops can replay them manually, and conversations whose Slack handoff failed
+// so the quote can be passed on by hand. ?limit= caps each list (default 50,
+// max 500).
+func HandleOutboundFailures(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 500)
+		}
+
+		failures, err := db.ListOutboundFailures(limit)
+		if err != nil {
+			slog.Error("admin: list outbound failures", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if failures == nil {
+			failures = []models.OutboundFailure{}
+		}
+		handoffs, err := db.ListFailedHandoffs(limit)
+		if err != nil {
+			slog.Error("admin: list failed handoffs", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if handoffs == nil {
+			handoffs = []models.FailedHandoff{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"failures": failures, "handoffs": handoffs})
+	}
+}
+
+// ─── GET /admin/events ────────────────────────────────────────────────────────
+
+// HandleWebhookEvents lists the most recent raw inbound webhook bodies for
+// debugging Meta delivery. ?limit= caps the list (default 50, max 500).
+func HandleWebhookEvents(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 500)
+		}
+
+		events, err := db.ListWebhookEvents(limit)
+		if err != nil {
+			slog.Error("admin: list webhook events", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if events == nil {
+			events = []models.WebhookEvent{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"events": events})
+	}
+}
+
+// ─── GET /admin/usage ─────────────────────────────────────────────────────────
+
+// HandleUsage reports LLM token usage in total and for the ?limit= heaviest
+// conversations (default 50, max 500), for attributing cost.
+func HandleUsage(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 500)
+		}
+
+		calls, prompt, completion, err := db.TotalUsage()
+		if err != nil {
+			slog.Error("admin: total usage", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		conversations, err := db.ListUsage(limit)
+		if err != nil {
+			slog.Error("admin: list usage", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if conversations == nil {
+			conversations = []models.ConversationUsage{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{
+			"total": map[string]int{
+				"calls":             calls,
+				"prompt_tokens":     prompt,
+				"completion_tokens": completion,
+			},
+			"conversations": conversations,
+		})
+	}
+}
+
+// ─── GET /admin/stats ─────────────────────────────────────────────────────────
+
+// HandleStats reports conversation volume, funnel counts (overall and per
+// prompt variant) and inbound message types over the last ?days= days
+// (default 7, max 365).
+func HandleStats(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days := 7
+		if raw := r.URL.Query().Get("days"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > 365 {
+				http.Error(w, "days must be an integer from 1 to 365", http.StatusBadRequest)
+				return
+			}
+			days = n
+		}
+
+		stats, err := db.ActivityStats(time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			slog.Error("admin: activity stats", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"days": days, "stats": stats})
+	}
+}
+
+// ─── GET /admin/search ────────────────────────────────────────────────────────
+
+// HandleSearch finds messages containing ?q= ("piano") across conversations
+// and groups them by phone, most recent match first. ?limit= caps the number
+// of messages (default 50, max 500).
+func HandleSearch(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 500)
+		}
+
+		msgs, err := db.SearchMessages(q, limit)
+		if err != nil {
+			slog.Error("admin: search messages", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		type match struct {
+			ID        string `json:"id"`
+			Role      string `json:"role"`
+			Content   string `json:"content"`
+			CreatedAt string `json:"created_at"`
+		}
+		type group struct {
+			Phone    string  `json:"phone"`
+			Messages []match `json:"messages"`
+		}
+		results := []*group{}
+		byPhone := map[string]*group{}
+		for _, m := range msgs {
+			g, ok := byPhone[m.ConversationID]
+			if !ok {
+				g = &group{Phone: m.ConversationID}
+				byPhone[m.ConversationID] = g
+				results = append(results, g)
+			}
+			g.Messages = append(g.Messages, match{
+				ID:        m.ID,
+				Role:      m.Role,
+				Content:   m.Content,
+				CreatedAt: m.CreatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"query": q, "results": results})
+	}
+}
+
+// ─── POST /admin/reload-prompt ────────────────────────────────────────────────
+
+// HandleReloadPrompt re-reads the system prompt YAML (or every variant) so
+// prompt tuning doesn't need a redeploy. A file that fails to parse is
+// rejected with 400 and the current prompts stay in use.
+func HandleReloadPrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, err := llm.ReloadPrompt()
+		if err != nil {
+			slog.Error("admin: reload prompt", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"identity": identity, "variants": llm.Variants()})
+	}
+}
+
+// ─── Reload on SIGHUP ─────────────────────────────────────────────────────────
+
+var (
+	reloadMu sync.Mutex
+	applied  config.Reloadable // what ApplyReloadable last pushed out
+)
+
+// ApplyReloadable pushes the settings that may change at runtime to the llm
+// and logging packages. main calls it at startup, Reload on each SIGHUP.
+func ApplyReloadable(settings config.Reloadable) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	applyReloadable(settings)
+}
+
+func applyReloadable(settings config.Reloadable) {
+	logging.SetLevel(settings.LogLevel)
+	llm.SetRetryPolicy(settings.LLMMaxAttempts, settings.LLMRetryBaseDelay)
+	llm.SetTokenBudget(settings.LLMMaxPromptTokens)
+	llm.SetTimeout(settings.LLMTimeout)
+	llm.SetJSONSchema(settings.LLMJSONSchema)
+	llm.SetDedupe(settings.LLMDedupe)
+	applied = settings
+}
+
+// Reload re-reads the system prompt and applies next's reloadable settings,
+// logging what changed. next is a freshly loaded config; its other values
+// are ignored. If the prompt fails to compile nothing changes.
+func Reload(ctx context.Context, next *config.Config) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	identity, err := llm.ReloadPrompt()
+	if err != nil {
+		return fmt.Errorf("reload prompt: %w", err)
+	}
+	settings := next.Reloadable()
+	changes := settings.Changes(applied)
+	applyReloadable(settings)
+	slog.InfoContext(ctx, "admin: reloaded config and prompt", "changes", changes, "identity", identity, "variants", llm.Variants(), "event", "reload")
+	return nil
+}
+
+// ─── GET /admin/conversations/{phone}/export ──────────────────────────────────
+
+// HandleExportConversation returns a conversation's full transcript, latest
+// quote and staff notes for review. ?format=csv gives role,content,created_at
+// rows (transcript only); the default JSON nests the quote and notes.
+func HandleExportConversation(db *database.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, err := normalizePhone(mux.Vars(r)["phone"], cfg.DefaultCountryCode)
+		if err != nil {
+			http.Error(w, "invalid phone number", http.StatusBadRequest)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "csv" {
+			http.Error(w, "format must be json or csv", http.StatusBadRequest)
+			return
+		}
+
+		conv, err := db.GetConversation(phone)
+		if err != nil {
+			if errors.Is(err, database.ErrConversationNotFound) {
+				http.Error(w, "conversation not found", http.StatusNotFound)
+				return
+			}
+			slog.Error("admin: get conversation", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		msgs, err := db.GetAllMessages(phone)
+		if err != nil {
+			slog.Error("admin: get messages", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="conversation-%s.csv"`, phone))
+			cw := csv.NewWriter(w)
+			_ = cw.Write([]string{"role", "content", "created_at"})
+			for _, m := range msgs {
+				_ = cw.Write([]string{m.Role, csvCell(m.Content), m.CreatedAt.UTC().Format(time.RFC3339)})
+			}
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				slog.Error("admin: write csv export", "phone", phone, "err", err)
+			}
+			return
+		}
+
+		quote, err := db.GetQuoteData(phone)
+		if err != nil {
+			slog.Error("admin: get quote", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		notes, err := db.GetNotes(phone)
+		if err != nil {
+			slog.Error("admin: get notes", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if notes == nil {
+			notes = []models.ConversationNote{}
+		}
+		messages := make([]map[string]any, 0, len(msgs))
+		for _, m := range msgs {
+			messages = append(messages, map[string]any{
+				"id":         m.ID,
+				"role":       m.Role,
+				"content":    m.Content,
+				"created_at": m.CreatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{
+			"phone":    phone,
+			"status":   conv.Status,
+			"stage":    conv.Stage,
+			"messages": messages,
+			"quote":    quote,
+			"notes":    notes,
+		})
+	}
+}
+
+// csvCell quotes customer text that a spreadsheet would run as a formula.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// ─── GET /admin/conversations/{phone} ─────────────────────────────────────────
+
+// transcriptPage renders a conversation as a plain HTML table, linked from
+// Slack handoffs. html/template escapes the message content.
+var transcriptPage = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>+{{.Phone}}</title></head>
+<body>
+<h1>{{if .Name}}{{.Name}} {{end}}+{{.Phone}}</h1>
+<p>Status: {{.Status}} · Stage: {{.Stage}}</p>
+<table border="1" cellpadding="4">
+<tr><th>Time (UTC)</th><th>From</th><th>Message</th></tr>
+{{range .Messages}}<tr><td>{{.Time}}</td><td>{{.Role}}</td><td style="white-space: pre-wrap">{{.Content}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type transcriptRow struct {
+	Time, Role, Content string
+}
+
+// HandleTranscript serves a conversation's full history as HTML, for staff
+// following the link on a Slack handoff.
+func HandleTranscript(db *database.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, err := normalizePhone(mux.Vars(r)["phone"], cfg.DefaultCountryCode)
+		if err != nil {
+			http.Error(w, "invalid phone number", http.StatusBadRequest)
+			return
+		}
+		conv, err := db.GetConversation(phone)
+		if err != nil {
+			if errors.Is(err, database.ErrConversationNotFound) {
+				http.Error(w, "conversation not found", http.StatusNotFound)
+				return
+			}
+			slog.Error("admin: get conversation", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		msgs, err := db.GetAllMessages(phone)
+		if err != nil {
+			slog.Error("admin: get messages", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		rows := make([]transcriptRow, 0, len(msgs))
+		for _, m := range msgs {
+			at := m.SentAt
+			if at.IsZero() {
+				at = m.CreatedAt
+			}
+			rows = append(rows, transcriptRow{Time: at.UTC().Format("2006-01-02 15:04:05"), Role: m.Role, Content: m.Content})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := transcriptPage.Execute(w, map[string]any{
+			"Phone":    phone,
+			"Name":     conv.DisplayName,
+			"Status":   conv.Status,
+			"Stage":    conv.Stage,
+			"Messages": rows,
+		}); err != nil {
+			slog.Error("admin: render transcript", "phone", phone, "err", err)
+		}
+	}
+}
+
+// ─── GET /admin/quote/{phone} ─────────────────────────────────────────────────
+
+// HandleQuote returns a conversation's parsed quote data alongside its status.
+// 404 if the conversation is unknown or no quote data has been extracted yet.
+func HandleQuote(db *database.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, err := normalizePhone(mux.Vars(r)["phone"], cfg.DefaultCountryCode)
+		if err != nil {
+			http.Error(w, "invalid phone number", http.StatusBadRequest)
+			return
+		}
+
+		conv, err := db.GetConversation(phone)
+		if err != nil {
+			if errors.Is(err, database.ErrConversationNotFound) {
+				http.Error(w, "conversation not found", http.StatusNotFound)
+				return
+			}
+			slog.Error("admin: get conversation", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		quote, err := db.GetQuoteData(phone)
+		if err != nil {
+			slog.Error("admin: get quote", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if quote == nil {
+			http.Error(w, "no quote data", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{
+			"phone":    phone,
+			"status":   conv.Status,
+			"stage":    conv.Stage,
+			"quote":    quote,
+			"complete": quote.IsComplete(),
+		})
+	}
+}
+
+// ─── POST /admin/conversations/{phone}/notes ──────────────────────────────────
+
+// Limits on staff notes.
+const (
+	maxNoteChars   = 2000
+	maxAuthorChars = 100
+)
+
+// HandleAddNote attaches a staff note ("quoted $400, thinking it over") to a
+// conversation. Body: {"author": "...", "note": "..."}.
+func HandleAddNote(db *database.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, err := normalizePhone(mux.Vars(r)["phone"], cfg.DefaultCountryCode)
+		if err != nil {
+			http.Error(w, "invalid phone number", http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Author string `json:"author"`
+			Note   string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		author, note := strings.TrimSpace(body.Author), strings.TrimSpace(body.Note)
+		switch {
+		case author == "":
+			http.Error(w, "author is required", http.StatusBadRequest)
+			return
+		case utf8.RuneCountInString(author) > maxAuthorChars:
+			http.Error(w, fmt.Sprintf("author must be at most %d characters", maxAuthorChars), http.StatusBadRequest)
+			return
+		case note == "":
+			http.Error(w, "note is required", http.StatusBadRequest)
+			return
+		case utf8.RuneCountInString(note) > maxNoteChars:
+			http.Error(w, fmt.Sprintf("note must be at most %d characters", maxNoteChars), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := db.GetConversationStatus(phone); err != nil {
+			if errors.Is(err, database.ErrConversationNotFound) {
+				http.Error(w, "conversation not found", http.StatusNotFound)
+				return
+			}
+			slog.Error("admin: get conversation", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		id, err := db.AddNote(phone, author, note)
+		if err != nil {
+			slog.Error("admin: add note", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("admin: note added", "phone", phone, "author", author)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]any{"id": id, "phone": phone, "author": author, "note": note})
+	}
+}
+
+// ─── POST /admin/conversations/{phone}/handoff ────────────────────────────────
+
+// HandleManualHandoff posts a Slack handoff for a conversation the bot didn't
+// escalate, using its latest quote data. Fields not extracted yet show as
+// unknown. 502 if Slack rejects it.
+func HandleManualHandoff(db *database.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, err := normalizePhone(mux.Vars(r)["phone"], cfg.DefaultCountryCode)
+		if err != nil {
+			http.Error(w, "invalid phone number", http.StatusBadRequest)
+			return
+		}
+
+		conv, err := db.GetConversation(phone)
+		if err != nil {
+			if errors.Is(err, database.ErrConversationNotFound) {
+				http.Error(w, "conversation not found", http.StatusNotFound)
+				return
+			}
+			slog.Error("admin: get conversation", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		quote, err := db.GetQuoteData(phone)
+		if err != nil {
+			slog.Error("admin: get quote", "phone", phone, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if quote == nil {
+			quote = &models.ExtractedData{Address: "unknown", ElevatorAccess: "unknown", Stairs: "unknown", Inventory: "unknown"}
+		}
+
+		ctx := r.Context()
+		if err := sendSlackHandoff(ctx, db, cfg, phone, conv.DisplayName, &models.LLMResponse{ExtractedData: *quote}); err != nil {
+			slog.Error("admin: manual handoff failed", "phone", phone, "event", "handoff_failed", "err", err)
+			if err := db.MarkHandoffFailed(phone); err != nil {
+				slog.Error("admin: mark handoff failed", "phone", phone, "err", err)
+			}
+			http.Error(w, "slack handoff failed", http.StatusBadGateway)
+			return
+		}
+		if err := db.ClearHandoffFailed(phone); err != nil {
+			slog.Error("admin: clear handoff failed", "phone", phone, "err", err)
+		}
+		metrics.SlackHandoffs.Inc()
+		events.Publish(events.Event{Type: events.Handoff, Phone: phone})
+		advanceStage(ctx, db, phone, models.StageQuoted)
+
+		slog.Info("admin: manual handoff sent", "phone", phone, "event", "manual_handoff")
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"phone": phone, "handoff": "sent"})
+	}
+}
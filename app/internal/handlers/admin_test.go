@@ -0,0 +1,843 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"clearoutspaces/internal/llm"
+	"clearoutspaces/internal/metrics"
+	"clearoutspaces/internal/models"
+)
+
+// adminRequest builds a request carrying the test admin token.
+func adminRequest(method, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	return req
+}
+
+// serveAdmin routes req through a mux router so path variables resolve.
+func serveAdmin(pattern string, h http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc(pattern, h)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// ─── Admin auth ───────────────────────────────────────────────────────────────
+
+func TestRequireAdmin(t *testing.T) {
+	cfg := testConfig()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	cases := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"valid token", "test-admin-token", "Bearer test-admin-token", http.StatusOK},
+		{"wrong token", "test-admin-token", "Bearer nope", http.StatusForbidden},
+		{"missing header", "test-admin-token", "", http.StatusForbidden},
+		{"admin disabled", "", "Bearer ", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg.AdminToken = tc.token
+			req := httptest.NewRequest(http.MethodGet, "/admin/completeness", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			RequireAdmin(cfg, ok)(w, req)
+			if w.Code != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin_SignedLink(t *testing.T) {
+	cfg := testConfig()
+	cfg.BaseURL = "https://bot.example.test"
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	now := time.Now()
+
+	link := signedAdminLink(cfg, "/admin/conversations/14165551234", now)
+	target := strings.TrimPrefix(link, cfg.BaseURL)
+	cases := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"signed", http.MethodGet, target, http.StatusOK},
+		{"other path", http.MethodGet, strings.Replace(target, "14165551234", "14165559999", 1), http.StatusForbidden},
+		{"not a GET", http.MethodPost, target, http.StatusForbidden},
+		{"expired", http.MethodGet, strings.TrimPrefix(signedAdminLink(cfg, "/admin/conversations/14165551234", now.Add(-2*adminLinkTTL)), cfg.BaseURL), http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			RequireAdmin(cfg, ok)(w, httptest.NewRequest(tc.method, tc.target, nil))
+			if w.Code != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+
+	// Signatures are keyed by ADMIN_TOKEN, so rotating it revokes old links.
+	cfg.AdminToken = "rotated"
+	w := httptest.NewRecorder()
+	RequireAdmin(cfg, ok)(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected a link signed with the old token rejected, got %d", w.Code)
+	}
+}
+
+// ─── GET /admin/completeness ──────────────────────────────────────────────────
+
+func TestHandleCompleteness(t *testing.T) {
+	db := testDB(t)
+
+	seed := map[string]string{
+		"14165550001": `{"address":"unknown","elevator_access":"unknown","stairs":"unknown","inventory":"unknown"}`,
+		"14165550002": `{"address":"123 Main St","elevator_access":"unknown","stairs":"unknown","inventory":"1 couch"}`,
+		"14165550003": `{"address":"123 Main St","elevator_access":"yes","stairs":"none","inventory":"1 couch"}`,
+	}
+	for phone, dump := range seed {
+		if err := db.UpsertConversation(phone); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpsertQuoteData(phone, dump); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	HandleCompleteness(db)(w, adminRequest(http.MethodGet, "/admin/completeness"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Total          int            `json:"total"`
+		RequiredFields int            `json:"required_fields"`
+		Complete       int            `json:"complete"`
+		ByFilled       map[string]int `json:"by_filled"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("response is not valid JSON: %v", err)
+	}
+	if body.Total != 3 || body.RequiredFields != 4 || body.Complete != 1 {
+		t.Errorf("unexpected totals: %+v", body)
+	}
+	if body.ByFilled["0"] != 1 || body.ByFilled["2"] != 1 || body.ByFilled["4"] != 1 {
+		t.Errorf("unexpected histogram: %v", body.ByFilled)
+	}
+}
+
+// ─── PUT /admin/conversations/{phone}/language ────────────────────────────────
+
+func TestHandleSetLanguage(t *testing.T) {
+	cfg := testConfig()
+	cfg.DefaultCountryCode = "1"
+	db := testDB(t)
+	if err := db.UpsertConversation("14165551234"); err != nil {
+		t.Fatal(err)
+	}
+	const pattern = "/admin/conversations/{phone}/language"
+
+	cases := []struct {
+		name  string
+		phone string
+		body  string
+		want  int
+	}{
+		{"override", "14165551234", `{"language":"fr"}`, http.StatusOK},
+		{"bare local number", "416-555-1234", `{"language":"fr"}`, http.StatusOK},
+		{"invalid phone", "not-a-phone", `{"language":"fr"}`, http.StatusBadRequest},
+		{"invalid code", "14165551234", `{"language":"french"}`, http.StatusBadRequest},
+		{"unknown phone", "19995550000", `{"language":"fr"}`, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := adminRequest(http.MethodPut, "/admin/conversations/"+tc.phone+"/language")
+			req.Body = io.NopCloser(strings.NewReader(tc.body))
+			w := serveAdmin(pattern, HandleSetLanguage(db, cfg), req)
+			if w.Code != tc.want {
+				t.Errorf("expected %d, got %d: %s", tc.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	lang, err := db.GetConversationLanguage("14165551234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lang != "fr" {
+		t.Errorf("expected stored language fr, got %q", lang)
+	}
+}
+
+// ─── GET /admin/messages/{id}/raw ─────────────────────────────────────────────
+
+func TestHandleMessageRaw(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	newMetaStub(t)
+	newLLMStub(t, `not json at all`)
+
+	phone := "14165554444"
+	handleMessage(context.Background(), db, cfg, textMessage(phone, "wamid.raw1", "Hello"), inboundMeta{})
+
+	history, err := db.GetRecentMessages(phone, 20)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("expected user + assistant messages, got %d (%v)", len(history), err)
+	}
+	assistantID := history[1].ID
+
+	w := serveAdmin("/admin/messages/{id}/raw", HandleMessageRaw(db), adminRequest(http.MethodGet, "/admin/messages/"+assistantID+"/raw"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if body["raw_llm_response"] != "not json at all" {
+		t.Errorf("expected raw DeepSeek content, got %q", body["raw_llm_response"])
+	}
+
+	w = serveAdmin("/admin/messages/{id}/raw", HandleMessageRaw(db), adminRequest(http.MethodGet, "/admin/messages/nope/raw"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown message, got %d", w.Code)
+	}
+}
+
+// ─── GET /admin/media/{id} ────────────────────────────────────────────────────
+
+func TestHandleMedia_ProxiesFromMeta(t *testing.T) {
+	cfg := testConfig()
+	cfg.BaseURL = "https://bot.example.test"
+	newMetaStub(t)
+
+	link := signedAdminLink(cfg, "/admin/media/media-42", time.Now())
+	req := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(link, cfg.BaseURL), nil)
+	w := serveAdmin("/admin/media/{id}", RequireAdmin(cfg, HandleMedia(cfg)), req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("expected image/jpeg, got %q", ct)
+	}
+	if got := w.Body.String(); got != "jpeg:Bearer "+cfg.MetaAccessToken {
+		t.Errorf("expected the media downloaded with the access token, got %q", got)
+	}
+}
+
+func TestHandleMedia_ForcesDownload(t *testing.T) {
+	cases := []struct {
+		contentType, disposition, want string
+	}{
+		{"text/html", "", "attachment"},
+		{"image/svg+xml", `inline; filename="quote.svg"`, `attachment; filename=quote.svg`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.BaseURL = "https://bot.example.test"
+			var srv *httptest.Server
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasPrefix(r.URL.Path, "/media/") { // media lookup
+					fmt.Fprintf(w, `{"url":%q}`, srv.URL+"/media"+r.URL.Path)
+					return
+				}
+				w.Header().Set("Content-Type", tc.contentType)
+				if tc.disposition != "" {
+					w.Header().Set("Content-Disposition", tc.disposition)
+				}
+				w.Write([]byte("<script>alert(1)</script>"))
+			}))
+			t.Cleanup(srv.Close)
+			prev := metaAPIBaseURL
+			metaAPIBaseURL = srv.URL
+			t.Cleanup(func() { metaAPIBaseURL = prev })
+
+			link := signedAdminLink(cfg, "/admin/media/media-42", time.Now())
+			req := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(link, cfg.BaseURL), nil)
+			w := serveAdmin("/admin/media/{id}", RequireAdmin(cfg, HandleMedia(cfg)), req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("expected nosniff, got %q", got)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tc.want {
+				t.Errorf("expected Content-Disposition %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+// ─── GET /admin/usage ─────────────────────────────────────────────────────────
+
+func TestHandleUsage_RecordsLLMUsage(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	newMetaStub(t)
+	newLLMStub(t, continueReply)
+
+	phone := "14165558383"
+	handleMessage(context.Background(), db, cfg, textMessage(phone, "wamid.us1", "A couch"), inboundMeta{})
+	handleMessage(context.Background(), db, cfg, textMessage(phone, "wamid.us2", "And a chair"), inboundMeta{})
+
+	w := serveAdmin("/admin/usage", HandleUsage(db), adminRequest(http.MethodGet, "/admin/usage"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Total struct {
+			Calls            int `json:"calls"`
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"total"`
+		Conversations []models.ConversationUsage `json:"conversations"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if body.Total.Calls != 2 || body.Total.PromptTokens != 2*stubPromptTokens || body.Total.CompletionTokens != 2*stubCompletionTokens {
+		t.Errorf("expected two calls' usage in the total, got %+v", body.Total)
+	}
+	if len(body.Conversations) != 1 || body.Conversations[0].ConversationID != phone || body.Conversations[0].Calls != 2 {
+		t.Errorf("expected usage attributed to %s, got %+v", phone, body.Conversations)
+	}
+}
+
+// ─── GET /admin/stats ─────────────────────────────────────────────────────────
+
+func TestHandleStats(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	newMetaStub(t)
+	newSlackStub(t, cfg)
+
+	newLLMStub(t, `{"reply_to_user":"Passing you to the team!","extracted_data":{"address":"1 Main St","elevator_access":"yes","stairs":"no","inventory":"couch"},"action":"handoff"}`)
+	handleMessage(context.Background(), db, cfg, textMessage("14165556161", "wamid.st1", "Quote please"), inboundMeta{})
+	newLLMStub(t, `{"reply_to_user":"Let's book it.","extracted_data":{"address":"2 Main St","elevator_access":"yes","stairs":"no","inventory":"desk"},"action":"schedule"}`)
+	handleMessage(context.Background(), db, cfg, textMessage("14165556262", "wamid.st2", "Friday works"), inboundMeta{})
+	newLLMStub(t, continueReply)
+	handleMessage(context.Background(), db, cfg, textMessage("14165556363", "wamid.st3", "Hi"), inboundMeta{})
+
+	w := serveAdmin("/admin/stats", HandleStats(db), adminRequest(http.MethodGet, "/admin/stats?days=7"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Days  int `json:"days"`
+		Stats struct {
+			Conversations int `json:"conversations"`
+			Messages      int `json:"messages"`
+			Handoffs      int `json:"handoffs"`
+			Schedules     int `json:"schedules"`
+		} `json:"stats"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if body.Days != 7 || body.Stats.Conversations != 3 || body.Stats.Messages != 6 || body.Stats.Handoffs != 1 || body.Stats.Schedules != 1 {
+		t.Errorf("unexpected stats: %+v", body)
+	}
+
+	for _, q := range []string{"?days=0", "?days=abc", "?days=400"} {
+		w = serveAdmin("/admin/stats", HandleStats(db), adminRequest(http.MethodGet, "/admin/stats"+q))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestHandleStats_CountsMessageTypes(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	newMetaStub(t)
+	newLLMStub(t, continueReply)
+
+	phone := "14165556464"
+	before := promtest.ToFloat64(metrics.MessagesByType.WithLabelValues("audio"))
+	for _, msg := range []*models.WAMessage{
+		textMessage(phone, "wamid.ty1", "Hi"),
+		textMessage(phone, "wamid.ty2", "Couch pickup?"),
+		{From: phone, ID: "wamid.ty3", Type: "audio"},
+		{From: phone, ID: "wamid.ty4", Type: "sticker"},
+		{From: phone, ID: "wamid.ty3", Type: "audio"}, // webhook retry
+	} {
+		handleMessage(context.Background(), db, cfg, msg, inboundMeta{})
+	}
+
+	w := serveAdmin("/admin/stats", HandleStats(db), adminRequest(http.MethodGet, "/admin/stats"))
+	var body struct {
+		Stats struct {
+			MessageTypes map[string]int `json:"message_types"`
+		} `json:"stats"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	want := map[string]int{"text": 2, "audio": 1, "sticker": 1}
+	if !maps.Equal(body.Stats.MessageTypes, want) {
+		t.Errorf("message_types = %v, want %v", body.Stats.MessageTypes, want)
+	}
+	if got := promtest.ToFloat64(metrics.MessagesByType.WithLabelValues("audio")) - before; got != 1 {
+		t.Errorf("expected 1 audio message counted in metrics, got %v", got)
+	}
+}
+
+// ─── GET /admin/search ────────────────────────────────────────────────────────
+
+func TestHandleSearch(t *testing.T) {
+	db := testDB(t)
+	for _, m := range []*models.Message{
+		{ID: "q1", ConversationID: "14165551111", Role: "user", Content: "There's a piano in the basement"},
+		{ID: "q2", ConversationID: "14165552222", Role: "user", Content: "Just boxes"},
+		{ID: "q3", ConversationID: "14165551111", Role: "assistant", Content: "Is the piano upright or grand?"},
+	} {
+		if err := db.UpsertConversation(m.ConversationID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := serveAdmin("/admin/search", HandleSearch(db), adminRequest(http.MethodGet, "/admin/search?q=piano"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Results []struct {
+			Phone    string `json:"phone"`
+			Messages []struct {
+				ID string `json:"id"`
+			} `json:"messages"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(body.Results) != 1 || body.Results[0].Phone != "14165551111" || len(body.Results[0].Messages) != 2 {
+		t.Errorf("expected both piano messages grouped under one phone, got %+v", body.Results)
+	}
+
+	w = serveAdmin("/admin/search", HandleSearch(db), adminRequest(http.MethodGet, "/admin/search?q=%25"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"results":[]`) {
+		t.Errorf("expected no results for a literal %%, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = serveAdmin("/admin/search", HandleSearch(db), adminRequest(http.MethodGet, "/admin/search?q=+"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a blank query, got %d", w.Code)
+	}
+}
+
+// ─── GET /admin/failures ──────────────────────────────────────────────────────
+
+func TestHandleOutboundFailures_RecordsRejectedSends(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, `{"error":{"message":"temporarily unavailable"}}`)
+	}))
+	defer srv.Close()
+	prev := metaAPIBaseURL
+	metaAPIBaseURL = srv.URL
+	defer func() { metaAPIBaseURL = prev }()
+
+	sendWhatsApp(context.Background(), db, cfg, "14165556666", "Your quote is ready!")
+
+	w := serveAdmin("/admin/failures", HandleOutboundFailures(db), adminRequest(http.MethodGet, "/admin/failures?limit=10"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Failures []struct {
+			ConversationID string `json:"conversation_id"`
+			Body           string `json:"body"`
+			StatusCode     int    `json:"status_code"`
+			Error          string `json:"error"`
+		} `json:"failures"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(body.Failures) != 1 {
+		t.Fatalf("expected 1 failure, got %d", len(body.Failures))
+	}
+	f := body.Failures[0]
+	if f.ConversationID != "14165556666" || f.Body != "Your quote is ready!" || f.StatusCode != 503 {
+		t.Errorf("unexpected failure record: %+v", f)
+	}
+	if !strings.Contains(f.Error, "temporarily unavailable") {
+		t.Errorf("expected Meta error body recorded, got %q", f.Error)
+	}
+
+	w = serveAdmin("/admin/failures", HandleOutboundFailures(db), adminRequest(http.MethodGet, "/admin/failures?limit=abc"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for bad limit, got %d", w.Code)
+	}
+}
+
+func TestHandleOutboundFailures_ListsFailedHandoffs(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	meta := newMetaStub(t)
+	newLLMStub(t, `{"reply_to_user":"Passing you to the team!","extracted_data":{"address":"1 Main St","elevator_access":"yes","stairs":"no","inventory":"couch"},"action":"handoff"}`)
+	slackUp := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slackUp {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	cfg.SlackWebhookURL = srv.URL
+
+	handleMessage(context.Background(), db, cfg, textMessage("14165557777", "wamid.hf1", "Book it"), inboundMeta{})
+	if n := len(meta.messages()); n != 1 {
+		t.Fatalf("expected the customer reply despite the Slack failure, got %d sends", n)
+	}
+	conv, err := db.GetConversation("14165557777")
+	if err != nil {
+		t.Fatalf("get conversation: %v", err)
+	}
+	if conv.HandoffFailedAt.IsZero() {
+		t.Fatal("expected the conversation flagged as a failed handoff")
+	}
+
+	w := serveAdmin("/admin/failures", HandleOutboundFailures(db), adminRequest(http.MethodGet, "/admin/failures"))
+	var body struct {
+		Handoffs []models.FailedHandoff `json:"handoffs"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(body.Handoffs) != 1 || body.Handoffs[0].ConversationID != "14165557777" || body.Handoffs[0].Stage != conv.Stage {
+		t.Fatalf("expected the failed handoff listed, got %+v", body.Handoffs)
+	}
+
+	// The next handoff that goes through clears the flag.
+	slackUp = true
+	handleMessage(context.Background(), db, cfg, textMessage("14165557777", "wamid.hf2", "Any update?"), inboundMeta{})
+	if conv, _ := db.GetConversation("14165557777"); !conv.HandoffFailedAt.IsZero() {
+		t.Error("expected the flag cleared after a successful handoff")
+	}
+	w = serveAdmin("/admin/failures", HandleOutboundFailures(db), adminRequest(http.MethodGet, "/admin/failures"))
+	if !strings.Contains(w.Body.String(), `"handoffs":[]`) {
+		t.Errorf("expected no failed handoffs listed, got %s", w.Body.String())
+	}
+}
+
+// ─── Reload on SIGHUP ─────────────────────────────────────────────────────────
+
+func TestReload_AppliesSettingsAndPrompt(t *testing.T) {
+	// The llm package defaults, restored afterwards.
+	base := testConfig()
+	base.LLMMaxAttempts, base.LLMRetryBaseDelay, base.LLMTimeout, base.LLMMaxPromptTokens = 3, 500*time.Millisecond, 30*time.Second, 8000
+	ApplyReloadable(base.Reloadable())
+	t.Cleanup(func() { ApplyReloadable(base.Reloadable()); llm.SetSystemPromptForTest("You are a test assistant.") })
+
+	path := filepath.Join(t.TempDir(), "system_prompt.yaml")
+	writePrompt := func(identity string) {
+		yaml := "identity: \"" + identity + "\"\nbusiness_rules:\n  - \"Be nice.\"\nquote_fields_needed:\n  - address\nworkflow: \"Ask one question.\"\n"
+		if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePrompt("You are version one.")
+	llm.LoadPrompt(path)
+
+	next := testConfig()
+	*next = *base
+	next.LLMTimeout = 12 * time.Second
+	writePrompt("You are version two.")
+	if err := Reload(context.Background(), next); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := llm.CallTimeout(); got != 12*time.Second+5*time.Second {
+		t.Errorf("expected the new LLM timeout applied, got call timeout %s", got)
+	}
+	if !strings.HasPrefix(llm.SystemPromptFor(""), "You are version two.") {
+		t.Errorf("expected the prompt reloaded, got %q", llm.SystemPromptFor(""))
+	}
+
+	// A broken prompt leaves everything as it was.
+	if err := os.WriteFile(path, []byte("identity: [unclosed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	broken := testConfig()
+	*broken = *base
+	broken.LLMTimeout = time.Second
+	if err := Reload(context.Background(), broken); err == nil {
+		t.Fatal("expected an error for an invalid prompt")
+	}
+	if got := llm.CallTimeout(); got != 12*time.Second+5*time.Second {
+		t.Errorf("expected settings kept after a failed reload, got call timeout %s", got)
+	}
+}
+
+// ─── GET /admin/conversations/{phone} ─────────────────────────────────────────
+
+func TestHandleTranscript(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	phone := "14165558282"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.SetConversationDisplayName(phone, "Sam Rivera")
+	_ = db.InsertMessage(&models.Message{ID: "wamid.tr1", ConversationID: phone, Role: "user", Content: "<b>couch</b> & chair"})
+	_ = db.InsertMessage(&models.Message{ID: "assistant-wamid.tr1", ConversationID: phone, Role: "assistant", Content: "What's the address?"})
+
+	const pattern = "/admin/conversations/{phone}"
+	h := HandleTranscript(db, cfg)
+
+	w := serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/conversations/+"+phone))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("expected HTML, got %q", ct)
+	}
+	html := w.Body.String()
+	for _, want := range []string{"Sam Rivera +14165558282", "&lt;b&gt;couch&lt;/b&gt; &amp; chair", "<td>assistant</td>", "What&#39;s the address?"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected transcript to contain %q:\n%s", want, html)
+		}
+	}
+	if strings.Index(html, "couch") > strings.Index(html, "address") {
+		t.Error("expected messages oldest first")
+	}
+
+	w = serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/conversations/14165550000"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown conversation: expected 404, got %d", w.Code)
+	}
+}
+
+// ─── GET /admin/conversations/{phone}/export ──────────────────────────────────
+
+func TestHandleExportConversation(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	newMetaStub(t)
+	newLLMStub(t, `{"reply_to_user":"What's the address?","extracted_data":{"address":"unknown","elevator_access":"unknown","stairs":"unknown","inventory":"couch, fridge"},"action":"continue"}`)
+
+	phone := "14165558181"
+	handleMessage(context.Background(), db, cfg, textMessage(phone, "wamid.ex1", "A couch, and a fridge"), inboundMeta{})
+
+	const pattern = "/admin/conversations/{phone}/export"
+	h := HandleExportConversation(db, cfg)
+
+	w := serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/conversations/"+phone+"/export"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("json: expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+		Quote *struct {
+			Inventory string `json:"inventory"`
+		} `json:"quote"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(body.Messages) != 2 || body.Messages[0].Role != "user" || body.Messages[1].Role != "assistant" {
+		t.Errorf("expected ordered user/assistant messages, got %+v", body.Messages)
+	}
+	if body.Quote == nil || body.Quote.Inventory != "couch, fridge" {
+		t.Errorf("expected nested quote, got %+v", body.Quote)
+	}
+
+	w = serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/conversations/"+phone+"/export?format=csv"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("csv: expected 200, got %d", w.Code)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != "role,content,created_at" {
+		t.Fatalf("unexpected CSV:\n%s", w.Body.String())
+	}
+	if !strings.HasPrefix(lines[1], `user,"A couch, and a fridge",`) {
+		t.Errorf("expected quoted user row, got %q", lines[1])
+	}
+
+	handleMessage(context.Background(), db, cfg, textMessage(phone, "wamid.ex2", `=HYPERLINK("http://evil.example","x")`), inboundMeta{})
+	w = serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/conversations/"+phone+"/export?format=csv"))
+	if !strings.Contains(w.Body.String(), `user,"'=HYPERLINK(""http://evil.example"",""x"")",`) {
+		t.Errorf("expected the formula neutralised, got:\n%s", w.Body.String())
+	}
+
+	w = serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/conversations/14165550000/export"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown phone, got %d", w.Code)
+	}
+}
+
+// ─── GET /admin/quote/{phone} ─────────────────────────────────────────────────
+
+func TestHandleQuote(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	newMetaStub(t)
+	newLLMStub(t, `{"reply_to_user":"Any stairs?","extracted_data":{"address":"1 Main St","elevator_access":"yes","stairs":"unknown","inventory":"couch"},"action":"continue"}`)
+
+	phone := "14165558282"
+	handleMessage(context.Background(), db, cfg, textMessage(phone, "wamid.q1", "1 Main St, elevator, a couch"), inboundMeta{})
+
+	const pattern = "/admin/quote/{phone}"
+	h := HandleQuote(db, cfg)
+
+	w := serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/quote/"+phone))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Status   string `json:"status"`
+		Complete bool   `json:"complete"`
+		Quote    struct {
+			Address   string `json:"address"`
+			Stairs    string `json:"stairs"`
+			Inventory string `json:"inventory"`
+		} `json:"quote"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if body.Status != "ACTIVE" || body.Complete {
+		t.Errorf("expected ACTIVE incomplete quote, got %+v", body)
+	}
+	if body.Quote.Address != "1 Main St" || body.Quote.Stairs != "unknown" || body.Quote.Inventory != "couch" {
+		t.Errorf("unexpected quote: %+v", body.Quote)
+	}
+
+	// A conversation with no extracted data yet.
+	empty := "14165558383"
+	if err := db.UpsertConversation(empty); err != nil {
+		t.Fatal(err)
+	}
+	w = serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/quote/"+empty))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without quote data, got %d", w.Code)
+	}
+
+	w = serveAdmin(pattern, h, adminRequest(http.MethodGet, "/admin/quote/14165550000"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown phone, got %d", w.Code)
+	}
+}
+
+// ─── POST /admin/conversations/{phone}/notes ──────────────────────────────────
+
+func TestHandleAddNote(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	phone := "14165558888"
+	if err := db.UpsertConversation(phone); err != nil {
+		t.Fatal(err)
+	}
+	const pattern = "/admin/conversations/{phone}/notes"
+
+	cases := []struct {
+		name  string
+		phone string
+		body  string
+		want  int
+	}{
+		{"added", phone, `{"author":"adrian","note":"quoted $400, customer thinking it over"}`, http.StatusCreated},
+		{"missing author", phone, `{"author":"  ","note":"hi"}`, http.StatusBadRequest},
+		{"empty note", phone, `{"author":"adrian","note":""}`, http.StatusBadRequest},
+		{"note too long", phone, `{"author":"adrian","note":"` + strings.Repeat("x", maxNoteChars+1) + `"}`, http.StatusBadRequest},
+		{"unknown phone", "19995550000", `{"author":"adrian","note":"hi"}`, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := adminRequest(http.MethodPost, "/admin/conversations/"+tc.phone+"/notes")
+			req.Body = io.NopCloser(strings.NewReader(tc.body))
+			w := serveAdmin(pattern, HandleAddNote(db, cfg), req)
+			if w.Code != tc.want {
+				t.Errorf("expected %d, got %d: %s", tc.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	w := serveAdmin("/admin/conversations/{phone}/export", HandleExportConversation(db, cfg),
+		adminRequest(http.MethodGet, "/admin/conversations/"+phone+"/export"))
+	var body struct {
+		Notes []struct {
+			Author string `json:"author"`
+			Note   string `json:"note"`
+		} `json:"notes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(body.Notes) != 1 || body.Notes[0].Author != "adrian" || !strings.Contains(body.Notes[0].Note, "$400") {
+		t.Errorf("expected the note in the export, got %+v", body.Notes)
+	}
+}
+
+// ─── POST /admin/conversations/{phone}/handoff ────────────────────────────────
+
+func TestHandleManualHandoff(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	newMetaStub(t)
+	slack := newSlackStub(t, cfg)
+	newLLMStub(t, `{"reply_to_user":"Which floor?","extracted_data":{"address":"1 Main St","elevator_access":"unknown","stairs":"unknown","inventory":"piano"},"action":"continue"}`)
+
+	phone := "14165556363"
+	handleMessage(context.Background(), db, cfg, textMessage(phone, "wamid.mh1", "Can you move a piano from 1 Main St?"), inboundMeta{})
+	if n := len(slack()); n != 0 {
+		t.Fatalf("expected no handoff from the bot, got %d", n)
+	}
+
+	const pattern = "/admin/conversations/{phone}/handoff"
+	h := HandleManualHandoff(db, cfg)
+
+	w := serveAdmin(pattern, h, adminRequest(http.MethodPost, "/admin/conversations/"+phone+"/handoff"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	posted := slack()
+	if len(posted) != 1 || !strings.Contains(posted[0], "*Address:* 1 Main St") || !strings.Contains(posted[0], "*Inventory:* piano") {
+		t.Errorf("expected the handoff card with the stored quote, got %v", posted)
+	}
+	if conv, _ := db.GetConversation(phone); conv.Stage != models.StageQuoted {
+		t.Errorf("expected stage %s, got %s", models.StageQuoted, conv.Stage)
+	}
+
+	w = serveAdmin(pattern, h, adminRequest(http.MethodPost, "/admin/conversations/14165550000/handoff"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown phone, got %d", w.Code)
+	}
+	if n := len(slack()); n != 1 {
+		t.Errorf("expected no handoff for an unknown phone, got %d", n)
+	}
+}
@@ -2,13 +2,65 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+
+	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/version"
 )
 
-func HealthCheck(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]string{"status": "healthy"}); err != nil {
-		log.Printf("health: encode response: %v", err)
+// HealthCheck reports liveness along with a cheap check of each dependency
+// and the DB connection pool stats. Any failing check turns the status to
+// "degraded" with a 503.
+func HealthCheck(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := "healthy"
+		checks := map[string]string{"db": "ok"}
+		if err := db.Ping(); err != nil {
+			slog.Error("health: db ping", "err", err)
+			checks["db"] = "fail"
+			status = "degraded"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if status != "healthy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		stats := db.Stats()
+		dbStats := map[string]any{
+			"max_open_connections": stats.MaxOpenConnections,
+			"open_connections":     stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
+			"wait_count":           stats.WaitCount,
+			"wait_duration_ms":     stats.WaitDuration.Milliseconds(),
+		}
+		if err := json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks, "db_stats": dbStats}); err != nil {
+			slog.Error("health: encode response", "err", err)
+		}
+	}
+}
+
+// HandleReadiness reports whether the service can do useful work. It returns
+// 503 while the database is rejecting writes (disk full, read-only mount) so
+// orchestration can react instead of customer messages being dropped silently.
+func HandleReadiness(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := db.WriteHealth(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			writeJSON(w, map[string]string{"status": "unavailable", "db": err.Error()})
+			return
+		}
+		writeJSON(w, map[string]string{"status": "ready"})
+	}
+}
+
+// HandleVersion reports which build is running, so incidents can be matched
+// to deploys.
+func HandleVersion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, version.Get())
 	}
 }
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// waitFor polls cond until it holds or a second passes, for work the webhook
+// handler does on its own goroutine.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// slackButton finds a button by action_id in a posted Slack Block Kit payload.
+func slackButton(t *testing.T, raw, actionID string) (text, value string, ok bool) {
+	t.Helper()
+	var msg struct {
+		Blocks []struct {
+			Type     string `json:"type"`
+			Elements []struct {
+				ActionID string `json:"action_id"`
+				Value    string `json:"value"`
+				Text     struct {
+					Text string `json:"text"`
+				} `json:"text"`
+			} `json:"elements"`
+		} `json:"blocks"`
+	}
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		t.Fatalf("slack payload is not JSON: %v\n%s", err, raw)
+	}
+	for _, b := range msg.Blocks {
+		for _, e := range b.Elements {
+			if b.Type == "actions" && e.ActionID == actionID {
+				return e.Text.Text, e.Value, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// sentTexts returns the text bodies of the WhatsApp messages Meta received.
+func sentTexts(meta *metaStub) []string {
+	var texts []string
+	for _, m := range meta.messages() {
+		if text, ok := m["text"].(map[string]any); ok {
+			texts = append(texts, fmt.Sprint(text["body"]))
+		}
+	}
+	return texts
+}
+
+// TestIntegration_HandoffTakeoverPausedReply drives the whole contract over
+// HTTP: inbound webhook → LLM handoff → Slack handoff → Slack takeover →
+// static reply while paused.
+func TestIntegration_HandoffTakeoverPausedReply(t *testing.T) {
+	cfg := testConfig()
+	db := testDB(t)
+	meta := newMetaStub(t)
+	slack := newSlackStub(t, cfg)
+	llmStub := newLLMStub(t, `{"reply_to_user":"Thanks! Our team will send your quote shortly.","extracted_data":{"address":"12 King St W","elevator_access":"yes","stairs":"no","inventory":"sofa, 2 chairs"},"action":"handoff"}`)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/whatsapp/webhook", HandleWhatsAppMessage(testContext(t), db, cfg)).Methods(http.MethodPost)
+	r.HandleFunc("/slack/interactive", HandleSlackInteractive(db, cfg)).Methods(http.MethodPost)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	const phone = "14165553030"
+	inbound := func(id, text string) {
+		t.Helper()
+		body := []byte(fmt.Sprintf(`{"object":"whatsapp_business_account","entry":[{"changes":[{"value":{"messages":[{"from":%q,"id":%q,"type":"text","text":{"body":%q}}]},"field":"messages"}]}]}`, phone, id, text))
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/whatsapp/webhook", bytes.NewReader(body))
+		req.Header.Set("X-Hub-Signature-256", metaSignature(cfg.MetaAppSecret, body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post webhook: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("webhook: expected 200, got %d", resp.StatusCode)
+		}
+	}
+
+	// 1. Inbound message; the LLM decides to hand off.
+	inbound("wamid.int1", "Sofa and two chairs from 12 King St W, elevator, no stairs")
+	waitFor(t, "slack handoff", func() bool { return len(slack()) == 1 })
+	waitFor(t, "customer reply", func() bool { return len(sentTexts(meta)) == 1 })
+
+	if got := sentTexts(meta)[0]; got != "Thanks! Our team will send your quote shortly." {
+		t.Errorf("unexpected customer reply %q", got)
+	}
+	text, value, ok := slackButton(t, slack()[0], "take_over_chat")
+	if !ok {
+		t.Fatalf("expected a take_over_chat button, got %s", slack()[0])
+	}
+	if text != "Take Over Chat" || value != phone {
+		t.Errorf("expected Take Over Chat button for %s, got %q / %q", phone, text, value)
+	}
+
+	// 2. Staff clicks the button; Slack posts the interactive callback.
+	req := slackActionRequest(cfg, "take_over_chat", value)
+	req.RequestURI = ""
+	req.URL, _ = req.URL.Parse(srv.URL + "/slack/interactive")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post slack callback: %v", err)
+	}
+	var ack struct {
+		ReplaceOriginal bool   `json:"replace_original"`
+		Text            string `json:"text"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&ack)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !ack.ReplaceOriginal {
+		t.Fatalf("slack callback: expected 200 replacing the original, got %d %+v", resp.StatusCode, ack)
+	}
+
+	status, err := db.GetConversationStatus(phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "PAUSED" {
+		t.Fatalf("expected PAUSED after takeover, got %s", status)
+	}
+
+	// 3. The next inbound message gets the static reply, not the LLM.
+	inbound("wamid.int2", "Any update?")
+	waitFor(t, "static reply", func() bool { return len(sentTexts(meta)) == 2 })
+
+	if got := sentTexts(meta)[1]; got != "Our team is handling your request directly. We'll be in touch shortly!" {
+		t.Errorf("expected the paused static reply, got %q", got)
+	}
+	if n := len(llmStub.calls()); n != 1 {
+		t.Errorf("expected the LLM to be called once, got %d", n)
+	}
+	if n := len(slack()); n != 1 {
+		t.Errorf("expected no further Slack posts while paused, got %d", n)
+	}
+	exists, err := db.MessageExists("wamid.int2")
+	if err != nil || !exists {
+		t.Errorf("expected the paused message saved for audit, got %v, %v", exists, err)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"clearoutspaces/internal/config"
+	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/logging"
+)
+
+// outboundPollInterval is how often the worker looks for due retries; new
+// replies wake it immediately. A var so tests can shrink it.
+var outboundPollInterval = time.Second
+
+// outboundBatch caps how many queued messages one pass sends.
+const outboundBatch = 50
+
+// maxOutboundBackoff caps the delay between retries of one message.
+const maxOutboundBackoff = 10 * time.Minute
+
+var outboundWake = make(chan struct{}, 1)
+
+// wakeOutbound nudges the worker to deliver a just-queued message.
+func wakeOutbound() {
+	select {
+	case outboundWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutboundWorker delivers queued replies until ctx is cancelled. Anything
+// still pending at shutdown is picked up on the next start. Delivery is
+// at-least-once: a crash between Meta accepting a message and it being marked
+// sent resends it.
+func RunOutboundWorker(ctx context.Context, db *database.DB, cfg *config.Config) {
+	ticker := time.NewTicker(outboundPollInterval)
+	defer ticker.Stop()
+
+	for {
+		deliverDue(db, cfg, time.Now())
+		select {
+		case <-ctx.Done():
+			slog.Info("whatsapp: outbound worker stopped")
+			return
+		case <-ticker.C:
+		case <-outboundWake:
+		}
+	}
+}
+
+// deliverDue sends due queued messages until a pass sends nothing, returning
+// how many were sent. Rejections that can't succeed on retry (4xx other than
+// 429) and messages out of attempts are marked failed and logged to
+// outbound_failures.
+func deliverDue(db *database.DB, cfg *config.Config, now time.Time) int {
+	total := 0
+	for {
+		// Each pass sees at most one message per conversation (see
+		// DueOutbound), so follow-ups go out on the next pass.
+		sent, err := deliverPass(db, cfg, now)
+		if err != nil {
+			slog.Error("whatsapp: list due outbound messages", "err", err)
+		}
+		total += sent
+		if sent == 0 || err != nil {
+			return total
+		}
+	}
+}
+
+// deliverPass makes one attempt per due message.
+func deliverPass(db *database.DB, cfg *config.Config, now time.Time) (int, error) {
+	msgs, err := db.DueOutbound(now, outboundBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range msgs {
+		ctx := context.Background()
+		if m.TraceID != "" {
+			ctx = logging.WithTraceID(ctx, m.TraceID)
+		}
+		status, wamid, sendErr := postWhatsApp(ctx, cfg, m.ConversationID, textPayload(m.ConversationID, m.Body))
+		if sendErr == nil {
+			if err := db.MarkOutboundSent(m.ID, wamid); err != nil {
+				// Left pending, so it will be resent; don't loop on it now.
+				slog.ErrorContext(ctx, "whatsapp: mark outbound sent", "phone", m.ConversationID, "outbound_id", m.ID, "err", err)
+				continue
+			}
+			if m.MessageID != "" && wamid != "" {
+				if err := db.SetMessageWamid(m.MessageID, wamid); err != nil {
+					slog.ErrorContext(ctx, "whatsapp: record reply wamid", "phone", m.ConversationID, "outbound_id", m.ID, "err", err)
+				}
+			}
+			sent++
+			continue
+		}
+
+		attempts := m.Attempts + 1
+		if attempts >= cfg.OutboundMaxAttempts || permanentSendFailure(status) {
+			slog.ErrorContext(ctx, "whatsapp: giving up on outbound message", "phone", m.ConversationID, "outbound_id", m.ID, "attempts", attempts, "status", status, "event", "outbound_failed")
+			if err := db.MarkOutboundFailed(m.ID, sendErr.Error()); err != nil {
+				slog.ErrorContext(ctx, "whatsapp: mark outbound failed", "phone", m.ConversationID, "outbound_id", m.ID, "err", err)
+			}
+			recordOutboundFailure(ctx, db, m.ConversationID, m.Body, status, sendErr.Error())
+			continue
+		}
+
+		next := now.Add(outboundBackoff(cfg.OutboundRetryBaseDelay, attempts))
+		slog.WarnContext(ctx, "whatsapp: outbound send failed, will retry", "phone", m.ConversationID, "outbound_id", m.ID, "attempts", attempts, "next_attempt_at", next.UTC().Format(time.RFC3339))
+		if err := db.RetryOutbound(m.ID, next, sendErr.Error()); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: reschedule outbound", "phone", m.ConversationID, "outbound_id", m.ID, "err", err)
+		}
+	}
+	return sent, nil
+}
+
+// outboundBackoff returns base·2^(attempts-1), capped at maxOutboundBackoff.
+func outboundBackoff(base time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < maxOutboundBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxOutboundBackoff)
+}
+
+func permanentSendFailure(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusTooManyRequests
+}
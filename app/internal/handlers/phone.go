@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+)
+
+// E.164 allows at most 15 digits including the country code; anything under
+// 8 can't be a real subscriber number.
+const (
+	minPhoneDigits = 8
+	maxPhoneDigits = 15
+)
+
+var errInvalidPhone = errors.New("invalid phone number")
+
+// normalizePhone canonicalises a phone number to E.164 digits without the
+// leading "+" (e.g. "14165551234"), the form Meta uses for wa_id.
+//
+// Numbers written internationally ("+44 …" or "0044 …") keep their country
+// code. Otherwise, when defaultCountryCode is set, bare local numbers — those
+// with a trunk "0" prefix or 10 digits or fewer — get it prepended; longer
+// numbers are assumed to already include one.
+func normalizePhone(raw, defaultCountryCode string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+
+	var b strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", errInvalidPhone
+		}
+	}
+	digits := b.String()
+
+	if !international && strings.HasPrefix(digits, "00") {
+		digits = strings.TrimPrefix(digits, "00")
+		international = true
+	}
+	if !international && defaultCountryCode != "" {
+		switch {
+		case strings.HasPrefix(digits, "0"):
+			digits = defaultCountryCode + strings.TrimLeft(digits, "0")
+		case len(digits) <= 10:
+			digits = defaultCountryCode + digits
+		}
+	}
+
+	if len(digits) < minPhoneDigits || len(digits) > maxPhoneDigits || digits[0] == '0' {
+		return "", errInvalidPhone
+	}
+	return digits, nil
+}
+
+// countryTimezones maps calling codes to the timezone most of the country
+// uses; countries spanning several zones are left out. NANP numbers ("1")
+// go by area code in nanpTimezones instead.
+var countryTimezones = map[string]string{
+	"33":  "Europe/Paris",
+	"34":  "Europe/Madrid",
+	"44":  "Europe/London",
+	"49":  "Europe/Berlin",
+	"52":  "America/Mexico_City",
+	"57":  "America/Bogota",
+	"58":  "America/Caracas",
+	"593": "America/Guayaquil",
+}
+
+var nanpTimezones = map[string]string{
+	"416": "America/Toronto", "647": "America/Toronto", "437": "America/Toronto",
+	"905": "America/Toronto", "289": "America/Toronto", "365": "America/Toronto",
+	"613": "America/Toronto", "343": "America/Toronto",
+	"514": "America/Toronto", "438": "America/Toronto", "450": "America/Toronto",
+	"604": "America/Vancouver", "778": "America/Vancouver", "236": "America/Vancouver",
+	"403": "America/Edmonton", "587": "America/Edmonton", "780": "America/Edmonton", "825": "America/Edmonton",
+	"204": "America/Winnipeg", "431": "America/Winnipeg",
+	"902": "America/Halifax", "782": "America/Halifax",
+	"212": "America/New_York", "646": "America/New_York", "917": "America/New_York",
+}
+
+// phoneTimezone guesses a timezone from a normalized phone number's calling
+// code, or returns "" when it can't tell.
+func phoneTimezone(phone string) string {
+	if strings.HasPrefix(phone, "1") && len(phone) >= 4 {
+		return nanpTimezones[phone[1:4]]
+	}
+	for n := 3; n >= 1; n-- {
+		if len(phone) > n {
+			if tz, ok := countryTimezones[phone[:n]]; ok {
+				return tz
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+
+	"clearoutspaces/internal/llm"
+)
+
+// The sanitizer is deliberately conservative: it only removes content that a
+// customer should never see — leaked prompt/schema fragments and links to our
+// internal admin surface — and leaves ordinary prose untouched.
+var (
+	// schemaKeyRe matches the opening of a JSON object that uses one of the
+	// LLM contract keys, e.g. `{"reply_to_user": ...`.
+	schemaKeyRe = regexp.MustCompile(`\{\s*"(reply_to_user|extracted_data|action|address|elevator_access|stairs|inventory)"\s*:`)
+
+	builtinReplyPatterns = []*regexp.Regexp{
+		// Fenced code blocks (```json ... ```).
+		regexp.MustCompile("(?s)```.*?```"),
+		// Links into the admin API on any host.
+		regexp.MustCompile(`(?i)https?://\S*/admin\S*`),
+		// Echoes of the system prompt's output instruction.
+		regexp.MustCompile(`(?i)you must respond only with a valid json object[^\n]*`),
+	}
+
+	blankLinesRe = regexp.MustCompile(`\n{3,}`)
+)
+
+// sanitizeReply strips unsafe content from an outbound assistant reply.
+// extra holds operator-configured patterns (REPLY_BLOCKED_PATTERNS). The
+// second return value reports whether anything was removed.
+func sanitizeReply(reply string, extra []*regexp.Regexp) (string, bool) {
+	out := stripSchemaJSON(reply)
+	for _, re := range builtinReplyPatterns {
+		out = re.ReplaceAllString(out, "")
+	}
+	for _, re := range extra {
+		out = re.ReplaceAllString(out, "")
+	}
+	if out == reply {
+		return reply, false
+	}
+
+	out = blankLinesRe.ReplaceAllString(strings.TrimSpace(out), "\n\n")
+	if out == "" {
+		out = llm.EmptyReply()
+	}
+	return out, true
+}
+
+// stripSchemaJSON removes every JSON object that opens with an LLM contract
+// key. Objects are removed up to their balanced closing brace, or to the end
+// of the string when truncated.
+func stripSchemaJSON(s string) string {
+	for {
+		loc := schemaKeyRe.FindStringIndex(s)
+		if loc == nil {
+			return s
+		}
+		end := matchingBrace(s, loc[0])
+		s = s[:loc[0]] + s[end:]
+	}
+}
+
+// matchingBrace returns the index just past the '}' that closes the object
+// opening at s[start], skipping braces inside JSON strings.
+func matchingBrace(s string, start int) int {
+	depth := 0
+	inString := false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
@@ -1,29 +1,36 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"clearoutspaces/internal/config"
 	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/events"
 	"clearoutspaces/internal/models"
 )
 
-// HandleSlackInteractive processes the "Take Over Chat" button click from Slack.
+// HandleSlackInteractive processes the "Take Over Chat" and "Resume Bot"
+// button clicks from Slack.
 func HandleSlackInteractive(db *database.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 1. Read raw body first — required for signature verification.
 		rawBody, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "bad request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "bad_request", "bad request")
 			return
 		}
 
@@ -32,80 +39,280 @@ func HandleSlackInteractive(db *database.DB, cfg *config.Config) http.HandlerFun
 		signature := r.Header.Get("X-Slack-Signature")
 
 		if !verifySlackSignature(cfg.SlackSigningSecret, timestamp, rawBody, signature) {
-			log.Println("slack: invalid signature")
-			http.Error(w, "forbidden", http.StatusForbidden)
+			slog.Warn("slack: invalid signature", "event", "invalid_signature")
+			writeError(w, http.StatusForbidden, "invalid_signature", "invalid signature")
 			return
 		}
 
 		// 3. Decode form-encoded body and extract the JSON payload parameter.
 		formVals, err := url.ParseQuery(string(rawBody))
 		if err != nil {
-			http.Error(w, "bad request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "bad_request", "bad request")
 			return
 		}
 		payloadJSON := formVals.Get("payload")
 		if payloadJSON == "" {
-			http.Error(w, "missing payload", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "missing_payload", "missing payload")
 			return
 		}
 
 		var slackPayload models.SlackInteractivePayload
 		if err := json.Unmarshal([]byte(payloadJSON), &slackPayload); err != nil {
-			log.Printf("slack: unmarshal payload: %v", err)
-			http.Error(w, "bad request", http.StatusBadRequest)
+			slog.Error("slack: unmarshal payload", "err", err)
+			writeError(w, http.StatusBadRequest, "bad_request", "bad request")
 			return
 		}
 
 		if len(slackPayload.Actions) == 0 {
-			http.Error(w, "no actions", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "no_actions", "no actions")
 			return
 		}
 
 		action := slackPayload.Actions[0]
-		if action.ActionID != "take_over_chat" {
+		username := slackPayload.User.Username
+
+		switch action.ActionID {
+		case "take_over_chat", "resume_bot":
+			// Values are stored wa_ids; normalize as inbound does, without
+			// DEFAULT_COUNTRY_CODE, so short international numbers match.
+			phone, err := normalizePhone(action.Value, "")
+			if err != nil {
+				slog.Warn("slack: invalid phone in action value", "phone", action.Value)
+				w.Header().Set("Content-Type", "application/json")
+				writeJSON(w, map[string]any{"replace_original": true, "text": "⚠️ Conversation not found."})
+				return
+			}
+			if action.ActionID == "take_over_chat" {
+				takeOverChat(w, db, phone, username)
+			} else {
+				resumeBot(w, db, phone, username)
+			}
+		default:
 			w.WriteHeader(http.StatusOK)
-			return
 		}
+	}
+}
+
+// takeOverChat pauses the bot so staff can reply to the customer directly.
+func takeOverChat(w http.ResponseWriter, db *database.DB, phone, username string) {
+	// 4. Validate phone exists in DB before acting (prevents arbitrary pausing).
+	status, err := db.GetConversationStatus(phone)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		slog.Warn("slack: conversation not found", "phone", phone)
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"replace_original": true, "text": "⚠️ Conversation not found."})
+		return
+	}
+	if err != nil {
+		slog.Error("slack: get conversation", "phone", phone, "err", err)
+		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		return
+	}
+
+	if status == "PAUSED" {
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"replace_original": true, "text": "ℹ️ Chat was already paused."})
+		return
+	}
+
+	// 5. Pause the conversation.
+	if err := db.PauseConversation(phone, username); err != nil {
+		slog.Error("slack: pause conversation", "phone", phone, "err", err)
+		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		return
+	}
+
+	slog.Info("slack: conversation paused", "phone", phone, "actor", username, "event", "takeover")
+	events.Publish(events.Event{Type: events.StatusChanged, Phone: phone, Status: "PAUSED", Actor: username})
 
-		phone := action.Value
+	// 6. Respond to Slack within 3 seconds.
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]any{
+		"replace_original": true,
+		"text":             fmt.Sprintf("✅ Chat paused. %s has taken over the conversation.", username),
+	})
+}
+
+// resumeBot hands a paused conversation back to the assistant.
+func resumeBot(w http.ResponseWriter, db *database.DB, phone, username string) {
+	status, err := db.GetConversationStatus(phone)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		slog.Warn("slack: conversation not found", "phone", phone)
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"replace_original": true, "text": "⚠️ Conversation not found."})
+		return
+	}
+	if err != nil {
+		slog.Error("slack: get conversation", "phone", phone, "err", err)
+		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		return
+	}
 
-		// 4. Validate phone exists in DB before acting (prevents arbitrary pausing).
-		status, err := db.GetConversationStatus(phone)
+	if status == "ACTIVE" {
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]any{"replace_original": true, "text": fmt.Sprintf("ℹ️ Bot is already active for +%s.", phone)})
+		return
+	}
+
+	if err := db.ResumeConversation(phone); err != nil {
+		slog.Error("slack: resume conversation", "phone", phone, "err", err)
+		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		return
+	}
+
+	slog.Info("slack: conversation resumed", "phone", phone, "actor", username, "event", "resume")
+	events.Publish(events.Event{Type: events.StatusChanged, Phone: phone, Status: "ACTIVE", Actor: username})
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]any{
+		"replace_original": true,
+		"text":             fmt.Sprintf("▶️ Bot resumed for +%s", phone),
+	})
+}
+
+// HandleSlackCommand serves Slack slash commands. The only one is
+//
+//	/fixquote <phone> address=<...> inventory=<...>
+//
+// which overwrites the named quote fields, e.g. a mis-extracted address,
+// and leaves the rest as they were.
+func HandleSlackCommand(db *database.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rawBody, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Printf("slack: conversation %s not found: %v", phone, err)
-			w.Header().Set("Content-Type", "application/json")
-			writeJSON(w, map[string]any{"replace_original": true, "text": "⚠️ Conversation not found."})
+			writeError(w, http.StatusBadRequest, "bad_request", "bad request")
+			return
+		}
+		if !verifySlackSignature(cfg.SlackSigningSecret, r.Header.Get("X-Slack-Request-Timestamp"), rawBody, r.Header.Get("X-Slack-Signature")) {
+			slog.Warn("slack: invalid signature", "event", "invalid_signature")
+			writeError(w, http.StatusForbidden, "invalid_signature", "invalid signature")
+			return
+		}
+		form, err := url.ParseQuery(string(rawBody))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "bad request")
 			return
 		}
 
-		if status == "PAUSED" {
+		// Slack shows the ephemeral reply only to the staff member who ran it.
+		reply := func(text string) {
 			w.Header().Set("Content-Type", "application/json")
-			writeJSON(w, map[string]any{"replace_original": true, "text": "ℹ️ Chat was already paused."})
+			writeJSON(w, map[string]any{"response_type": "ephemeral", "text": text})
+		}
+		if form.Get("command") != "/fixquote" {
+			reply(fmt.Sprintf("⚠️ Unknown command %s.", form.Get("command")))
 			return
 		}
 
-		// 5. Pause the conversation.
-		if err := db.PauseConversation(phone); err != nil {
-			log.Printf("slack: pause conversation %s: %v", phone, err)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+		rawPhone, fields, err := parseFixQuote(form.Get("text"))
+		if err != nil {
+			reply("⚠️ " + err.Error() + "\nUsage: /fixquote <phone> address=<...> elevator_access=<...> stairs=<...> inventory=<...>")
+			return
+		}
+		phone, err := normalizePhone(rawPhone, "")
+		if err != nil {
+			reply("⚠️ Conversation not found.")
+			return
+		}
+		if _, err := db.GetConversationStatus(phone); errors.Is(err, database.ErrConversationNotFound) {
+			reply("⚠️ Conversation not found.")
+			return
+		} else if err != nil {
+			slog.Error("slack: get conversation", "phone", phone, "err", err)
+			writeError(w, http.StatusInternalServerError, "internal", "internal error")
 			return
 		}
 
-		log.Printf("slack: conversation %s paused by %s", phone, slackPayload.User.Username)
+		existing, err := db.GetQuoteData(phone)
+		if err != nil {
+			slog.Error("slack: get quote data", "phone", phone, "err", err)
+			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			return
+		}
+		var data models.ExtractedData
+		if existing != nil {
+			data = *existing
+		}
+		data = mergeQuote(data, fields)
+		dataJSON, _ := json.Marshal(data)
+		if err := db.UpsertQuoteData(phone, string(dataJSON)); err != nil {
+			slog.Error("slack: update quote data", "phone", phone, "err", err)
+			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			return
+		}
 
-		// 6. Respond to Slack within 3 seconds.
-		w.Header().Set("Content-Type", "application/json")
-		writeJSON(w, map[string]any{
-			"replace_original": true,
-			"text":             fmt.Sprintf("✅ Chat paused. %s has taken over the conversation.", slackPayload.User.Username),
-		})
+		username := form.Get("user_name")
+		slog.Info("slack: quote corrected", "phone", phone, "actor", username, "fields", len(fields), "event", "quote_fixed")
+		reply(fmt.Sprintf(
+			"✅ Quote for +%s updated by %s\n*Address:* %s\n*Inventory:* %s\n*Stairs:* %s\n*Elevator:* %s",
+			phone, username, data.Address, data.Inventory, data.Stairs, data.ElevatorAccess,
+		))
 	}
 }
 
+// fixQuoteField matches the start of a "field=" pair in /fixquote text.
+var fixQuoteField = regexp.MustCompile(`(?:^|\s)(address|elevator_access|stairs|inventory)=`)
+
+// parseFixQuote splits /fixquote text into the phone and field values. A
+// value runs until the next field name, so it may contain spaces and "=".
+func parseFixQuote(text string) (phone string, fields map[string]string, err error) {
+	text = strings.TrimSpace(text)
+	phone, rest, _ := strings.Cut(text, " ")
+	if phone == "" {
+		return "", nil, errors.New("missing phone number")
+	}
+	rest = strings.TrimSpace(rest)
+	matches := fixQuoteField.FindAllStringSubmatchIndex(rest, -1)
+	if len(matches) == 0 {
+		return "", nil, errors.New("no fields to update")
+	}
+	if lead := strings.TrimSpace(rest[:matches[0][0]]); lead != "" {
+		return "", nil, fmt.Errorf("unexpected text %q before the first field", lead)
+	}
+	fields = map[string]string{}
+	for i, m := range matches {
+		end := len(rest)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		value := strings.TrimSpace(rest[m[1]:end])
+		if value == "" {
+			return "", nil, fmt.Errorf("empty value for %s", rest[m[2]:m[3]])
+		}
+		fields[rest[m[2]:m[3]]] = value
+	}
+	return phone, fields, nil
+}
+
+// mergeQuote overwrites data's fields named in fields (JSON names).
+func mergeQuote(data models.ExtractedData, fields map[string]string) models.ExtractedData {
+	for name, value := range fields {
+		switch name {
+		case "address":
+			data.Address = value
+		case "elevator_access":
+			data.ElevatorAccess = value
+		case "stairs":
+			data.Stairs = value
+		case "inventory":
+			data.Inventory = value
+		}
+	}
+	return data
+}
+
+// writeError replies with status and a JSON body of the form
+// {"error": {"code": "bad_request", "message": "bad request"}}.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, map[string]any{"error": map[string]string{"code": code, "message": message}})
+}
+
 // writeJSON encodes v as JSON to w, logging any error.
 func writeJSON(w http.ResponseWriter, v any) {
 	if err := json.NewEncoder(w).Encode(v); err != nil {
-		log.Printf("slack: encode response: %v", err)
+		slog.Error("slack: encode response", "err", err)
 	}
 }
 
@@ -122,7 +329,7 @@ func verifySlackSignature(signingSecret, timestamp string, body []byte, signatur
 		return false
 	}
 	if time.Now().Unix()-ts > 300 {
-		log.Println("slack: request timestamp too old")
+		slog.Warn("slack: request timestamp too old", "event", "stale_timestamp")
 		return false
 	}
 
@@ -133,3 +340,168 @@ func verifySlackSignature(signingSecret, timestamp string, body []byte, signatur
 
 	return hmac.Equal([]byte(computed), []byte(signature))
 }
+
+// DBDegradedAlert returns a database.WritePolicy callback that posts a single
+// loud ops alert to Slack when the database starts rejecting writes.
+func DBDegradedAlert(cfg *config.Config) func(error) {
+	return func(err error) {
+		text := fmt.Sprintf("🚨 *Database is rejecting writes* — customer messages are not being saved.\n*Error:* `%v`\nCheck disk space and that the data volume is writable.", err)
+		if alertErr := sendSlackAlert(cfg, text); alertErr != nil {
+			slog.Error("slack: db degraded alert failed", "err", alertErr)
+		}
+	}
+}
+
+// sendSlackAlert posts a plain-text ops alert to the Slack webhook.
+func sendSlackAlert(cfg *config.Config, text string) error {
+	payloadBytes, _ := json.Marshal(map[string]any{"text": text})
+	if dryRun(context.Background(), cfg, "slack", "", payloadBytes) {
+		return nil
+	}
+	return postSlack(context.Background(), cfg.SlackWebhookURL, payloadBytes)
+}
+
+// slackMaxRetryAfter caps how long postSlack waits on a 429 before its one
+// retry; a longer Retry-After returns the rate-limit error straight away.
+var slackMaxRetryAfter = 30 * time.Second
+
+// slackError is a webhook post Slack answered without accepting it.
+type slackError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // from Retry-After on a 429
+}
+
+func (e *slackError) Error() string {
+	return fmt.Sprintf("slack: unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// isSlackRateLimited reports whether err is Slack rejecting a post with 429,
+// so the caller can queue it for later rather than treat it as broken.
+func isSlackRateLimited(err error) bool {
+	var se *slackError
+	return errors.As(err, &se) && se.StatusCode == http.StatusTooManyRequests
+}
+
+// postSlack posts a JSON payload to an incoming webhook. A 429 is retried
+// once after Retry-After when that fits within slackMaxRetryAfter and ctx.
+func postSlack(ctx context.Context, webhookURL string, payload []byte) error {
+	return retrySlackRateLimit(ctx, func() error {
+		return postSlackOnce(webhookURL, payload)
+	})
+}
+
+// postSlackMessage posts a JSON payload with chat.postMessage and returns
+// the new message's ts. Rate limits are retried as in postSlack.
+func postSlackMessage(ctx context.Context, botToken string, payload []byte) (string, error) {
+	var ts string
+	err := retrySlackRateLimit(ctx, func() error {
+		var err error
+		ts, err = postSlackMessageOnce(botToken, payload)
+		return err
+	})
+	return ts, err
+}
+
+// retrySlackRateLimit runs post, and once more after Retry-After if Slack
+// answered 429 with a wait within slackMaxRetryAfter and ctx's deadline.
+// Otherwise the rate-limit error is returned for the caller to record.
+func retrySlackRateLimit(ctx context.Context, post func() error) error {
+	err := post()
+	var se *slackError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusTooManyRequests || se.RetryAfter > slackMaxRetryAfter {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < se.RetryAfter {
+		return err
+	}
+	slog.WarnContext(ctx, "slack: rate limited, retrying", "retry_after", se.RetryAfter.String(), "event", "slack_rate_limited")
+	select {
+	case <-ctx.Done():
+		return err
+	case <-time.After(se.RetryAfter):
+	}
+	return post()
+}
+
+func postSlackOnce(webhookURL string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("slack: create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("slack: post error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Slack answers "ok" on success and an error code such as
+	// "invalid_payload" or "channel_is_archived" otherwise.
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return &slackError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(b)),
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return nil
+}
+
+// slackAPIBaseURL is a var so tests can override it with an httptest.Server URL.
+var slackAPIBaseURL = "https://slack.com/api"
+
+func postSlackMessageOnce(botToken string, payload []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackAPIBaseURL+"/chat.postMessage", bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("slack: create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+botToken)
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("slack: post error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return "", &slackError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(b)),
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	// The Web API answers 200 either way; failures set ok=false and an
+	// error code such as "channel_not_found" or "not_in_channel".
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		TS    string `json:"ts"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return "", fmt.Errorf("slack: decode chat.postMessage response: %w", err)
+	}
+	if !result.OK {
+		return "", fmt.Errorf("slack: chat.postMessage failed: %s", result.Error)
+	}
+	return result.TS, nil
+}
+
+// retryAfter parses a Retry-After header in seconds, defaulting to 1s.
+func retryAfter(v string) time.Duration {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		return time.Second
+	}
+	return time.Duration(n) * time.Second
+}
@@ -2,36 +2,220 @@ package handlers
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"clearoutspaces/internal/config"
 	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/events"
+	"clearoutspaces/internal/language"
 	"clearoutspaces/internal/llm"
+	"clearoutspaces/internal/logging"
+	"clearoutspaces/internal/metrics"
 	"clearoutspaces/internal/models"
+	"clearoutspaces/internal/replies"
 )
 
 // metaAPIBaseURL is a var so tests can override it with an httptest.Server URL.
 var metaAPIBaseURL = "https://graph.facebook.com"
 
+// llmProvider generates assistant replies; main sets it from LLM_PROVIDER
+// and tests may swap in a fake.
+var llmProvider llm.Provider = llm.DeepSeek{}
+
+// SetLLMProvider replaces the provider used for assistant replies.
+func SetLLMProvider(p llm.Provider) {
+	llmProvider = p
+}
+
 // conversationLocks serialises processing per phone number to prevent race
-// conditions when a user sends multiple messages in quick succession.
+// conditions when a user sends multiple messages in quick succession. Entries
+// are reference-counted and dropped once no goroutine holds or waits on them.
+var (
+	locksMu           sync.Mutex
+	conversationLocks = map[string]*conversationLock{}
+)
+
+type conversationLock struct {
+	sync.Mutex
+	phone string
+	refs  int // guarded by locksMu
+}
+
+// lockFor returns phone's lock with a reference taken. Callers must Lock it
+// and release the reference with the matching Unlock.
+func lockFor(phone string) *conversationLock {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	l := conversationLocks[phone]
+	if l == nil {
+		l = &conversationLock{phone: phone}
+		conversationLocks[phone] = l
+	}
+	l.refs++
+	return l
+}
+
+// lockPollInterval is how often lockWithin retries a held lock.
+const lockPollInterval = 5 * time.Millisecond
+
+// lockWithin acquires the lock, giving up once timeout passes or ctx ends
+// (timeout <= 0 waits forever). On failure the reference from lockFor is
+// released and the caller must not Unlock.
+func (l *conversationLock) lockWithin(ctx context.Context, timeout time.Duration) bool {
+	if timeout <= 0 {
+		l.Lock()
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tick := time.NewTicker(lockPollInterval)
+	defer tick.Stop()
+	for !l.TryLock() {
+		select {
+		case <-ctx.Done():
+			l.release()
+			return false
+		case <-tick.C:
+		}
+	}
+	return true
+}
+
+// Unlock releases the lock and the reference taken by lockFor.
+func (l *conversationLock) Unlock() {
+	l.Mutex.Unlock()
+	l.release()
+}
+
+func (l *conversationLock) release() {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if l.refs--; l.refs == 0 {
+		delete(conversationLocks, l.phone)
+	}
+}
+
+// rateBuckets holds a token bucket per phone number so a spam loop can't
+// rack up LLM calls; see allowMessage. Buckets that have refilled are
+// dropped at most every rateSweepInterval, since a full bucket is the same
+// as a new one.
+var (
+	rateMu        sync.Mutex
+	rateBuckets   = map[string]*tokenBucket{}
+	rateLastSweep time.Time
+)
+
+const rateSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allowMessage takes a token from phone's bucket, refilled at perMinute and
+// holding at most burst, and reports whether one was available.
+func allowMessage(phone string, perMinute, burst int, now time.Time) bool {
+	if perMinute <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	rateMu.Lock()
+	defer rateMu.Unlock()
+
+	if now.Sub(rateLastSweep) >= rateSweepInterval {
+		for p, b := range rateBuckets {
+			if b.tokens+now.Sub(b.last).Minutes()*float64(perMinute) >= float64(burst) {
+				delete(rateBuckets, p)
+			}
+		}
+		rateLastSweep = now
+	}
+
+	b, ok := rateBuckets[phone]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		rateBuckets[phone] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * float64(perMinute)
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// background counts inbound workers and pending or running debounced
+// replies, so shutdown can wait for them; see Wait.
+var background sync.WaitGroup
+
+// Wait blocks until the inbound pools have drained and every debounced reply
+// has been sent. Call it once the pools' ctx is cancelled and the HTTP server
+// has shut down, before closing the database.
+func Wait() {
+	background.Wait()
+}
+
+// debounceTimers holds the pending reply timer per phone number; each new
+// inbound message resets it so a burst of messages gets a single reply.
 var (
-	conversationLocks sync.Map // map[phoneNumber] -> *sync.Mutex
+	debounceMu     sync.Mutex
+	debounceTimers = map[string]*time.Timer{}
 )
 
-func lockFor(phone string) *sync.Mutex {
-	v, _ := conversationLocks.LoadOrStore(phone, &sync.Mutex{})
-	return v.(*sync.Mutex)
+// debounce runs fn once window has passed without another call for phone.
+// Earlier pending calls for the same phone are dropped.
+func debounce(ctx context.Context, phone string, window time.Duration, fn func()) {
+	debounceMu.Lock()
+	defer debounceMu.Unlock()
+
+	if t, ok := debounceTimers[phone]; ok && t.Stop() {
+		background.Done() // it will never run
+	}
+	var t *time.Timer
+	background.Add(1)
+	t = time.AfterFunc(window, func() {
+		defer background.Done()
+		debounceMu.Lock()
+		if debounceTimers[phone] != t {
+			debounceMu.Unlock()
+			return // superseded by a newer message
+		}
+		delete(debounceTimers, phone)
+		debounceMu.Unlock()
+
+		defer func() {
+			if rec := recover(); rec != nil {
+				slog.ErrorContext(ctx, "whatsapp: recovered from panic", "phone", phone, "err", rec)
+			}
+		}()
+		fn()
+	})
+	debounceTimers[phone] = t
 }
 
 // ─── GET /whatsapp/webhook ────────────────────────────────────────────────────
@@ -47,42 +231,207 @@ func VerifyWebhook(cfg *config.Config) http.HandlerFunc {
 			fmt.Fprint(w, challenge)
 			return
 		}
-		http.Error(w, "forbidden", http.StatusForbidden)
+		writeError(w, http.StatusForbidden, "forbidden", "forbidden")
 	}
 }
 
 // ─── POST /whatsapp/webhook ───────────────────────────────────────────────────
 
-func HandleWhatsAppMessage(db *database.DB, cfg *config.Config) http.HandlerFunc {
+// maxWebhookBody caps an inbound webhook body; Meta's are a few KB even in
+// a large batch. A var so tests can lower it.
+var maxWebhookBody int64 = 1 << 20
+
+// unsignedBodyPrefix is how much of a body with a bad signature is kept in
+// webhook_events, enough to tell a misconfigured secret from noise.
+const unsignedBodyPrefix = 512
+
+// HandleWhatsAppMessage acks Meta's webhook and queues the body for the
+// inbound pool, which processes it in the background until ctx is cancelled.
+func HandleWhatsAppMessage(ctx context.Context, db *database.DB, cfg *config.Config) http.HandlerFunc {
+	pool := newInboundPool(ctx, db, cfg)
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 1. Read raw body first — required for HMAC verification.
-		rawBody, err := io.ReadAll(r.Body)
+		rawBody, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
 		if err != nil {
-			log.Printf("whatsapp: failed to read body: %v", err)
-			http.Error(w, "bad request", http.StatusBadRequest)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				slog.Warn("whatsapp: webhook body too large", "limit", tooLarge.Limit, "event", "body_too_large")
+				writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+				return
+			}
+			slog.Error("whatsapp: failed to read body", "err", err)
+			writeError(w, http.StatusBadRequest, "bad_request", "bad request")
 			return
 		}
 
-		// 2. Verify HMAC-SHA256 signature.
-		if !verifyMetaSignature(cfg.MetaAppSecret, rawBody, r.Header.Get("X-Hub-Signature-256")) {
-			log.Println("whatsapp: invalid signature")
-			http.Error(w, "forbidden", http.StatusForbidden)
+		// 2. Verify HMAC-SHA256 signature, archiving the body either way;
+		// only a prefix of an unsigned one, as anyone can send those.
+		valid := verifyMetaSignature(cfg.MetaAppSecret, rawBody, r.Header.Get("X-Hub-Signature-256"))
+		archived := string(rawBody)
+		if !valid && len(rawBody) > unsignedBodyPrefix {
+			archived = strings.ToValidUTF8(string(rawBody[:unsignedBodyPrefix]), "")
+		}
+		if err := db.InsertWebhookEvent(archived, valid); err != nil {
+			slog.Error("whatsapp: failed to log webhook event", "err", err)
+		}
+		if !valid {
+			slog.Warn("whatsapp: invalid signature", "event", "invalid_signature")
+			writeError(w, http.StatusForbidden, "invalid_signature", "invalid signature")
 			return
 		}
 
 		// 3. Return 200 immediately — Meta requires a fast ack.
 		w.WriteHeader(http.StatusOK)
 
-		// 4. Process asynchronously.
+		// 4. Process asynchronously, tagging every log line with one trace
+		// id. A proxy-supplied X-Request-Id wins so its logs line up too.
+		traceID := r.Header.Get("X-Request-Id")
+		if traceID == "" {
+			traceID = logging.NewTraceID()
+		}
+		pool.submit(logging.WithTraceID(context.Background(), traceID), rawBody)
+	}
+}
+
+// deferredPollInterval is how often the inbound pool refills its queue from
+// deferred_inbound. A var so tests can change it.
+var deferredPollInterval = 5 * time.Second
+
+// processWebhook handles one queued webhook body; pools capture it when
+// created. A var so tests can stall the workers.
+var processWebhook = processInbound
+
+type inboundJob struct {
+	ctx  context.Context
+	body []byte
+}
+
+// inboundPool processes webhook bodies on a fixed number of workers, so a
+// retry storm from Meta queues up instead of spawning a goroutine per POST.
+// Once a body has been deferred, later ones are deferred behind it until the
+// backlog has drained, so each customer's messages stay in order.
+type inboundPool struct {
+	db      *database.DB
+	cfg     *config.Config
+	jobs    chan inboundJob
+	process func(ctx context.Context, db *database.DB, cfg *config.Config, rawBody []byte)
+
+	mu      sync.Mutex // held to send on jobs or touch the fields below
+	backlog bool       // deferred_inbound may hold bodies
+	closed  bool       // shutting down; new bodies are deferred
+}
+
+// newInboundPool starts the workers. When ctx is cancelled the pool stops
+// taking bodies, defers any that still arrive, and the workers exit once
+// they have drained the queue.
+func newInboundPool(ctx context.Context, db *database.DB, cfg *config.Config) *inboundPool {
+	p := &inboundPool{db: db, cfg: cfg, jobs: make(chan inboundJob, max(cfg.InboundQueueSize, 1)), process: processWebhook, backlog: true}
+	// Pick up what an earlier run left deferred before taking new bodies.
+	p.refillOnce()
+
+	stop := make(chan struct{})
+	for i := 0; i < max(cfg.InboundWorkers, 1); i++ {
+		background.Add(1)
 		go func() {
-			defer func() {
-				if rec := recover(); rec != nil {
-					log.Printf("whatsapp: recovered from panic: %v", rec)
-				}
-			}()
-			processInbound(db, cfg, rawBody)
+			defer background.Done()
+			p.work(stop)
 		}()
 	}
+	go p.refill(ctx, deferredPollInterval)
+	go func() {
+		<-ctx.Done()
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+		close(stop)
+	}()
+	return p
+}
+
+// work runs queued jobs until stop is closed, then drains the queue. Nothing
+// is queued once the pool is closed, so an empty queue then stays empty.
+func (p *inboundPool) work(stop <-chan struct{}) {
+	for {
+		select {
+		case job := <-p.jobs:
+			p.run(job)
+		case <-stop:
+			for {
+				select {
+				case job := <-p.jobs:
+					p.run(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *inboundPool) run(job inboundJob) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.ErrorContext(job.ctx, "whatsapp: recovered from panic", "err", rec)
+		}
+	}()
+	p.process(job.ctx, p.db, p.cfg, job.body)
+}
+
+// submit queues a body for the workers. When the queue is full, a backlog is
+// waiting or the pool is shutting down, the body is saved to deferred_inbound
+// with its trace id instead; Meta has already had its 200.
+func (p *inboundPool) submit(ctx context.Context, body []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed && !p.backlog {
+		select {
+		case p.jobs <- inboundJob{ctx: ctx, body: body}:
+			return
+		default:
+			slog.WarnContext(ctx, "whatsapp: processing queue full, deferring webhook", "event", "inbound_deferred", "queue_size", cap(p.jobs))
+		}
+	}
+	p.backlog = true
+	if err := p.db.DeferInbound(string(body), logging.TraceID(ctx)); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: defer webhook, dropping it", "event", "inbound_dropped", "err", err)
+	}
+}
+
+// refill calls refillOnce every interval until ctx is cancelled.
+func (p *inboundPool) refill(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.refillOnce()
+		}
+	}
+}
+
+// refillOnce moves deferred bodies back onto the queue, oldest first and
+// under the trace id they arrived with, as far as there is room. The backlog
+// has drained once fewer bodies than that are left.
+func (p *inboundPool) refillOnce() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	room := cap(p.jobs) - len(p.jobs)
+	if p.closed || !p.backlog || room == 0 {
+		return
+	}
+	deferred, err := p.db.TakeDeferredInbound(room)
+	if err != nil {
+		slog.Error("whatsapp: take deferred webhooks", "err", err)
+		return
+	}
+	// Only mu holders send, so the room can't shrink meanwhile.
+	for _, d := range deferred {
+		ctx := logging.WithTraceID(context.Background(), cmp.Or(d.TraceID, logging.NewTraceID()))
+		p.jobs <- inboundJob{ctx: ctx, body: []byte(d.Body)}
+	}
+	p.backlog = len(deferred) == room
 }
 
 func verifyMetaSignature(secret string, body []byte, header string) bool {
@@ -97,75 +446,367 @@ func verifyMetaSignature(secret string, body []byte, header string) bool {
 	return hmac.Equal([]byte(computed), []byte(expected))
 }
 
-func processInbound(db *database.DB, cfg *config.Config, rawBody []byte) {
+func processInbound(ctx context.Context, db *database.DB, cfg *config.Config, rawBody []byte) {
 	var payload models.WAPayload
 	if err := json.Unmarshal(rawBody, &payload); err != nil {
-		log.Printf("whatsapp: unmarshal error: %v", err)
+		slog.ErrorContext(ctx, "whatsapp: unmarshal error", "err", err)
 		return
 	}
-
-	// Guard against status/delivery receipt webhooks (no messages array).
-	if len(payload.Entry) == 0 ||
-		len(payload.Entry[0].Changes) == 0 ||
-		len(payload.Entry[0].Changes[0].Value.Messages) == 0 {
+	if payload.Object != "whatsapp_business_account" {
+		slog.WarnContext(ctx, "whatsapp: dropping webhook for unexpected object", "object", payload.Object, "event", "unexpected_object")
 		return
 	}
 
-	// Process all messages in the payload (Meta can batch multiple).
+	// Process all statuses and messages in the payload (Meta can batch multiple).
 	for _, entry := range payload.Entry {
 		for _, change := range entry.Changes {
+			if change.Field != "messages" {
+				slog.WarnContext(ctx, "whatsapp: skipping change for unsubscribed field", "field", change.Field, "event", "unexpected_field")
+				continue
+			}
+			for _, st := range change.Value.Statuses {
+				recordStatus(ctx, db, st)
+			}
+			meta := inboundMeta{PhoneNumberID: change.Value.Metadata.PhoneNumberID}
+			for _, c := range change.Value.Contacts {
+				if c.Profile.Name == "" {
+					continue
+				}
+				if meta.ProfileNames == nil {
+					meta.ProfileNames = map[string]string{}
+				}
+				meta.ProfileNames[c.WaID] = c.Profile.Name
+			}
 			for _, msg := range change.Value.Messages {
-				handleMessage(db, cfg, &msg)
+				handleMessage(ctx, db, cfg, &msg, meta)
+			}
+		}
+	}
+}
+
+// staticReply returns a canned message in the conversation's stored
+// language, or the default language when none is known yet.
+func staticReply(db *database.DB, phone, id string) string {
+	lang, err := db.GetConversationLanguage(phone)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("whatsapp: get language", "phone", phone, "err", err)
+	}
+	return replies.Get(id, lang)
+}
+
+// maxQuotedChars caps how much of a quoted message is repeated in a reply's
+// context note.
+const maxQuotedChars = 300
+
+// replyTarget returns the wamid of the message the customer is replying or
+// reacting to, or "".
+func replyTarget(msg *models.WAMessage) string {
+	switch {
+	case msg.Context != nil:
+		return msg.Context.ID
+	case msg.Reaction != nil:
+		return msg.Reaction.MessageID
+	}
+	return ""
+}
+
+// withReplyContext prefixes each customer message in history with the
+// message it replies or reacts to, so a bare "yes" still tells the LLM which
+// question it answers. The note is added per call and never stored. Quoted
+// messages we don't have stored are skipped.
+func withReplyContext(ctx context.Context, db *database.DB, history []models.Message) {
+	for i := range history {
+		m := &history[i]
+		if m.Role != "user" || m.ReplyTo == "" {
+			continue
+		}
+		quoted, err := db.GetMessageContent(m.ConversationID, m.ReplyTo)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				slog.ErrorContext(ctx, "whatsapp: look up quoted message", "phone", m.ConversationID, "wamid", m.ID, "err", err)
 			}
+			continue
+		}
+		if r := []rune(quoted); len(r) > maxQuotedChars {
+			quoted = string(r[:maxQuotedChars]) + "…"
+		}
+		m.Content = fmt.Sprintf("[in reply to message %s: %s]\n%s", m.ReplyTo, quoted, m.Content)
+	}
+}
+
+// inboundText returns the text content of a message the assistant can handle.
+// Quick-reply taps use the option title (or id when untitled) and keep the
+// reply id in the content so the saved transcript is unambiguous.
+func inboundText(msg *models.WAMessage) (string, bool) {
+	switch msg.Type {
+	case "text":
+		if msg.Text == nil {
+			return "", false
+		}
+		return msg.Text.Body, true
+	case "interactive":
+		if msg.Interactive == nil {
+			return "", false
+		}
+		opt := msg.Interactive.ButtonReply
+		if msg.Interactive.Type == "list_reply" || opt == nil {
+			opt = msg.Interactive.ListReply
+		}
+		if opt == nil {
+			return "", false
 		}
+		text := opt.Title
+		if text == "" {
+			text = opt.ID
+		}
+		return fmt.Sprintf("%s [%s id=%s]", text, msg.Interactive.Type, opt.ID), true
+	case "image":
+		if msg.Image == nil {
+			return "", false
+		}
+		return mediaText("image", msg.Image.Caption), true
+	case "document":
+		if msg.Document == nil {
+			return "", false
+		}
+		return mediaText("document", msg.Document.Caption), true
+	case "reaction":
+		if msg.Reaction == nil || msg.Reaction.Emoji == "" {
+			return "", false
+		}
+		if positiveReaction(msg.Reaction.Emoji) {
+			return fmt.Sprintf("Yes [reacted with %s]", msg.Reaction.Emoji), true
+		}
+		return fmt.Sprintf("[reacted with %s]", msg.Reaction.Emoji), true
+	}
+	return "", false
+}
+
+// mediaText is the content of a photo or document message. The caption
+// often carries the actual request ("getting rid of this sofa + 2 chairs"),
+// so it goes to the LLM ahead of the placeholder.
+func mediaText(kind, caption string) string {
+	placeholder := fmt.Sprintf("[%s received]", kind)
+	if caption = strings.TrimSpace(caption); caption != "" {
+		return caption + " " + placeholder
+	}
+	return placeholder
+}
+
+// affirmativeEmoji are reactions read as the customer saying "yes".
+var affirmativeEmoji = map[string]bool{
+	"👍": true, "👌": true, "✅": true, "✔": true, "❤": true, "🙏": true, "💯": true, "🙌": true,
+}
+
+// positiveReaction reports whether emoji is an affirmative reaction,
+// ignoring skin tones and emoji presentation selectors.
+func positiveReaction(emoji string) bool {
+	base := strings.Map(func(r rune) rune {
+		if r == '\uFE0F' || (r >= 0x1F3FB && r <= 0x1F3FF) {
+			return -1
+		}
+		return r
+	}, emoji)
+	return affirmativeEmoji[base]
+}
+
+// inboundMedia returns the media kind, id and caption of an image or
+// document message, or ok=false for anything else.
+func inboundMedia(msg *models.WAMessage) (kind, id, caption string, ok bool) {
+	switch {
+	case msg.Type == "image" && msg.Image != nil:
+		return "image", msg.Image.ID, msg.Image.Caption, true
+	case msg.Type == "document" && msg.Document != nil:
+		caption = msg.Document.Caption
+		if caption == "" {
+			caption = msg.Document.Filename
+		}
+		return "document", msg.Document.ID, caption, true
+	}
+	return "", "", "", false
+}
+
+// forwardMedia posts a Slack notice for photos and documents so staff can
+// see what the customer sent; the bot only has the caption and placeholder.
+func forwardMedia(ctx context.Context, cfg *config.Config, phone string, msg *models.WAMessage) {
+	kind, id, caption, ok := inboundMedia(msg)
+	if !ok {
+		return
+	}
+	link := signedAdminLink(cfg, "/admin/media/"+id, clock())
+	if err := sendSlackMediaNotice(ctx, cfg, phone, kind, caption, link); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: slack media notice failed", "phone", phone, "err", err)
+	}
+}
+
+// inboundMeta is the per-change context Meta sends alongside messages.
+type inboundMeta struct {
+	PhoneNumberID string            // our business number that received the message
+	ProfileNames  map[string]string // sender wa_id -> WhatsApp profile name
+}
+
+// recordStatus stores a delivery receipt's status and billing metadata.
+func recordStatus(ctx context.Context, db *database.DB, st models.WAStatus) {
+	if st.ID == "" || st.Status == "" {
+		return
+	}
+	var convID, category string
+	if st.Conversation != nil {
+		convID = st.Conversation.ID
+	}
+	if st.Pricing != nil {
+		category = st.Pricing.Category
+	}
+	if err := db.RecordStatus(st.ID, st.Status, convID, category); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: record status", "wamid", st.ID, "phone", st.RecipientID, "err", err)
+	}
+	if st.Status == "failed" && len(st.Errors) > 0 {
+		recordSendError(ctx, db, st)
 	}
 }
 
-func handleMessage(db *database.DB, cfg *config.Config, msg *models.WAMessage) {
-	// Only handle text messages.
-	if msg.Type != "text" || msg.Text == nil {
-		log.Printf("whatsapp: ignoring non-text message type=%s from=%s", msg.Type, msg.From)
-		sendWhatsApp(cfg, msg.From, "Sorry, I can only handle text messages right now.")
+// recordSendError stores the reason Meta gave for failing to deliver one of
+// our messages, and lists it in outbound_failures under the customer so it
+// shows up in /admin/failures. Meta doesn't echo the text, so the body is
+// left empty.
+func recordSendError(ctx context.Context, db *database.DB, st models.WAStatus) {
+	e := st.Errors[0]
+	slog.WarnContext(ctx, "whatsapp: message delivery failed", "wamid", st.ID, "phone", st.RecipientID, "code", e.Code, "title", e.Title, "event", "delivery_failed")
+	if err := db.RecordSendError(st.ID, e.Code, e.Title); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: record send error", "wamid", st.ID, "err", err)
+	}
+	if st.RecipientID == "" {
+		return
+	}
+	if err := db.InsertOutboundFailure(&models.OutboundFailure{
+		ConversationID: st.RecipientID,
+		Error:          fmt.Sprintf("delivery failed for %s: %d %s", st.ID, e.Code, e.Title),
+	}); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: record outbound failure", "phone", st.RecipientID, "err", err)
+	}
+}
+
+func handleMessage(ctx context.Context, db *database.DB, cfg *config.Config, msg *models.WAMessage, meta inboundMeta) {
+	if msg.Type == "reaction" && (msg.Reaction == nil || msg.Reaction.Emoji == "") {
+		slog.InfoContext(ctx, "whatsapp: ignoring reaction removal", "phone", msg.From, "wamid", msg.ID)
+		return
+	}
+
+	// Meta's wa_id is already international; normalizing keeps "+1…" and
+	// "1…" from ever becoming two conversations.
+	phone, err := normalizePhone(msg.From, "")
+	if err != nil {
+		slog.WarnContext(ctx, "whatsapp: invalid sender, skipping message", "phone", msg.From, "wamid", msg.ID)
+		return
+	}
+	sentAt := messageSentAt(ctx, cfg, msg, time.Now())
+	recordInboundType(ctx, db, msg)
+
+	// Blocked and (with an allowlist) unlisted numbers are kept for audit
+	// but get no read receipt, reply or LLM call.
+	if event := screenSender(cfg, phone); event != "" {
+		slog.InfoContext(ctx, "whatsapp: sender screened out, saving without replying", "phone", phone, "wamid", msg.ID, "event", event)
+		text, ok := inboundText(msg)
+		if !ok {
+			text = fmt.Sprintf("[%s message]", msg.Type)
+		}
+		saveUnanswered(ctx, db, phone, msg, text, sentAt)
 		return
 	}
 
-	phone := msg.From
+	// Only handle text (and quick-reply taps, which carry text). Photos and
+	// documents are stored as their caption plus a placeholder and forwarded
+	// to Slack.
+	body, ok := inboundText(msg)
+	if !ok {
+		// Saved like any other message, so a redelivery is a duplicate and
+		// isn't bounced twice.
+		slog.InfoContext(ctx, "whatsapp: ignoring non-text message", "type", msg.Type, "phone", phone, "wamid", msg.ID)
+		if saveUnanswered(ctx, db, phone, msg, fmt.Sprintf("[%s message]", msg.Type), sentAt) {
+			sendWhatsApp(ctx, db, cfg, phone, staticReply(db, phone, replies.UnsupportedType))
+		}
+		return
+	}
+	if strings.TrimSpace(body) == "" {
+		slog.InfoContext(ctx, "whatsapp: empty message body, not calling the LLM", "phone", phone, "wamid", msg.ID, "action", cfg.EmptyMessageAction, "event", "empty_message")
+		if cfg.EmptyMessageAction != config.EmptyMessageIgnore {
+			sendWhatsApp(ctx, db, cfg, phone, staticReply(db, phone, replies.EmptyMessage))
+		}
+		return
+	}
+	body, oversize := truncateMessage(body, cfg.MaxMessageChars)
+	if oversize {
+		slog.WarnContext(ctx, "whatsapp: message over length limit, truncating", "phone", phone, "wamid", msg.ID, "limit", cfg.MaxMessageChars, "event", "oversize")
+	}
 
-	// Per-conversation lock.
+	// Per-conversation lock. If another message is stuck on a slow LLM
+	// call, save this one rather than pile up behind it.
 	mu := lockFor(phone)
-	mu.Lock()
+	if !mu.lockWithin(ctx, cfg.LockTimeout) {
+		slog.WarnContext(ctx, "whatsapp: conversation busy, saving message without replying", "phone", phone, "wamid", msg.ID, "timeout", cfg.LockTimeout.String(), "event", "lock_timeout")
+		if saveUnanswered(ctx, db, phone, msg, body, sentAt) {
+			setDisplayName(ctx, db, phone, meta.ProfileNames[msg.From])
+			events.Publish(events.Event{Type: events.MessageReceived, Phone: phone, MessageID: msg.ID, Text: body})
+			forwardMedia(ctx, cfg, phone, msg)
+		}
+		return
+	}
 	defer mu.Unlock()
 
 	// Idempotency check.
 	exists, err := db.MessageExists(msg.ID)
 	if err != nil {
-		log.Printf("whatsapp: idempotency check failed: %v", err)
+		slog.ErrorContext(ctx, "whatsapp: idempotency check failed", "wamid", msg.ID, "err", err)
 		return
 	}
 	if exists {
-		log.Printf("whatsapp: duplicate message %s, skipping", msg.ID)
+		slog.InfoContext(ctx, "whatsapp: duplicate message, skipping", "phone", phone, "wamid", msg.ID, "event", "duplicate")
+		return
+	}
+
+	// Abuse control: cap concurrently-active conversations per source.
+	overCap, err := overActiveCap(db, cfg, phone, meta.PhoneNumberID)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: active conversation cap check", "phone", phone, "err", err)
+		return
+	}
+	if overCap && cfg.OverCapacityAction == config.OverCapacityClose {
+		// Saved, never answered, so a redelivery isn't turned away twice.
+		slog.WarnContext(ctx, "whatsapp: source over active cap, closing new conversation", "source", meta.PhoneNumberID, "phone", phone, "event", "over_capacity")
+		if saveUnanswered(ctx, db, phone, msg, body, sentAt) {
+			sendWhatsApp(ctx, db, cfg, phone, staticReply(db, phone, replies.OverCapacityClosed))
+		}
 		return
 	}
 
 	// Upsert conversation.
 	if err := db.UpsertConversation(phone); err != nil {
-		log.Printf("whatsapp: upsert conversation: %v", err)
+		slog.ErrorContext(ctx, "whatsapp: upsert conversation", "phone", phone, "err", err)
 		return
 	}
+	if meta.PhoneNumberID != "" {
+		if err := db.SetConversationSource(phone, meta.PhoneNumberID); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: set conversation source", "phone", phone, "err", err)
+		}
+	}
+	setDisplayName(ctx, db, phone, meta.ProfileNames[msg.From])
 
 	// Check if conversation is PAUSED (staff has taken over).
 	status, err := db.GetConversationStatus(phone)
 	if err != nil {
-		log.Printf("whatsapp: get status: %v", err)
+		slog.ErrorContext(ctx, "whatsapp: get status", "phone", phone, "err", err)
 		return
 	}
 	if status == "PAUSED" {
-		log.Printf("whatsapp: conversation %s is PAUSED, sending static reply", phone)
+		slog.InfoContext(ctx, "whatsapp: conversation is PAUSED, sending static reply", "phone", phone, "wamid", msg.ID)
 		// Still save the message for audit trail.
 		_ = db.InsertMessage(&models.Message{
-			ID: msg.ID, ConversationID: phone, Role: "user", Content: msg.Text.Body,
+			ID: msg.ID, ConversationID: phone, Role: "user", Content: body, SentAt: sentAt, Forwarded: msg.ForwardedFlag(), ReplyTo: replyTarget(msg),
 		})
-		sendWhatsApp(cfg, phone, "Our team is handling your request directly. We'll be in touch shortly!")
+		metrics.MessagesReceived.Inc()
+		events.Publish(events.Event{Type: events.MessageReceived, Phone: phone, MessageID: msg.ID, Text: body, Status: status})
+		forwardMedia(ctx, cfg, phone, msg)
+		sendWhatsApp(ctx, db, cfg, phone, staticReply(db, phone, replies.Paused))
 		return
 	}
 
@@ -174,102 +815,966 @@ func handleMessage(db *database.DB, cfg *config.Config, msg *models.WAMessage) {
 		ID:             msg.ID,
 		ConversationID: phone,
 		Role:           "user",
-		Content:        msg.Text.Body,
+		Content:        body,
+		SentAt:         sentAt,
+		Forwarded:      msg.ForwardedFlag(),
+		ReplyTo:        replyTarget(msg),
 	}); err != nil {
-		log.Printf("whatsapp: insert message: %v", err)
+		slog.ErrorContext(ctx, "whatsapp: insert message", "phone", phone, "wamid", msg.ID, "err", err)
 		return
 	}
+	if flag := msg.ForwardedFlag(); flag != "" {
+		slog.InfoContext(ctx, "whatsapp: customer forwarded a message", "phone", phone, "wamid", msg.ID, "event", flag)
+	}
+	metrics.MessagesReceived.Inc()
+	events.Publish(events.Event{Type: events.MessageReceived, Phone: phone, MessageID: msg.ID, Text: body, Status: status})
+	forwardMedia(ctx, cfg, phone, msg)
 
-	// Load conversation history (last 20 messages).
-	history, err := db.GetRecentMessages(phone, 20)
-	if err != nil {
-		log.Printf("whatsapp: get history: %v", err)
+	if oversize && cfg.OversizeMessageAction == config.OversizeReject {
+		sendWhatsApp(ctx, db, cfg, phone, staticReply(db, phone, replies.TooLong))
 		return
 	}
 
-	// Call DeepSeek.
-	ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
-	defer cancel()
+	if !allowMessage(phone, cfg.RateLimitPerMinute, cfg.RateLimitBurst, time.Now()) {
+		slog.WarnContext(ctx, "whatsapp: over rate limit, saved message without replying", "phone", phone, "wamid", msg.ID, "event", "rate_limited")
+		return
+	}
 
-	llmResp, err := llm.Call(ctx, cfg.DeepSeekAPIKey, history)
-	if err != nil {
-		log.Printf("whatsapp: llm error: %v", err)
-		// llmResp is still a valid fallback — continue processing.
+	if overCap {
+		// Queued: RunCapacityQueue answers the saved message once the
+		// source has capacity.
+		slog.WarnContext(ctx, "whatsapp: source over active cap, queueing conversation", "source", meta.PhoneNumberID, "phone", phone, "event", "over_capacity")
+		if err := db.QueueConversation(phone); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: queue conversation", "phone", phone, "err", err)
+		}
+		sendWhatsApp(ctx, db, cfg, phone, staticReply(db, phone, replies.OverCapacityQueued))
+		return
 	}
 
-	// Save assistant reply.
-	assistantMsgID := fmt.Sprintf("assistant-%s-%d", phone, time.Now().UnixNano())
-	_ = db.InsertMessage(&models.Message{
-		ID:             assistantMsgID,
-		ConversationID: phone,
-		Role:           "assistant",
-		Content:        llmResp.ReplyToUser,
-	})
+	// Only now is a reply coming, so blue ticks and the typing indicator
+	// don't promise one to paused, duplicate or throttled messages.
+	markRead(ctx, cfg, msg.ID)
 
-	// Save extracted quote data.
-	if dataJSON, err := json.Marshal(llmResp.ExtractedData); err == nil {
-		_ = db.UpsertQuoteData(phone, string(dataJSON))
+	// Short bursts of messages are answered together once the customer
+	// stops typing for DebounceWindow.
+	if cfg.DebounceWindow <= 0 {
+		respond(ctx, db, cfg, phone, msg.ID, body)
+		return
 	}
-
-	// Execute action.
-	switch llmResp.Action {
-	case "handoff":
-		if err := sendSlackHandoff(cfg, phone, llmResp); err != nil {
-			log.Printf("whatsapp: slack handoff failed: %v — falling back to continue", err)
-			// Don't leave customer hanging; send the reply anyway.
+	msgID := msg.ID
+	debounce(ctx, phone, cfg.DebounceWindow, func() {
+		mu := lockFor(phone)
+		if !mu.lockWithin(ctx, cfg.LockTimeout) {
+			slog.WarnContext(ctx, "whatsapp: conversation busy after debounce, not replying", "phone", phone, "wamid", msgID, "event", "lock_timeout")
+			return
 		}
-		sendWhatsApp(cfg, phone, llmResp.ReplyToUser)
+		defer mu.Unlock()
 
-	case "schedule":
-		bookingMsg := fmt.Sprintf("%s\n\nYou can pick a time for an on-site assessment here: https://bookings.clearoutspaces.ca/clearoutspaces/assessment", llmResp.ReplyToUser)
-		sendWhatsApp(cfg, phone, bookingMsg)
+		// Staff may have taken over while we were waiting.
+		status, err := db.GetConversationStatus(phone)
+		if err != nil {
+			slog.ErrorContext(ctx, "whatsapp: get status", "phone", phone, "err", err)
+			return
+		}
+		if status == "PAUSED" {
+			slog.InfoContext(ctx, "whatsapp: conversation paused during debounce, not replying", "phone", phone)
+			return
+		}
+		respond(ctx, db, cfg, phone, msgID, body)
+	})
+}
 
-	default: // "continue"
-		sendWhatsApp(cfg, phone, llmResp.ReplyToUser)
+// screenSender checks phone against the blocklist and allowlist, returning
+// the log event when the bot must not reply, or "".
+func screenSender(cfg *config.Config, phone string) string {
+	if slices.Contains(cfg.Blocklist, phone) {
+		return "blocked"
+	}
+	if len(cfg.Allowlist) > 0 && !slices.Contains(cfg.Allowlist, phone) {
+		return "not_allowlisted"
 	}
+	return ""
 }
 
-// ─── Outbound WhatsApp ────────────────────────────────────────────────────────
-
-func sendWhatsApp(cfg *config.Config, to, body string) {
-	url := fmt.Sprintf("%s/v18.0/%s/messages", metaAPIBaseURL, cfg.MetaPhoneNumberID)
-	payload := map[string]any{
-		"messaging_product": "whatsapp",
-		"to":                to,
-		"type":              "text",
-		"text":              map[string]string{"body": body},
+// recordInboundType counts msg by type, for the /admin/stats breakdown and
+// inbound_messages_by_type_total, before anything can turn it away.
+func recordInboundType(ctx context.Context, db *database.DB, msg *models.WAMessage) {
+	msgType := cmp.Or(msg.Type, "unknown")
+	added, err := db.RecordInboundType(msg.ID, msgType)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: record message type", "wamid", msg.ID, "type", msgType, "err", err)
+		return
 	}
-	payloadBytes, _ := json.Marshal(payload)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if added {
+		metrics.MessagesByType.WithLabelValues(msgType).Inc()
+	}
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payloadBytes))
+// saveUnanswered stores an inbound message without the conversation lock
+// or a reply, so it is in the history the next reply is generated from. It
+// reports whether the message was new.
+func saveUnanswered(ctx context.Context, db *database.DB, phone string, msg *models.WAMessage, body string, sentAt time.Time) bool {
+	if err := db.UpsertConversation(phone); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: upsert conversation", "phone", phone, "err", err)
+		return false
+	}
+	err := db.InsertMessage(&models.Message{ID: msg.ID, ConversationID: phone, Role: "user", Content: body, SentAt: sentAt, Forwarded: msg.ForwardedFlag(), ReplyTo: replyTarget(msg)})
+	if database.IsDuplicateKey(err) {
+		return false
+	}
 	if err != nil {
-		log.Printf("whatsapp: send: create request: %v", err)
+		slog.ErrorContext(ctx, "whatsapp: insert message", "phone", phone, "wamid", msg.ID, "err", err)
+		return false
+	}
+	metrics.MessagesReceived.Inc()
+	return true
+}
+
+// setDisplayName stores the customer's WhatsApp profile name, if Meta sent one.
+func setDisplayName(ctx context.Context, db *database.DB, phone, name string) {
+	if name == "" {
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+cfg.MetaAccessToken)
+	if err := db.SetConversationDisplayName(phone, name); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: set display name", "phone", phone, "err", err)
+	}
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+// respond generates and sends the assistant's reply to the conversation
+// history, where msgID and body are the latest inbound message. The caller
+// must hold the conversation lock.
+func respond(ctx context.Context, db *database.DB, cfg *config.Config, phone, msgID, body string) {
+	// A draft awaiting staff approval must not race a second one.
+	pending, err := db.GetPendingReply(phone)
 	if err != nil {
-		log.Printf("whatsapp: send: http error: %v", err)
+		slog.ErrorContext(ctx, "whatsapp: get pending reply", "phone", phone, "err", err)
 		return
 	}
+	if pending != nil && cfg.PendingApprovalBehavior != config.PendingApprovalUpdate {
+		slog.InfoContext(ctx, "whatsapp: conversation has a reply pending approval, queueing message", "phone", phone, "wamid", msgID)
+		return
+	}
+
+	// A replayed message was already answered; checked before the LLM call,
+	// since a streamed reply would go out again before the insert below.
+	if answered, err := db.MessageExists("assistant-" + msgID); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: check for existing reply", "phone", phone, "wamid", msgID, "err", err)
+	} else if answered {
+		slog.InfoContext(ctx, "whatsapp: message already answered, skipping reply", "phone", phone, "wamid", msgID, "event", "duplicate_reply")
+		return
+	}
+
+	// Load recent conversation history.
+	history, err := loadHistory(ctx, db, cfg, phone)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: get history", "phone", phone, "err", err)
+		return
+	}
+	withReplyContext(ctx, db, history)
+
+	// Keep replies in the conversation's language when locked.
+	lockedLang := resolveLanguage(ctx, db, cfg, phone, body)
+	if note := localeNote(ctx, db, phone); note != "" {
+		// After the summary note, ahead of the turns themselves.
+		i := 0
+		for i < len(history) && history[i].Role == "system" {
+			i++
+		}
+		history = slices.Insert(history, i, models.Message{ConversationID: phone, Role: "system", Content: note})
+	}
+	if lang := lockedLang; lang != "" {
+		history = append(history, models.Message{
+			ConversationID: phone,
+			Role:           "system",
+			Content: fmt.Sprintf(
+				"The conversation language is %s. Always reply in %s, even if the customer writes in another language, unless they explicitly ask to switch.",
+				language.Name(lang), language.Name(lang),
+			),
+		})
+	}
+
+	// Call DeepSeek.
+	llmCtx, cancel := context.WithTimeout(ctx, llm.CallTimeout())
+	defer cancel()
+
+	// When streaming, the reply goes out as soon as reply_to_user is complete;
+	// early holds what was sent. Drafts for approval are never sent early, nor
+	// replies off hours, when a handoff's reply is replaced (see below).
+	var (
+		llmResp *models.LLMResponse
+		raw     string
+		early   string
+		// earlyWamid is early's wamid when it was sent directly.
+		earlyWamid string
+	)
+	offHours := !isBusinessHours(cfg, clock())
+	streamer, canStream := llmProvider.(llm.Streamer)
+	if cfg.LLMStream && canStream && pending == nil && !offHours {
+		llmResp, raw, err = streamer.CompleteStream(llmCtx, cfg.LLMAPIKey, phone, history, func(reply string) {
+			early = cleanReply(ctx, cfg, phone, reply)
+			earlyWamid = sendAssistantReply(ctx, db, cfg, phone, "assistant-"+msgID, early)
+		})
+	} else {
+		llmResp, raw, err = llmProvider.Complete(llmCtx, cfg.LLMAPIKey, phone, history)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: llm error", "phone", phone, "wamid", msgID, "err", err)
+		// llmResp is still a valid fallback — continue processing.
+	}
+	if u := llmResp.Usage; u != nil {
+		if err := db.AddUsage(phone, u.PromptTokens, u.CompletionTokens); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: record llm usage", "phone", phone, "err", err)
+		}
+	}
+	// Without extracted_data there is nothing for staff to act on, and the
+	// salvaged action may not be what the LLM meant.
+	if llmResp.DataMissing && llmResp.Action != "continue" {
+		slog.WarnContext(ctx, "whatsapp: llm response has no extracted_data, overriding action to continue", "phone", phone, "wamid", msgID, "llm_action", llmResp.Action, "event", "action_without_data")
+		llmResp.Action = "continue"
+	}
+	// Once every quote field is collected, staff take it from here. A booking
+	// stands, and conversations already handed off or booked aren't re-sent.
+	if llmResp.Action == "continue" && llmResp.ExtractedData.IsComplete() && !pastCollecting(ctx, db, phone) {
+		slog.InfoContext(ctx, "whatsapp: quote complete, overriding action to handoff", "phone", phone, "wamid", msgID, "llm_action", llmResp.Action, "event", "auto_handoff")
+		llmResp.Action = "handoff"
+	}
+	if llmResp.Action == "schedule" {
+		if missing := missingFields(llmResp.ExtractedData, cfg.ScheduleRequiredFields); len(missing) > 0 {
+			slog.InfoContext(ctx, "whatsapp: schedule before quote fields are known, overriding action to continue", "phone", phone, "wamid", msgID, "missing", missing, "event", "schedule_downgraded")
+			llmResp.Action = "continue"
+		}
+	}
+	if llmResp.Action == "continue" && !llmResp.DataMissing && turnLimitReached(ctx, db, cfg, phone) {
+		slog.InfoContext(ctx, "whatsapp: turn limit reached, overriding action to handoff", "phone", phone, "wamid", msgID, "limit", cfg.HandoffAfterTurns, "event", "turn_limit_handoff")
+		llmResp.Action = "handoff"
+	}
+	if early != "" {
+		// The customer already has this text, even if the rest of the stream failed.
+		llmResp.ReplyToUser = early
+	} else {
+		llmResp.ReplyToUser = cleanReply(ctx, cfg, phone, llmResp.ReplyToUser)
+	}
+
+	// Save extracted quote data, keeping what's stored when none was parsed.
+	if dataJSON, err := json.Marshal(llmResp.ExtractedData); err == nil && !llmResp.DataMissing {
+		_ = db.UpsertQuoteData(phone, string(dataJSON))
+	}
+	if len(llmResp.Flags) > 0 {
+		slog.WarnContext(ctx, "whatsapp: llm flagged a business rule", "phone", phone, "wamid", msgID, "flags", llmResp.Flags, "event", "rule_flagged")
+		if err := db.AddConversationFlags(phone, llmResp.Flags); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: save flags", "phone", phone, "err", err)
+		}
+	}
+
+	// Replace the pending draft with one that accounts for the new message.
+	if pending != nil {
+		if err := db.SetPendingReply(phone, msgID, llmResp.ReplyToUser); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: update pending reply", "phone", phone, "err", err)
+		} else {
+			slog.InfoContext(ctx, "whatsapp: updated reply pending approval", "phone", phone, "wamid", msgID)
+		}
+		return
+	}
+
+	// Off hours, "shortly" isn't true; say when staff will reply instead.
+	if llmResp.Action == "handoff" && offHours {
+		slog.InfoContext(ctx, "whatsapp: handoff outside business hours", "phone", phone, "wamid", msgID, "event", "handoff_after_hours")
+		llmResp.ReplyToUser = fmt.Sprintf(staticReply(db, phone, replies.HandoffAfterHours), businessHoursText(cfg.BusinessHours, clock()))
+	}
+
+	// Save assistant reply. Its id derives from the triggering message, so a
+	// replayed message collides on the primary key instead of replying twice.
+	assistantMsgID := "assistant-" + msgID
+	if err := db.InsertMessage(&models.Message{
+		ID:             assistantMsgID,
+		ConversationID: phone,
+		Role:           "assistant",
+		Content:        llmResp.ReplyToUser,
+		RawLLMResponse: raw,
+		Action:         llmResp.Action,
+		Wamid:          earlyWamid,
+	}); database.IsDuplicateKey(err) {
+		slog.InfoContext(ctx, "whatsapp: message already answered, skipping reply", "phone", phone, "wamid", msgID, "event", "duplicate_reply")
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: insert assistant message", "phone", phone, "wamid", msgID, "err", err)
+	}
+
+	// Execute action.
+	reply := llmResp.ReplyToUser
+	switch llmResp.Action {
+	case "handoff":
+		var name string
+		if conv, err := db.GetConversation(phone); err == nil {
+			name = conv.DisplayName
+		}
+		if err := sendSlackHandoff(ctx, db, cfg, phone, name, llmResp); err != nil {
+			event := "handoff_failed"
+			if isSlackRateLimited(err) {
+				event = "handoff_rate_limited"
+			}
+			slog.ErrorContext(ctx, "whatsapp: slack handoff failed, falling back to continue", "phone", phone, "event", event, "err", err)
+			// Flag it for /admin/failures so the lead isn't lost, and don't
+			// leave the customer hanging; send the reply anyway.
+			if err := db.MarkHandoffFailed(phone); err != nil {
+				slog.ErrorContext(ctx, "whatsapp: mark handoff failed", "phone", phone, "err", err)
+			}
+		} else {
+			if err := db.ClearHandoffFailed(phone); err != nil {
+				slog.ErrorContext(ctx, "whatsapp: clear handoff failed", "phone", phone, "err", err)
+			}
+			metrics.SlackHandoffs.Inc()
+			events.Publish(events.Event{Type: events.Handoff, Phone: phone, MessageID: msgID})
+			advanceStage(ctx, db, phone, models.StageQuoted)
+		}
+		if early == "" {
+			sendAssistantReply(ctx, db, cfg, phone, assistantMsgID, reply)
+		}
+
+	case "schedule":
+		link := staticReply(db, phone, replies.BookingLink) + " " + cfg.BookingURL
+		reply = fmt.Sprintf("%s\n\n%s", llmResp.ReplyToUser, link)
+		if early != "" {
+			sendWhatsApp(ctx, db, cfg, phone, link)
+		} else {
+			sendAssistantReply(ctx, db, cfg, phone, assistantMsgID, reply)
+		}
+		advanceStage(ctx, db, phone, models.StageScheduled)
+
+	default: // "continue"
+		advanceStage(ctx, db, phone, models.StageCollecting)
+		if early == "" {
+			sendAssistantReply(ctx, db, cfg, phone, assistantMsgID, reply)
+		}
+	}
+	events.Publish(events.Event{Type: events.ReplySent, Phone: phone, MessageID: assistantMsgID, Text: reply})
+}
+
+// loadHistory returns the messages to send the LLM: the latest HistoryLimit,
+// or with LLMSummarize the cached summary followed by every message it
+// doesn't cover. Once more than LLMSummarizeAfter aren't covered, all but the
+// latest HistoryLimit are folded into the summary first.
+func loadHistory(ctx context.Context, db *database.DB, cfg *config.Config, phone string) ([]models.Message, error) {
+	summarizer, ok := llmProvider.(llm.Summarizer)
+	if !cfg.LLMSummarize || !ok {
+		return db.GetRecentMessages(phone, cfg.HistoryLimit)
+	}
+	all, err := db.GetAllMessages(phone)
+	if err != nil {
+		return nil, err
+	}
+	summary, through, err := db.GetSummary(phone)
+	if err != nil {
+		return nil, err
+	}
+	// If the last summarized message has been archived since, start stays 0
+	// and the next refresh folds the remaining messages in again.
+	start := slices.IndexFunc(all, func(m models.Message) bool { return m.ID == through }) + 1
+
+	if len(all)-start > cfg.LLMSummarizeAfter {
+		end := len(all) - cfg.HistoryLimit
+		sumCtx, cancel := context.WithTimeout(ctx, llm.CallTimeout())
+		next, err := summarizer.Summarize(sumCtx, cfg.LLMAPIKey, summary, all[start:end])
+		cancel()
+		if err != nil {
+			// Send everything uncovered; the token budget trims what won't fit.
+			slog.ErrorContext(ctx, "whatsapp: summarize history", "phone", phone, "err", err)
+		} else {
+			if err := db.SetSummary(phone, next, all[end-1].ID); err != nil {
+				slog.ErrorContext(ctx, "whatsapp: save history summary", "phone", phone, "err", err)
+			}
+			slog.InfoContext(ctx, "whatsapp: summarized older history", "phone", phone, "messages", end-start, "event", "history_summarized")
+			summary, start = next, end
+		}
+	}
+
+	history := all[start:]
+	if summary == "" {
+		return history, nil
+	}
+	note := models.Message{
+		ConversationID: phone,
+		Role:           "system",
+		Content:        "Summary of the conversation so far: " + summary,
+	}
+	return append([]models.Message{note}, history...), nil
+}
+
+// missingFields returns the fields (JSON names) of data that aren't known.
+func missingFields(data models.ExtractedData, fields []string) []string {
+	values := data.Fields()
+	var missing []string
+	for _, f := range fields {
+		if !models.FieldFilled(values[f]) {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+// clock is the current time; tests pin it to check business hours.
+var clock = time.Now
+
+// isBusinessHours reports whether now falls within cfg.BusinessHours. With
+// none configured it is always business hours.
+func isBusinessHours(cfg *config.Config, now time.Time) bool {
+	h := cfg.BusinessHours
+	if h.Location == nil {
+		return true
+	}
+	t := now.In(h.Location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if h.Open < h.Close {
+		return offset >= h.Open && offset < h.Close
+	}
+	return offset >= h.Open || offset < h.Close // spans midnight
+}
+
+// businessHoursText describes h for customers, e.g. "9am–6pm EDT", using
+// the zone abbreviation in effect at now.
+func businessHoursText(h config.BusinessHours, now time.Time) string {
+	clockText := func(d time.Duration) string {
+		return strings.Replace(time.Time{}.Add(d).Format("3:04pm"), ":00", "", 1)
+	}
+	return fmt.Sprintf("%s–%s %s", clockText(h.Open), clockText(h.Close), now.In(h.Location).Format("MST"))
+}
+
+// pastCollecting reports whether the conversation was already handed off or
+// booked. Lookup errors count as not past, so a handoff isn't lost.
+func pastCollecting(ctx context.Context, db *database.DB, phone string) bool {
+	conv, err := db.GetConversation(phone)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: get conversation stage", "phone", phone, "err", err)
+		return false
+	}
+	return models.StageRank(conv.Stage) > models.StageRank(models.StageCollecting)
+}
+
+// turnLimitReached reports whether the bot has already replied
+// HandoffAfterTurns times in a conversation that hasn't been handed off.
+// Conversations past StageCollecting were handed off or booked already.
+func turnLimitReached(ctx context.Context, db *database.DB, cfg *config.Config, phone string) bool {
+	if cfg.HandoffAfterTurns <= 0 || pastCollecting(ctx, db, phone) {
+		return false
+	}
+	turns, err := db.CountMessagesByRole(phone, "assistant")
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: count assistant turns", "phone", phone, "err", err)
+		return false
+	}
+	return turns >= cfg.HandoffAfterTurns
+}
+
+// advanceStage moves the conversation forward in the funnel to stage. It
+// never moves backwards: a scheduled customer asking a follow-up question
+// stays SCHEDULED.
+func advanceStage(ctx context.Context, db *database.DB, phone, stage string) {
+	conv, err := db.GetConversation(phone)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: get conversation for stage", "phone", phone, "err", err)
+		return
+	}
+	if models.StageRank(stage) <= models.StageRank(conv.Stage) {
+		return
+	}
+	if err := db.SetStage(phone, stage); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: set stage", "phone", phone, "stage", stage, "err", err)
+		return
+	}
+	slog.InfoContext(ctx, "whatsapp: conversation stage changed", "phone", phone, "from", conv.Stage, "to", stage, "event", "stage_changed")
+}
+
+// truncatedMarker is appended to inbound text cut at MaxMessageChars.
+const truncatedMarker = " […truncated]"
+
+// truncateMessage cuts body to at most limit runes plus truncatedMarker,
+// reporting whether it was cut. limit <= 0 disables the check.
+func truncateMessage(body string, limit int) (string, bool) {
+	if limit <= 0 || utf8.RuneCountInString(body) <= limit {
+		return body, false
+	}
+	runes := []rune(body)
+	return string(runes[:limit]) + truncatedMarker, true
+}
+
+// cleanReply strips anything that must never reach the customer verbatim.
+func cleanReply(ctx context.Context, cfg *config.Config, phone, reply string) string {
+	if !cfg.SanitizeReplies {
+		return reply
+	}
+	if clean, changed := sanitizeReply(reply, cfg.ReplyBlockedPatterns); changed {
+		slog.InfoContext(ctx, "whatsapp: sanitized assistant reply", "phone", phone)
+		return clean
+	}
+	return reply
+}
+
+// overActiveCap reports whether a message would open a new bot conversation
+// on a source already at MaxActivePerSource. Conversations the bot is already
+// serving, and paused ones, are never over the cap.
+func overActiveCap(db *database.DB, cfg *config.Config, phone, sourceID string) (bool, error) {
+	if cfg.MaxActivePerSource <= 0 || sourceID == "" {
+		return false, nil
+	}
+	since := time.Now().Add(-cfg.ActiveWindow)
+
+	status, err := db.GetConversationStatus(phone)
+	switch {
+	case err == nil && status == "PAUSED":
+		return false, nil
+	case err != nil && !errors.Is(err, database.ErrConversationNotFound):
+		return false, err
+	}
+
+	if serving, err := db.HasAssistantReplySince(phone, since); err != nil || serving {
+		return false, err
+	}
+	active, err := db.CountActiveConversations(sourceID, since)
+	if err != nil {
+		return false, err
+	}
+	return active >= cfg.MaxActivePerSource, nil
+}
+
+// capacityPollInterval is how often queued conversations are checked
+// against the cap. A var so tests can shrink it.
+var capacityPollInterval = 30 * time.Second
+
+// queuedBatch caps how many queued conversations one pass looks at.
+const queuedBatch = 50
+
+// RunCapacityQueue answers conversations queued over MaxActivePerSource,
+// longest waiting first, as their source frees capacity, until ctx is
+// cancelled.
+func RunCapacityQueue(ctx context.Context, db *database.DB, cfg *config.Config) {
+	ticker := time.NewTicker(capacityPollInterval)
+	defer ticker.Stop()
+
+	for {
+		answerQueued(ctx, db, cfg)
+		select {
+		case <-ctx.Done():
+			slog.Info("whatsapp: capacity queue stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// answerQueued makes one pass over the queue, returning how many
+// conversations it answered. Each answer counts against the cap for the next.
+func answerQueued(ctx context.Context, db *database.DB, cfg *config.Config) int {
+	phones, err := db.QueuedConversations(queuedBatch)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: list queued conversations", "err", err)
+		return 0
+	}
+	answered := 0
+	for _, phone := range phones {
+		if ctx.Err() != nil {
+			break
+		}
+		if answerQueuedConversation(ctx, db, cfg, phone) {
+			answered++
+		}
+	}
+	return answered
+}
+
+// answerQueuedConversation replies to phone's latest message if its source
+// now has capacity. Conversations that were paused or answered meanwhile
+// leave the queue without a reply.
+func answerQueuedConversation(ctx context.Context, db *database.DB, cfg *config.Config, phone string) bool {
+	conv, err := db.GetConversation(phone)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: get queued conversation", "phone", phone, "err", err)
+		return false
+	}
+	if over, err := overActiveCap(db, cfg, phone, conv.SourceID); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: active conversation cap check", "phone", phone, "err", err)
+		return false
+	} else if over {
+		return false
+	}
+
+	mu := lockFor(phone)
+	if !mu.lockWithin(ctx, cfg.LockTimeout) {
+		return false // busy; the next pass retries
+	}
+	defer mu.Unlock()
+
+	dequeue := func() {
+		if err := db.DequeueConversation(phone); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: dequeue conversation", "phone", phone, "err", err)
+		}
+	}
+	status, err := db.GetConversationStatus(phone)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: get status", "phone", phone, "err", err)
+		return false
+	}
+	latest, err := db.GetRecentMessages(phone, 1)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: get history", "phone", phone, "err", err)
+		return false
+	}
+	if status == "PAUSED" || len(latest) == 0 || latest[0].Role != "user" {
+		dequeue()
+		return false
+	}
+
+	slog.InfoContext(ctx, "whatsapp: source has capacity, answering queued conversation", "phone", phone, "wamid", latest[0].ID, "event", "dequeued")
+	respond(ctx, db, cfg, phone, latest[0].ID, latest[0].Content)
+	dequeue()
+	return true
+}
+
+// messageSentAt parses Meta's unix-seconds timestamp. Missing or unparseable
+// values fall back to the receive time, as do implausible ones — further in
+// the future than MessageMaxFutureSkew (clock skew, malformed payloads) or
+// older than MessageMaxAge — so they can't distort ordering or age checks.
+func messageSentAt(ctx context.Context, cfg *config.Config, msg *models.WAMessage, now time.Time) time.Time {
+	if msg.Timestamp == "" {
+		return now
+	}
+	secs, err := strconv.ParseInt(msg.Timestamp, 10, 64)
+	if err != nil {
+		slog.WarnContext(ctx, "whatsapp: invalid timestamp, using receive time", "wamid", msg.ID, "timestamp", msg.Timestamp)
+		return now
+	}
+	sent := time.Unix(secs, 0)
+	if sent.After(now.Add(cfg.MessageMaxFutureSkew)) || sent.Before(now.Add(-cfg.MessageMaxAge)) {
+		slog.WarnContext(ctx, "whatsapp: implausible timestamp, clamping to receive time", "wamid", msg.ID, "timestamp", sent.UTC().Format(time.RFC3339))
+		return now
+	}
+	return sent
+}
+
+// resolveLanguage records the language detected in text and returns the
+// language replies must use, or "" when no lock applies. With LANGUAGE_LOCK
+// the first detected language sticks unless the customer explicitly asks to
+// switch; without it the stored language simply tracks the latest message.
+func resolveLanguage(ctx context.Context, db *database.DB, cfg *config.Config, phone, text string) string {
+	stored, err := db.GetConversationLanguage(phone)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: get language", "phone", phone, "err", err)
+		return ""
+	}
+
+	requested := language.RequestedSwitch(text)
+	next := stored
+	switch {
+	case requested != "":
+		next = requested
+	case !cfg.LanguageLock || stored == "":
+		if detected := language.Detect(text); detected != "" {
+			next = detected
+		}
+	}
+
+	if next != stored {
+		if err := db.SetConversationLanguage(phone, next); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: set language", "phone", phone, "err", err)
+		} else if cfg.LanguageLock {
+			slog.InfoContext(ctx, "whatsapp: conversation language locked", "phone", phone, "language", next)
+		}
+	}
+
+	if !cfg.LanguageLock {
+		return ""
+	}
+	return next
+}
+
+// localeNote tells the LLM the customer's language and timezone so dates
+// and times come out right, guessing the timezone from the phone number the
+// first time. It returns "" when neither is known.
+func localeNote(ctx context.Context, db *database.DB, phone string) string {
+	conv, err := db.GetConversation(phone)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: get locale", "phone", phone, "err", err)
+		return ""
+	}
+	if conv.Timezone == "" {
+		if tz := phoneTimezone(phone); tz != "" {
+			if err := db.SetConversationLocale(phone, "", tz); err != nil {
+				slog.ErrorContext(ctx, "whatsapp: set locale", "phone", phone, "err", err)
+			}
+			conv.Timezone = tz
+		}
+	}
+	var parts []string
+	if conv.Language != "" {
+		parts = append(parts, "Customer language: "+conv.Language)
+	}
+	if conv.Timezone != "" {
+		parts = append(parts, "timezone: "+conv.Timezone)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	note := strings.Join(parts, "; ")
+	return strings.ToUpper(note[:1]) + note[1:]
+}
+
+// ─── Outbound WhatsApp ────────────────────────────────────────────────────────
+
+// sendWhatsApp sends a static text message. With OutboundQueue it is queued
+// for the outbound worker, so it survives a crash and is retried; otherwise it
+// is sent now. Failed sends are logged to outbound_failures so ops can replay
+// them after a Meta outage.
+func sendWhatsApp(ctx context.Context, db *database.DB, cfg *config.Config, to, body string) {
+	sendText(ctx, db, cfg, to, "", body)
+}
+
+// sendAssistantReply sends the assistant message messageID, signed with
+// cfg.SenderFooter when enabled. The stored message stays unsigned so the
+// footer never reaches the LLM's history. It returns the wamid when the reply
+// was sent directly; queued replies get theirs from the outbound worker.
+func sendAssistantReply(ctx context.Context, db *database.DB, cfg *config.Config, to, messageID, body string) string {
+	if cfg.SenderFooterEnabled && cfg.SenderFooter != "" {
+		body += "\n\n" + cfg.SenderFooter
+	}
+	return sendText(ctx, db, cfg, to, messageID, body)
+}
+
+// sendText sends body to to, recording the wamid on messageID's row when it
+// names a stored assistant message.
+func sendText(ctx context.Context, db *database.DB, cfg *config.Config, to, messageID, body string) string {
+	if cfg.OutboundQueue {
+		_, err := db.EnqueueOutbound(to, body, messageID, logging.TraceID(ctx))
+		if err == nil {
+			wakeOutbound()
+			return ""
+		}
+		slog.ErrorContext(ctx, "whatsapp: enqueue reply, sending directly", "phone", to, "err", err)
+	}
+	status, wamid, err := postWhatsApp(ctx, cfg, to, textPayload(to, body))
+	if err != nil {
+		recordOutboundFailure(ctx, db, to, body, status, err.Error())
+		return ""
+	}
+	if messageID != "" && wamid != "" {
+		if err := db.SetMessageWamid(messageID, wamid); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: record reply wamid", "phone", to, "wamid", wamid, "err", err)
+		}
+	}
+	return wamid
+}
+
+func textPayload(to, body string) map[string]any {
+	return map[string]any{
+		"messaging_product": "whatsapp",
+		"to":                to,
+		"type":              "text",
+		"text":              map[string]string{"body": body},
+	}
+}
+
+// markRead shows the customer's message as read (blue ticks) and, with
+// TypingIndicator, a typing indicator until our reply arrives or 25s pass.
+// Failures are logged and never stop processing.
+func markRead(ctx context.Context, cfg *config.Config, messageID string) {
+	payload := map[string]any{
+		"messaging_product": "whatsapp",
+		"status":            "read",
+		"message_id":        messageID,
+	}
+	if cfg.TypingIndicator {
+		payload["typing_indicator"] = map[string]string{"type": "text"}
+	}
+	if _, _, err := postWhatsApp(ctx, cfg, "", payload); err != nil {
+		slog.WarnContext(ctx, "whatsapp: mark read failed", "wamid", messageID, "err", err)
+	}
+}
+
+// sendWhatsAppTemplate sends an approved message template, the only kind of
+// message WhatsApp accepts once the 24h customer-service window has closed
+// (see within24h). params fill the template body's {{1}}, {{2}}, … in order.
+func sendWhatsAppTemplate(ctx context.Context, db *database.DB, cfg *config.Config, to, templateName string, params []string) {
+	template := map[string]any{
+		"name":     templateName,
+		"language": map[string]string{"code": cfg.TemplateLanguage},
+	}
+	if len(params) > 0 {
+		values := make([]map[string]string, len(params))
+		for i, p := range params {
+			values[i] = map[string]string{"type": "text", "text": p}
+		}
+		template["components"] = []map[string]any{{"type": "body", "parameters": values}}
+	}
+	status, _, err := postWhatsApp(ctx, cfg, to, map[string]any{
+		"messaging_product": "whatsapp",
+		"to":                to,
+		"type":              "template",
+		"template":          template,
+	})
+	if err != nil {
+		recordOutboundFailure(ctx, db, to, "template:"+templateName, status, err.Error())
+	}
+}
+
+// postWhatsApp posts payload to the Meta messages API, returning the status
+// code and the wamid Meta assigned the message ("" for read receipts and dry
+// runs). On a non-200 response the error carries Meta's response body; the
+// status is 0 when no response was received.
+func postWhatsApp(ctx context.Context, cfg *config.Config, to string, payload map[string]any) (int, string, error) {
+	url := fmt.Sprintf("%s/v18.0/%s/messages", metaAPIBaseURL, cfg.MetaPhoneNumberID)
+	payloadBytes, _ := json.Marshal(payload)
+	if dryRun(ctx, cfg, "whatsapp", to, payloadBytes) {
+		return http.StatusOK, "", nil
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, url, bytes.NewReader(payloadBytes))
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: send: create request", "phone", to, "err", err)
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.MetaAccessToken)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.ErrorContext(ctx, "whatsapp: send: http error", "phone", to, "err", err)
+		return 0, "", err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("whatsapp: send: unexpected status %d: %s", resp.StatusCode, string(body))
+		respBody, _ := io.ReadAll(resp.Body)
+		slog.ErrorContext(ctx, "whatsapp: send: unexpected status", "phone", to, "status", resp.StatusCode, "body", string(respBody))
+		return resp.StatusCode, "", errors.New(string(respBody))
 	}
+	var sent struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&sent); err == nil && len(sent.Messages) > 0 {
+		return resp.StatusCode, sent.Messages[0].ID, nil
+	}
+	return resp.StatusCode, "", nil
+}
+
+// dryRun logs an outbound payload instead of sending it when DRY_RUN is set,
+// reporting whether the caller should skip the request and treat it as sent.
+func dryRun(ctx context.Context, cfg *config.Config, target, phone string, payload []byte) bool {
+	if !cfg.DryRun {
+		return false
+	}
+	slog.InfoContext(ctx, "dry run: not sending", "target", target, "phone", phone, "payload", string(payload), "event", "dry_run")
+	return true
+}
+
+// customerServiceWindow is how long after a customer's last message WhatsApp
+// allows free-form replies.
+const customerServiceWindow = 24 * time.Hour
+
+// within24h reports whether phone's customer-service window is open, i.e.
+// free-form messages are allowed rather than templates only.
+func within24h(db *database.DB, phone string) (bool, error) {
+	last, err := db.GetLastInboundTime(phone)
+	if err != nil || last.IsZero() {
+		return false, err
+	}
+	return time.Since(last) < customerServiceWindow, nil
+}
+
+func recordOutboundFailure(ctx context.Context, db *database.DB, to, body string, statusCode int, errText string) {
+	if err := db.InsertOutboundFailure(&models.OutboundFailure{
+		ConversationID: to,
+		Body:           body,
+		StatusCode:     statusCode,
+		Error:          errText,
+	}); err != nil {
+		slog.ErrorContext(ctx, "whatsapp: record outbound failure", "phone", to, "err", err)
+	}
+}
+
+// fetchMediaURL resolves a media id to its download URL. Meta's URLs need
+// the access token and expire after a few minutes.
+func fetchMediaURL(ctx context.Context, cfg *config.Config, mediaID string) (string, error) {
+	endpoint := fmt.Sprintf("%s/v18.0/%s", metaAPIBaseURL, url.PathEscape(mediaID))
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.MetaAccessToken)
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(b))
+	}
+	var media struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&media); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+	return media.URL, nil
+}
+
+// fetchMedia opens the media behind mediaID for download. The caller closes
+// the response body.
+func fetchMedia(ctx context.Context, cfg *config.Config, mediaID string) (*http.Response, error) {
+	link, err := fetchMediaURL(ctx, cfg, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.MetaAccessToken)
+
+	resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http error: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(b))
+	}
+	return resp, nil
 }
 
 // ─── Slack handoff ────────────────────────────────────────────────────────────
 
-func sendSlackHandoff(cfg *config.Config, phone string, llmResp *models.LLMResponse) error {
+// sendSlackHandoff posts the quote to staff. name is the customer's WhatsApp
+// profile name and is omitted when empty. With a bot token configured,
+// repeat handoffs for a customer reply in the thread of their first.
+func sendSlackHandoff(ctx context.Context, db *database.DB, cfg *config.Config, phone, name string, llmResp *models.LLMResponse) error {
 	data := llmResp.ExtractedData
+	text := "*New Quote Request*\n"
+	if flags := handoffFlags(ctx, db, phone, llmResp.Flags); len(flags) > 0 {
+		text += fmt.Sprintf("⚠️ *Flags:* %s\n", slackEscape(strings.Join(flags, ", ")))
+	}
+	if name != "" {
+		text += fmt.Sprintf("*Name:* %s\n", slackEscape(name))
+	}
+	text += fmt.Sprintf(
+		"*Phone:* %s\n*Address:* %s\n*Inventory:* %s\n*Stairs:* %s\n*Elevator:* %s",
+		phone, slackEscape(data.Address), slackEscape(data.Inventory), slackEscape(data.Stairs), slackEscape(data.ElevatorAccess),
+	)
+	text += forwardedNote(ctx, db, phone)
+	if link := signedAdminLink(cfg, "/admin/conversations/"+phone, clock()); link != "" {
+		text += fmt.Sprintf("\n<%s|View transcript>", link)
+	}
 	payload := map[string]any{
 		"text": fmt.Sprintf("New Quote Request from +%s", phone),
 		"blocks": []any{
@@ -277,10 +1782,7 @@ func sendSlackHandoff(cfg *config.Config, phone string, llmResp *models.LLMRespo
 				"type": "section",
 				"text": map[string]any{
 					"type": "mrkdwn",
-					"text": fmt.Sprintf(
-						"*New Quote Request*\n*Phone:* %s\n*Address:* %s\n*Inventory:* %s\n*Stairs:* %s\n*Elevator:* %s",
-						phone, data.Address, data.Inventory, data.Stairs, data.ElevatorAccess,
-					),
+					"text": text,
 				},
 			},
 			map[string]any{
@@ -292,32 +1794,151 @@ func sendSlackHandoff(cfg *config.Config, phone string, llmResp *models.LLMRespo
 						"value":     phone,
 						"text":      map[string]string{"type": "plain_text", "text": "Take Over Chat"},
 					},
+					map[string]any{
+						"type":      "button",
+						"action_id": "resume_bot",
+						"value":     phone,
+						"text":      map[string]string{"type": "plain_text", "text": "Resume Bot"},
+					},
 				},
 			},
 		},
 	}
 
+	if cfg.SlackBotToken != "" {
+		return postSlackThreaded(ctx, db, cfg, phone, payload)
+	}
+
 	payloadBytes, _ := json.Marshal(payload)
+	if dryRun(ctx, cfg, "slack", phone, payloadBytes) {
+		return nil
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	return postSlack(ctx, routeSlackWebhook(cfg, phone), payloadBytes)
+}
+
+// handoffFlags returns the conversation's stored flags plus any on the
+// current response not yet saved.
+func handoffFlags(ctx context.Context, db *database.DB, phone string, current []string) []string {
+	var flags []string
+	if conv, err := db.GetConversation(phone); err == nil {
+		flags = conv.Flags
+	} else {
+		slog.ErrorContext(ctx, "whatsapp: get conversation flags", "phone", phone, "err", err)
+	}
+	for _, f := range current {
+		if !slices.Contains(flags, f) {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.SlackWebhookURL, bytes.NewReader(payloadBytes))
+// maxForwardedShown caps how many forwarded messages a handoff quotes.
+const maxForwardedShown = 3
+
+// forwardedNote quotes the customer's latest forwarded messages for the
+// handoff, since a forwarded competitor quote is worth staff's attention.
+// It is "" when nothing was forwarded.
+func forwardedNote(ctx context.Context, db *database.DB, phone string) string {
+	fwd, err := db.ListForwardedMessages(phone, maxForwardedShown)
 	if err != nil {
-		return fmt.Errorf("slack: create request: %w", err)
+		slog.ErrorContext(ctx, "whatsapp: list forwarded messages", "phone", phone, "err", err)
+		return ""
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if len(fwd) == 0 {
+		return ""
+	}
+	note := "\n*Forwarded by customer:*"
+	for _, m := range fwd {
+		content := m.Content
+		if r := []rune(content); len(r) > maxQuotedChars {
+			content = string(r[:maxQuotedChars]) + "…"
+		}
+		label := "forwarded"
+		if m.Forwarded == models.FrequentlyForwarded {
+			label = "frequently forwarded"
+		}
+		note += fmt.Sprintf("\n> %s _(%s)_", slackEscape(strings.ReplaceAll(content, "\n", " ")), label)
+	}
+	return note
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+// slackEscaper escapes the characters Slack mrkdwn treats as markup, so
+// customer text can't inject links or mentions like <!channel>.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackEscape makes s safe to embed in Slack mrkdwn.
+func slackEscape(s string) string { return slackEscaper.Replace(s) }
+
+// postSlackThreaded posts payload to SlackChannel, as a reply in the
+// conversation's thread when it has one. The first post's ts becomes the
+// thread; if it can't be stored the next handoff just starts a new one.
+func postSlackThreaded(ctx context.Context, db *database.DB, cfg *config.Config, phone string, payload map[string]any) error {
+	threadTS, err := db.GetSlackThreadTS(phone)
 	if err != nil {
-		return fmt.Errorf("slack: post error: %w", err)
+		slog.WarnContext(ctx, "whatsapp: get slack thread", "phone", phone, "err", err)
+	}
+	payload["channel"] = cfg.SlackChannel
+	if threadTS != "" {
+		payload["thread_ts"] = threadTS
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("slack: unexpected status %d: %s", resp.StatusCode, string(b))
+	payloadBytes, _ := json.Marshal(payload)
+	if dryRun(ctx, cfg, "slack", phone, payloadBytes) {
+		return nil
+	}
+
+	ts, err := postSlackMessage(ctx, cfg.SlackBotToken, payloadBytes)
+	if err != nil {
+		return err
+	}
+	if threadTS == "" && ts != "" {
+		if err := db.SetSlackThreadTS(phone, ts); err != nil {
+			slog.ErrorContext(ctx, "whatsapp: set slack thread", "phone", phone, "err", err)
+		}
 	}
 	return nil
 }
+
+// routeSlackWebhook picks the Slack webhook for a customer's number: the
+// longest SlackWebhookRoutes prefix matching its area code, else
+// SlackWebhookURL. Area codes are North American, so numbers outside +1
+// always use the default.
+func routeSlackWebhook(cfg *config.Config, phone string) string {
+	if len(phone) != 11 || !strings.HasPrefix(phone, "1") {
+		return cfg.SlackWebhookURL
+	}
+	for _, route := range cfg.SlackWebhookRoutes {
+		if strings.HasPrefix(phone[1:], route.Prefix) {
+			return route.URL
+		}
+	}
+	return cfg.SlackWebhookURL
+}
+
+// sendSlackMediaNotice tells staff a customer sent a photo or document.
+// link is a signed /admin/media URL, empty without BASE_URL or ADMIN_TOKEN.
+func sendSlackMediaNotice(ctx context.Context, cfg *config.Config, phone, kind, caption, link string) error {
+	text := fmt.Sprintf("*New %s from +%s*", kind, phone)
+	if caption != "" {
+		text += fmt.Sprintf("\n*Caption:* %s", slackEscape(caption))
+	}
+	if link != "" {
+		text += fmt.Sprintf("\n<%s|Open %s>", link, kind)
+	}
+	payloadBytes, _ := json.Marshal(map[string]any{
+		"text": fmt.Sprintf("New %s from +%s", kind, phone),
+		"blocks": []any{
+			map[string]any{
+				"type": "section",
+				"text": map[string]any{"type": "mrkdwn", "text": text},
+			},
+		},
+	})
+	if dryRun(ctx, cfg, "slack", phone, payloadBytes) {
+		return nil
+	}
+
+	return postSlack(ctx, routeSlackWebhook(cfg, phone), payloadBytes)
+}
@@ -0,0 +1,98 @@
+// Package language provides lightweight, dependency-free language detection
+// for inbound customer messages. It only distinguishes the languages we serve
+// and returns "" when a message is too short or ambiguous to call.
+package language
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Supported language codes (ISO 639-1).
+const (
+	English = "en"
+	Spanish = "es"
+	French  = "fr"
+)
+
+var names = map[string]string{
+	English: "English",
+	Spanish: "Spanish",
+	French:  "French",
+}
+
+// stopwords are frequent function words that rarely overlap between the
+// supported languages. Shared words ("a", "de", "la"…) are omitted.
+var stopwords = map[string][]string{
+	English: {"the", "and", "is", "are", "i", "you", "my", "have", "to", "of", "it", "this", "need", "with", "can", "what", "please", "thanks", "hello", "hi", "yes", "no"},
+	Spanish: {"el", "los", "las", "y", "es", "son", "yo", "tengo", "necesito", "mi", "por", "para", "con", "hola", "gracias", "sí", "quiero", "que", "una", "del", "puede", "favor"},
+	French:  {"le", "les", "et", "est", "je", "j'ai", "mon", "ma", "pour", "avec", "bonjour", "merci", "oui", "non", "une", "du", "des", "besoin", "vous", "peux"},
+}
+
+// minScore is how many stopword hits a message needs before we trust it.
+const minScore = 2
+
+// Name returns the English name for a language code, or the code itself.
+func Name(code string) string {
+	if n, ok := names[code]; ok {
+		return n
+	}
+	return code
+}
+
+// Detect returns the most likely language code for text, or "" when no
+// supported language clearly wins.
+func Detect(text string) string {
+	words := tokenize(text)
+	scores := make(map[string]int, len(stopwords))
+	for _, w := range words {
+		for lang, list := range stopwords {
+			for _, sw := range list {
+				if w == sw {
+					scores[lang]++
+				}
+			}
+		}
+	}
+
+	best, bestScore, tie := "", 0, false
+	for lang, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, tie = lang, score, false
+		case score == bestScore:
+			tie = true
+		}
+	}
+	if bestScore < minScore || tie {
+		return ""
+	}
+	return best
+}
+
+// switchRe matches explicit requests to change language, e.g. "in English
+// please", "reply in french", "¿puedes hablar en español?".
+var switchRe = regexp.MustCompile(`(?i)\b(?:in|en)\s+(english|ingl[eé]s|anglais|spanish|espa[nñ]ol|espagnol|french|franc[eé]s|fran[cç]ais)\b`)
+
+var switchTargets = map[string]string{
+	"english": English, "ingles": English, "inglés": English, "anglais": English,
+	"spanish": Spanish, "espanol": Spanish, "español": Spanish, "espagnol": Spanish,
+	"french": French, "frances": French, "francés": French, "francais": French, "français": French,
+}
+
+// RequestedSwitch returns the language the customer explicitly asked to
+// switch to, or "" when the message contains no such request.
+func RequestedSwitch(text string) string {
+	m := switchRe.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	return switchTargets[strings.ToLower(m[1])]
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+}
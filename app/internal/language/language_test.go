@@ -0,0 +1,38 @@
+package language
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"Hi, I need to get rid of the couch in my basement", English},
+		{"Hola, necesito que recojan un sofá por favor", Spanish},
+		{"Bonjour, j'ai besoin de vous pour un frigo", French},
+		{"ok", ""},
+		{"123 Main St", ""},
+	}
+	for _, tc := range cases {
+		if got := Detect(tc.text); got != tc.want {
+			t.Errorf("Detect(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestRequestedSwitch(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"Can you reply in English please?", English},
+		{"¿Puedes hablar en español?", Spanish},
+		{"Parlez en français svp", French},
+		{"I'm in Toronto", ""},
+	}
+	for _, tc := range cases {
+		if got := RequestedSwitch(tc.text); got != tc.want {
+			t.Errorf("RequestedSwitch(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
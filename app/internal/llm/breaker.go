@@ -0,0 +1,100 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"clearoutspaces/internal/models"
+)
+
+// Circuit breaker policy: after breakerThreshold consecutive failed requests,
+// calls short-circuit to fallback() for breakerCooldown, then a single trial
+// request decides whether to close the circuit again. Vars so tests can
+// shrink them.
+var (
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without contacting DeepSeek while the breaker
+// is open.
+var ErrCircuitOpen = errors.New("llm: circuit breaker open")
+
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool // a trial request is in flight
+}
+
+var breaker circuitBreaker
+
+// allow reports whether a request may go out. Once the cooldown has passed
+// it lets exactly one trial through until that trial is recorded.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < breakerThreshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record counts the outcome of a request allow let through.
+func (b *circuitBreaker) record(ctx context.Context, now time.Time, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		if b.failures >= breakerThreshold {
+			slog.InfoContext(ctx, "llm: circuit breaker closed")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openUntil = now.Add(breakerCooldown)
+		slog.WarnContext(ctx, "llm: circuit breaker open", "failures", b.failures, "cooldown", breakerCooldown.String())
+	}
+}
+
+// release ends a trial without counting it either way.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+}
+
+// post sends the request through the circuit breaker. Network errors and
+// 429/5xx responses count as failures; other responses, including schema
+// violations in a 200 body, mean the provider is up. The usage is what any
+// retried attempts reported.
+func post(ctx context.Context, url, apiKey string, reqBody []byte) (*http.Response, *models.LLMUsage, error) {
+	if !breaker.allow(time.Now()) {
+		return nil, nil, ErrCircuitOpen
+	}
+	resp, retried, err := postWithRetry(ctx, url, apiKey, reqBody)
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up; that says nothing about DeepSeek.
+		breaker.release()
+		return resp, retried, err
+	}
+	ok := err == nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500
+	breaker.record(ctx, time.Now(), ok)
+	return resp, retried, err
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withBreaker shrinks the breaker policy for the duration of the test.
+func withBreaker(t *testing.T, threshold int, cooldown time.Duration) {
+	t.Helper()
+	prevThreshold, prevCooldown := breakerThreshold, breakerCooldown
+	breakerThreshold, breakerCooldown = threshold, cooldown
+	t.Cleanup(func() { breakerThreshold, breakerCooldown = prevThreshold, prevCooldown })
+}
+
+func TestBreaker_OpensAfterConsecutiveFailures(t *testing.T) {
+	withRetryPolicy(t, 1, 0)
+	withBreaker(t, 3, time.Hour)
+	var hits atomic.Int32
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := Call(context.Background(), "key", "", history()); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: expected a server error, got %v", i+1, err)
+		}
+	}
+
+	start := time.Now()
+	resp, _, err := Call(context.Background(), "key", "", history())
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if !reflect.DeepEqual(*resp, *fallback()) {
+		t.Errorf("expected fallback response, got %+v", resp)
+	}
+	if _, _, err := CallStream(context.Background(), "key", "", history(), nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected streaming calls to short-circuit too, got %v", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("expected open-circuit calls to return immediately, took %v", d)
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("expected 3 requests to reach the server, got %d", n)
+	}
+}
+
+func TestBreaker_TrialAfterCooldown(t *testing.T) {
+	withRetryPolicy(t, 1, 0)
+	withBreaker(t, 2, 20*time.Millisecond)
+	var healthy atomic.Bool
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(okBody(validContent)))
+	})
+
+	for i := 0; i < 2; i++ {
+		_, _, _ = Call(context.Background(), "key", "", history())
+	}
+	if _, _, err := Call(context.Background(), "key", "", history()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+
+	// A failed trial reopens the circuit for another cooldown.
+	time.Sleep(30 * time.Millisecond)
+	if _, _, err := Call(context.Background(), "key", "", history()); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the trial to reach the server and fail, got %v", err)
+	}
+	if _, _, err := Call(context.Background(), "key", "", history()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected circuit reopened after failed trial, got %v", err)
+	}
+
+	// A successful trial closes it.
+	healthy.Store(true)
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if _, _, err := Call(context.Background(), "key", "", history()); err != nil {
+			t.Fatalf("call %d after recovery: %v", i+1, err)
+		}
+	}
+}
+
+func TestBreaker_IgnoresClientErrors(t *testing.T) {
+	withRetryPolicy(t, 1, 0)
+	withBreaker(t, 2, time.Hour)
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	for i := 0; i < 4; i++ {
+		if _, _, err := Call(context.Background(), "key", "", history()); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: a 400 must not trip the breaker", i+1)
+		}
+	}
+}
@@ -2,12 +2,25 @@ package llm
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/sync/singleflight"
+
+	"clearoutspaces/internal/metrics"
 	"clearoutspaces/internal/models"
 )
 
@@ -17,14 +30,49 @@ var deepSeekURL = "https://api.deepseek.com/chat/completions"
 const (
 	deepSeekModel = "deepseek-chat"
 	httpTimeout   = 30 * time.Second
+
+	// callTimeoutMargin keeps the caller's deadline past the client timeout,
+	// so a slow provider surfaces as a client timeout, not a canceled ctx.
+	callTimeoutMargin = 5 * time.Second
 )
 
 var httpClient = &http.Client{Timeout: httpTimeout}
 
+// tuningMu guards httpClient, the retry policy, maxPromptTokens, dedupe and
+// useJSONSchema, which a config reload may change while calls are in flight.
+var tuningMu sync.RWMutex
+
+// CallTimeout is the deadline callers should give a Call: the HTTP client
+// timeout plus a small margin.
+func CallTimeout() time.Duration {
+	tuningMu.RLock()
+	defer tuningMu.RUnlock()
+	return httpClient.Timeout + callTimeoutMargin
+}
+
+// Retry policy for transient DeepSeek failures (network errors, 429, 5xx).
+// Vars so tests and config can shrink or tune them.
+var (
+	maxAttempts = 3
+	baseDelay   = 500 * time.Millisecond
+)
+
+// maxPromptTokens is the rough token budget for a request's messages
+// (0 = unlimited); see trimToBudget.
+var maxPromptTokens = 8000
+
 type deepSeekRequest struct {
 	Model          string              `json:"model"`
 	Messages       []models.LLMMessage `json:"messages"`
-	ResponseFormat map[string]string   `json:"response_format"`
+	ResponseFormat map[string]any      `json:"response_format,omitempty"`
+	Stream         bool                `json:"stream,omitempty"`
+	StreamOptions  *streamOptions      `json:"stream_options,omitempty"`
+}
+
+// streamOptions asks for a final chunk carrying the stream's token usage,
+// which streams otherwise omit.
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type deepSeekResponse struct {
@@ -33,77 +81,394 @@ type deepSeekResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *models.LLMUsage `json:"usage"`
 }
 
-// Call sends the conversation history to DeepSeek and returns a validated LLMResponse.
-// Falls back gracefully on LLM errors — never returns a nil LLMResponse when err == nil.
-func Call(ctx context.Context, apiKey string, history []models.Message) (*models.LLMResponse, error) {
-	msgs := []models.LLMMessage{
-		{Role: "system", Content: SystemPrompt()},
+// Call sends phone's conversation history to DeepSeek and returns a validated LLMResponse
+// along with the raw message content DeepSeek returned ("" if none was received).
+// Falls back gracefully on LLM errors — never returns a nil LLMResponse. Content
+// that breaks the schema returns ErrLLMSchema, with the repaired response when
+// the reply was still usable.
+// The response carries the call's token usage when DeepSeek reported it.
+func Call(ctx context.Context, apiKey, phone string, history []models.Message) (*models.LLMResponse, string, error) {
+	return complete(ctx, deepSeek(), apiKey, phone, history)
+}
+
+// endpoint is an OpenAI-style chat completions API and the model to ask.
+type endpoint struct {
+	url   string
+	model string
+}
+
+func deepSeek() endpoint {
+	return endpoint{url: deepSeekURL, model: deepSeekModel}
+}
+
+func complete(ctx context.Context, ep endpoint, apiKey, phone string, history []models.Message) (*models.LLMResponse, string, error) {
+	reqBody, err := buildRequest(ep.model, phone, history, false)
+	if err != nil {
+		return fallback(), "", err
 	}
-	for _, m := range history {
-		msgs = append(msgs, models.LLMMessage{Role: m.Role, Content: m.Content})
+	do := func(ctx context.Context) (*models.LLMResponse, string, error) {
+		start := time.Now()
+		resp, raw, err := call(ctx, ep, apiKey, reqBody)
+		metrics.ObserveLLMCall(time.Since(start), err)
+		return resp, raw, err
 	}
 
-	reqBody, err := json.Marshal(deepSeekRequest{
-		Model:          deepSeekModel,
-		Messages:       msgs,
-		ResponseFormat: map[string]string{"type": "json_object"},
-	})
-	if err != nil {
-		return fallback(), fmt.Errorf("llm: marshal request: %w", err)
+	tuningMu.RLock()
+	shared := dedupe
+	tuningMu.RUnlock()
+	if !shared {
+		return do(ctx)
 	}
+	return sharedCall(ctx, ep.url+"\x00"+phone, reqBody, do)
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, deepSeekURL, bytes.NewReader(reqBody))
-	if err != nil {
-		return fallback(), fmt.Errorf("llm: create request: %w", err)
+// inflight shares one upstream call between concurrent identical requests,
+// e.g. a retried webhook racing the original; dedupe switches it off.
+var (
+	inflight singleflight.Group
+	dedupe   = true
+)
+
+type callResult struct {
+	resp *models.LLMResponse
+	raw  string
+	// usageTaken is set by the first caller to receive the result, which
+	// alone reports its token usage.
+	usageTaken *atomic.Bool
+}
+
+// sharedCall runs do once for every concurrent caller with the same key and
+// request body. The call is detached from the caller that starts it, so its
+// cancellation can't fail the others, but keeps that caller's deadline. Each
+// caller still returns as soon as its own ctx is done, and gets its own copy
+// of the response; the first to get it reports the token usage, so usage is
+// counted once even when the caller that started the call gave up.
+func sharedCall(ctx context.Context, key string, reqBody []byte, do func(context.Context) (*models.LLMResponse, string, error)) (*models.LLMResponse, string, error) {
+	sum := sha256.Sum256(append([]byte(key+"\x00"), reqBody...))
+	ch := inflight.DoChan(string(sum[:]), func() (any, error) {
+		callCtx, cancel := context.WithoutCancel(ctx), context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			callCtx, cancel = context.WithDeadline(callCtx, deadline)
+		}
+		defer cancel()
+		resp, raw, err := do(callCtx)
+		return callResult{resp, raw, new(atomic.Bool)}, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return fallback(), "", fmt.Errorf("llm: http call failed: %w", ctx.Err())
+	case r := <-ch:
+		res := r.Val.(callResult)
+		resp := *res.resp
+		if !res.usageTaken.CompareAndSwap(false, true) {
+			resp.Usage = nil
+		}
+		return &resp, res.raw, r.Err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+}
 
-	resp, err := httpClient.Do(req)
+// call makes the request, with retries. The response carries the usage of
+// every attempt that reported any, even when the call failed.
+func call(ctx context.Context, ep endpoint, apiKey string, reqBody []byte) (*models.LLMResponse, string, error) {
+	resp, usage, err := post(ctx, ep.url, apiKey, reqBody)
 	if err != nil {
-		return fallback(), fmt.Errorf("llm: http call failed: %w", err)
+		return failed(usage), "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fallback(), fmt.Errorf("llm: unexpected status %d", resp.StatusCode)
+		return failed(addUsage(usage, bodyUsage(resp.Body))), "", fmt.Errorf("llm: unexpected status %d", resp.StatusCode)
 	}
 
 	var dsResp deepSeekResponse
 	if err := json.NewDecoder(resp.Body).Decode(&dsResp); err != nil {
-		return fallback(), fmt.Errorf("llm: decode response: %w", err)
+		return failed(usage), "", fmt.Errorf("llm: decode response: %w", err)
 	}
+	usage = addUsage(usage, dsResp.Usage)
 	if len(dsResp.Choices) == 0 {
-		return fallback(), fmt.Errorf("llm: empty choices")
+		return failed(usage), "", fmt.Errorf("llm: empty choices")
+	}
+
+	raw := dsResp.Choices[0].Message.Content
+	llmResp, err := parseContent(raw)
+	if llmResp == nil {
+		llmResp = fallback()
+	}
+	llmResp.Usage = usage
+	return llmResp, raw, err
+}
+
+// failed returns fallback() carrying usage, so tokens billed for a failed
+// call are still recorded.
+func failed(usage *models.LLMUsage) *models.LLMResponse {
+	resp := fallback()
+	resp.Usage = usage
+	return resp
+}
+
+// addUsage returns the sum of a and b, either of which may be nil.
+func addUsage(a, b *models.LLMUsage) *models.LLMUsage {
+	if a == nil || b == nil {
+		return cmp.Or(a, b)
+	}
+	return &models.LLMUsage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+	}
+}
+
+// bodyUsage reads the usage, if any, from a response body about to be
+// discarded.
+func bodyUsage(body io.Reader) *models.LLMUsage {
+	var parsed struct {
+		Usage *models.LLMUsage `json:"usage"`
+	}
+	_ = json.NewDecoder(io.LimitReader(body, 64<<10)).Decode(&parsed)
+	return parsed.Usage
+}
+
+// buildRequest assembles the request body for model: phone's system prompt
+// variant, then the history trimmed to the token budget.
+func buildRequest(model, phone string, history []models.Message, stream bool) ([]byte, error) {
+	msgs := []models.LLMMessage{
+		{Role: "system", Content: SystemPromptFor(phone)},
+	}
+	for _, m := range history {
+		msgs = append(msgs, models.LLMMessage{Role: m.Role, Content: m.Content})
 	}
+	tuningMu.RLock()
+	budget := maxPromptTokens
+	tuningMu.RUnlock()
+	msgs = trimToBudget(msgs, budget)
 
-	var llmResp models.LLMResponse
-	if err := json.Unmarshal([]byte(dsResp.Choices[0].Message.Content), &llmResp); err != nil {
-		return fallback(), fmt.Errorf("llm: parse JSON content: %w", err)
+	req := deepSeekRequest{
+		Model:          model,
+		Messages:       msgs,
+		ResponseFormat: responseFormat(),
+		Stream:         stream,
 	}
+	if stream {
+		req.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("llm: marshal request: %w", err)
+	}
+	return reqBody, nil
+}
+
+// ErrLLMSchema marks a DeepSeek response that arrived but whose content isn't
+// the JSON shape the prompt asks for, as opposed to a transport failure.
+var ErrLLMSchema = errors.New("llm: response does not match schema")
 
-	// Validate required fields.
+// Schema violation reasons, the llm_schema_errors_total label.
+const (
+	schemaInvalidJSON   = "invalid_json"
+	schemaMissingData   = "missing_extracted_data"
+	schemaInvalidAction = "invalid_action"
+)
+
+// parseContent decodes the model's JSON content and fills in safe defaults.
+// Content that isn't JSON returns a nil response unless a reply can be
+// salvaged from it; a missing extracted_data or unknown action is repaired.
+// Either way the response comes back with ErrLLMSchema so the drift is
+// counted without changing what the customer sees.
+func parseContent(raw string) (*models.LLMResponse, error) {
+	var parsed struct {
+		ReplyToUser   string                `json:"reply_to_user"`
+		ExtractedData *models.ExtractedData `json:"extracted_data"`
+		Action        string                `json:"action"`
+		Flags         []string              `json:"flags"`
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		err = schemaError(schemaInvalidJSON, raw, fmt.Errorf("parse JSON content: %w", err))
+		return salvage(raw), err
+	}
+
+	llmResp := &models.LLMResponse{ReplyToUser: parsed.ReplyToUser, Action: parsed.Action, Flags: cleanFlags(parsed.Flags)}
 	if llmResp.ReplyToUser == "" {
-		llmResp.ReplyToUser = "I'm looking into that, one moment!"
+		llmResp.ReplyToUser = EmptyReply()
+	}
+	var err error
+	if parsed.ExtractedData == nil {
+		err = schemaError(schemaMissingData, raw, errors.New("extracted_data is missing"))
+		llmResp.DataMissing = true
+	} else {
+		llmResp.ExtractedData = *parsed.ExtractedData
 	}
 	if !validAction(llmResp.Action) {
+		err = schemaError(schemaInvalidAction, raw, fmt.Errorf("invalid action %q", llmResp.Action))
 		llmResp.Action = "continue"
 	}
+	return llmResp, err
+}
+
+// cleanFlags trims and lowercases flags, dropping blanks and repeats. Flags
+// are optional, so a response without any isn't a schema violation.
+func cleanFlags(flags []string) []string {
+	var out []string
+	for _, f := range flags {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f != "" && !slices.Contains(out, f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// salvage recovers reply_to_user, and action when valid, from content that
+// isn't valid JSON, typically a response cut off at the token limit. It
+// returns nil when there is no complete reply to send.
+func salvage(raw string) *models.LLMResponse {
+	reply, ok := partialReply(raw)
+	if !ok {
+		return nil
+	}
+	action, _ := partialString(raw, "action")
+	if !validAction(action) {
+		action = "continue"
+	}
+	slog.Warn("llm: salvaged reply from malformed JSON", "action", action, "event", "llm_tolerant_parse")
+	return &models.LLMResponse{ReplyToUser: reply, Action: action, DataMissing: true}
+}
+
+// schemaError counts a schema violation and wraps ErrLLMSchema with detail.
+func schemaError(reason, raw string, detail error) error {
+	metrics.LLMSchemaErrors.WithLabelValues(reason).Inc()
+	slog.Debug("llm: response failed schema validation", "reason", reason, "raw", raw)
+	return fmt.Errorf("%w: %v", ErrLLMSchema, detail)
+}
+
+// postWithRetry sends the request, retrying network errors and 429/5xx
+// responses with exponential backoff and jitter. It never sleeps past the
+// ctx deadline: when the next backoff wouldn't fit, the last result stands.
+// It returns the usage reported by the attempts it retried.
+func postWithRetry(ctx context.Context, url, apiKey string, reqBody []byte) (*http.Response, *models.LLMUsage, error) {
+	tuningMu.RLock()
+	client, attempts, base := httpClient, maxAttempts, baseDelay
+	tuningMu.RUnlock()
+
+	var retried *models.LLMUsage
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, retried, fmt.Errorf("llm: create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			err = fmt.Errorf("llm: http call failed: %w", err)
+		}
+		if !retryable(ctx, resp, err) || attempt >= attempts {
+			return resp, retried, err
+		}
+
+		// A retry the ctx deadline would cut off mid-attempt only swaps the
+		// real error for "context deadline exceeded".
+		delay := backoff(attempt, base)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay+client.Timeout {
+			return resp, retried, err
+		}
+		if resp != nil {
+			retried = addUsage(retried, bodyUsage(resp.Body))
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			slog.WarnContext(ctx, "llm: attempt got retryable status, retrying", "attempt", attempt, "max_attempts", attempts, "status", resp.StatusCode, "delay", delay.String())
+		} else {
+			slog.WarnContext(ctx, "llm: attempt failed, retrying", "attempt", attempt, "max_attempts", attempts, "delay", delay.String(), "err", err)
+		}
 
-	return &llmResp, nil
+		select {
+		case <-ctx.Done():
+			return nil, retried, fmt.Errorf("llm: http call failed: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff returns base·2^(attempt-1) plus up to base of jitter.
+func backoff(attempt int, base time.Duration) time.Duration {
+	d := base << (attempt - 1)
+	if base > 0 {
+		d += time.Duration(rand.Int63n(int64(base)))
+	}
+	return d
+}
+
+// estimateTokens approximates a message's token count at ~4 chars/token.
+func estimateTokens(m models.LLMMessage) int {
+	return (len(m.Content)+3)/4 + 4 // + per-message overhead
+}
+
+// trimToBudget drops the oldest non-system messages until the estimated
+// total fits budget. System messages are always kept, as is the latest
+// conversation turn so the model has something to answer.
+func trimToBudget(msgs []models.LLMMessage, budget int) []models.LLMMessage {
+	if budget <= 0 {
+		return msgs
+	}
+	total := 0
+	for _, m := range msgs {
+		total += estimateTokens(m)
+	}
+	if total <= budget {
+		return msgs
+	}
+
+	last := len(msgs) - 1
+	for last > 0 && msgs[last].Role == "system" {
+		last--
+	}
+	drop := make([]bool, len(msgs))
+	dropped := 0
+	for i := 0; i < last && total > budget; i++ {
+		if msgs[i].Role == "system" {
+			continue
+		}
+		drop[i] = true
+		dropped++
+		total -= estimateTokens(msgs[i])
+	}
+
+	kept := make([]models.LLMMessage, 0, len(msgs)-dropped)
+	for i, m := range msgs {
+		if !drop[i] {
+			kept = append(kept, m)
+		}
+	}
+	slog.Info("llm: trimmed oldest messages to fit token budget", "dropped", dropped, "budget", budget)
+	return kept
 }
 
 func validAction(a string) bool {
-	return a == "continue" || a == "handoff" || a == "schedule"
+	return slices.Contains(actions, a)
 }
 
+// Built-in fallback texts, used unless the prompt YAML overrides them.
+const (
+	defaultTechnicalIssue = "Sorry, I ran into a technical issue. Our team will follow up with you shortly."
+	defaultEmptyReply     = "I'm looking into that, one moment!"
+)
+
 // fallback returns a safe default response used when the LLM call fails entirely.
 func fallback() *models.LLMResponse {
 	return &models.LLMResponse{
-		ReplyToUser: "Sorry, I ran into a technical issue. Our team will follow up with you shortly.",
+		ReplyToUser: cmp.Or(loadedFallbacks().TechnicalIssue, defaultTechnicalIssue),
 		Action:      "continue",
+		DataMissing: true,
 	}
 }
 
@@ -113,3 +478,42 @@ func fallback() *models.LLMResponse {
 func SetBaseURL(url string) {
 	deepSeekURL = url
 }
+
+// SetRetryPolicy sets the maximum attempts per call and the base backoff delay.
+func SetRetryPolicy(attempts int, delay time.Duration) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	tuningMu.Lock()
+	defer tuningMu.Unlock()
+	maxAttempts = attempts
+	baseDelay = delay
+}
+
+// ResetBreaker closes the circuit breaker and clears its failure count, so
+// one test's outage doesn't short-circuit the next.
+func ResetBreaker() {
+	breaker.reset()
+}
+
+// SetTimeout sets the HTTP timeout per attempt, from LLM_TIMEOUT.
+func SetTimeout(d time.Duration) {
+	tuningMu.Lock()
+	defer tuningMu.Unlock()
+	httpClient = &http.Client{Timeout: d}
+}
+
+// SetDedupe turns sharing of concurrent identical calls on or off, from
+// LLM_DEDUPE.
+func SetDedupe(on bool) {
+	tuningMu.Lock()
+	defer tuningMu.Unlock()
+	dedupe = on
+}
+
+// SetTokenBudget sets the rough prompt token budget (0 = unlimited).
+func SetTokenBudget(tokens int) {
+	tuningMu.Lock()
+	defer tuningMu.Unlock()
+	maxPromptTokens = tokens
+}
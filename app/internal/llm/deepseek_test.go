@@ -0,0 +1,493 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"clearoutspaces/internal/metrics"
+	"clearoutspaces/internal/models"
+)
+
+const validContent = `{"reply_to_user":"What's the address?","extracted_data":{"address":"unknown","elevator_access":"unknown","stairs":"unknown","inventory":"1 couch"},"action":"continue"}`
+
+// withServer points deepSeekURL at h for the duration of the test.
+func withServer(t *testing.T, h http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	prev := deepSeekURL
+	deepSeekURL = srv.URL
+	breaker.reset()
+	t.Cleanup(func() { deepSeekURL = prev; breaker.reset() })
+}
+
+// withRetryPolicy shrinks the retry policy for the duration of the test.
+func withRetryPolicy(t *testing.T, attempts int, delay time.Duration) {
+	t.Helper()
+	prevAttempts, prevDelay := maxAttempts, baseDelay
+	SetRetryPolicy(attempts, delay)
+	t.Cleanup(func() { maxAttempts, baseDelay = prevAttempts, prevDelay })
+}
+
+func okBody(content string) string {
+	b, _ := json.Marshal(map[string]any{
+		"choices": []any{map[string]any{"message": map[string]string{"content": content}}},
+	})
+	return string(b)
+}
+
+func history() []models.Message {
+	return []models.Message{{Role: "user", Content: "I need a couch removed."}}
+}
+
+func TestCall_RetriesTransientFailures(t *testing.T) {
+	withRetryPolicy(t, 3, time.Millisecond)
+	var hits int32
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(okBody(validContent)))
+	})
+
+	resp, _, err := Call(context.Background(), "key", "", history())
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if resp.ReplyToUser != "What's the address?" || resp.ExtractedData.Inventory != "1 couch" {
+		t.Errorf("unexpected parsed response: %+v", resp)
+	}
+	if hits != 3 {
+		t.Errorf("expected 3 attempts, got %d", hits)
+	}
+}
+
+func TestCall_DoesNotRetryClientErrors(t *testing.T) {
+	withRetryPolicy(t, 3, time.Millisecond)
+	var hits int32
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	resp, _, err := Call(context.Background(), "key", "", history())
+	if err == nil {
+		t.Fatal("expected error for 401")
+	}
+	if resp == nil || resp.ReplyToUser == "" {
+		t.Error("expected fallback response on error")
+	}
+	if hits != 1 {
+		t.Errorf("expected a single attempt for 401, got %d", hits)
+	}
+}
+
+func TestCall_GivesUpAfterMaxAttempts(t *testing.T) {
+	withRetryPolicy(t, 2, time.Millisecond)
+	var hits int32
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	if _, _, err := Call(context.Background(), "key", "", history()); err == nil {
+		t.Fatal("expected error once attempts are exhausted")
+	}
+	if hits != 2 {
+		t.Errorf("expected 2 attempts, got %d", hits)
+	}
+}
+
+func TestCall_RespectsContextDeadline(t *testing.T) {
+	withRetryPolicy(t, 5, time.Second)
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := Call(ctx, "key", "", history()); err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("retry loop overran the context deadline: %s", elapsed)
+	}
+}
+
+func TestCall_SlowServerTimesOutWithinBound(t *testing.T) {
+	withRetryPolicy(t, 3, time.Millisecond)
+	prev := httpClient
+	SetTimeout(50 * time.Millisecond)
+	t.Cleanup(func() { httpClient = prev })
+	release := make(chan struct{})
+	var hits int32
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	t.Cleanup(func() { close(release) })
+
+	if got := CallTimeout(); got != 50*time.Millisecond+callTimeoutMargin {
+		t.Errorf("CallTimeout = %s, want the client timeout plus margin", got)
+	}
+	// Like the real margin, what's left after one timed-out attempt is too
+	// short for another.
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp, _, err := Call(ctx, "key", "", history())
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the call to give up near the 50ms timeout, took %s", elapsed)
+	}
+	var netErr interface{ Timeout() bool }
+	if !errors.As(err, &netErr) || !netErr.Timeout() || ctx.Err() != nil {
+		t.Errorf("expected a client timeout before the ctx deadline, got %v (ctx: %v)", err, ctx.Err())
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("expected no retry the deadline would cut off, got %d attempts", n)
+	}
+	if resp == nil || !reflect.DeepEqual(*resp, *fallback()) {
+		t.Errorf("expected the fallback response, got %+v", resp)
+	}
+}
+
+func TestCall_TrimsHistoryToTokenBudget(t *testing.T) {
+	const budget = 2000
+	prev := maxPromptTokens
+	SetTokenBudget(budget)
+	t.Cleanup(func() { maxPromptTokens = prev })
+	SetSystemPromptForTest("You are a test assistant.")
+
+	var got []models.LLMMessage
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req deepSeekRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		got = req.Messages
+		w.Write([]byte(okBody(validContent)))
+	})
+
+	var long []models.Message
+	for i := 0; i < 50; i++ {
+		long = append(long, models.Message{Role: "user", Content: strings.Repeat("couch ", 100)})
+	}
+	long[49].Content = "latest message"
+
+	if _, _, err := Call(context.Background(), "key", "", long); err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+
+	total := 0
+	for _, m := range got {
+		total += estimateTokens(m)
+	}
+	if total > budget {
+		t.Errorf("expected request within ~%d tokens, got %d", budget, total)
+	}
+	if len(got) < 2 || len(got) > 50 {
+		t.Fatalf("expected history to be trimmed, got %d messages", len(got))
+	}
+	if got[0].Role != "system" || got[0].Content != "You are a test assistant." {
+		t.Errorf("expected system prompt first, got %+v", got[0])
+	}
+	if got[len(got)-1].Content != "latest message" {
+		t.Errorf("expected latest message kept, got %q", got[len(got)-1].Content)
+	}
+}
+
+func TestCall_ReturnsRawContentOnParseFailure(t *testing.T) {
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(okBody(`{"reply_to_user": "Hi`)))
+	})
+
+	resp, raw, err := Call(context.Background(), "key", "", history())
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+	if raw != `{"reply_to_user": "Hi` {
+		t.Errorf("expected raw content returned, got %q", raw)
+	}
+	if resp == nil || resp.ReplyToUser == "" {
+		t.Errorf("expected fallback response, got %+v", resp)
+	}
+}
+
+func TestCall_SchemaViolations(t *testing.T) {
+	cases := []struct {
+		name      string
+		content   string
+		reason    string
+		wantReply string
+	}{
+		{"invalid json", `{"reply_to_user": "Hi`, schemaInvalidJSON, fallback().ReplyToUser},
+		{"missing extracted_data", `{"reply_to_user":"Which floor?","action":"continue"}`, schemaMissingData, "Which floor?"},
+		{"invalid action", `{"reply_to_user":"On it","extracted_data":{},"action":"escalate"}`, schemaInvalidAction, "On it"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(okBody(tc.content)))
+			})
+			before := testutil.ToFloat64(metrics.LLMSchemaErrors.WithLabelValues(tc.reason))
+
+			resp, _, err := Call(context.Background(), "key", "", history())
+			if !errors.Is(err, ErrLLMSchema) {
+				t.Fatalf("expected ErrLLMSchema, got %v", err)
+			}
+			if resp.ReplyToUser != tc.wantReply || resp.Action != "continue" {
+				t.Errorf("unexpected response: %+v", resp)
+			}
+			if got := testutil.ToFloat64(metrics.LLMSchemaErrors.WithLabelValues(tc.reason)) - before; got != 1 {
+				t.Errorf("expected 1 %s schema error counted, got %v", tc.reason, got)
+			}
+		})
+	}
+}
+
+func TestCall_SalvagesTruncatedJSON(t *testing.T) {
+	cases := []struct{ name, content, reply, action string }{
+		{"cut in extracted_data", `{"reply_to_user":"Great, we'll be there \"Saturday\"!","action":"schedule","extracted_data":{"address":"1 Ma`, `Great, we'll be there "Saturday"!`, "schedule"},
+		{"cut before action", `{"reply_to_user":"Which floor?","extracted_data":{"address":"unknown"`, "Which floor?", "continue"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(okBody(tc.content)))
+			})
+			resp, raw, err := Call(context.Background(), "key", "", history())
+			if !errors.Is(err, ErrLLMSchema) || raw != tc.content {
+				t.Fatalf("expected ErrLLMSchema with the raw content, got %v, %q", err, raw)
+			}
+			if resp.ReplyToUser != tc.reply || resp.Action != tc.action || !resp.DataMissing {
+				t.Errorf("expected the salvaged reply and action, marked as missing data, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestCall_ReturnsUsage(t *testing.T) {
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := json.Marshal(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]string{"content": validContent}}},
+			"usage":   map[string]int{"prompt_tokens": 812, "completion_tokens": 64, "total_tokens": 876},
+		})
+		w.Write(b)
+	})
+	resp, _, err := Call(context.Background(), "key", "", history())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 812 || resp.Usage.CompletionTokens != 64 {
+		t.Errorf("expected usage 812/64, got %+v", resp.Usage)
+	}
+
+	// Providers that don't report usage leave it nil.
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(okBody(validContent)))
+	})
+	if resp, _, _ := Call(context.Background(), "key", "", history()); resp.Usage != nil {
+		t.Errorf("expected nil usage, got %+v", resp.Usage)
+	}
+}
+
+func TestCall_ReportsUsageOfRetriedAndFailedAttempts(t *testing.T) {
+	withRetryPolicy(t, 2, time.Millisecond)
+	var hits int32
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"error":"overloaded","usage":{"prompt_tokens":100,"completion_tokens":5}}`))
+		atomic.AddInt32(&hits, 1)
+	})
+
+	resp, _, err := Call(context.Background(), "key", "", history())
+	if err == nil {
+		t.Fatal("expected an error after the last attempt failed")
+	}
+	if hits != 2 || resp.Usage == nil || resp.Usage.PromptTokens != 200 || resp.Usage.CompletionTokens != 10 {
+		t.Errorf("expected usage summed over %d attempts, got %+v", hits, resp.Usage)
+	}
+}
+
+func TestCall_TransportErrorIsNotSchemaError(t *testing.T) {
+	withRetryPolicy(t, 1, 0)
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	_, _, err := Call(context.Background(), "key", "", history())
+	if err == nil || errors.Is(err, ErrLLMSchema) {
+		t.Errorf("expected a non-schema error, got %v", err)
+	}
+}
+
+func TestCall_JSONSchemaResponseFormat(t *testing.T) {
+	var formats []map[string]any
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResponseFormat map[string]any `json:"response_format"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		formats = append(formats, req.ResponseFormat)
+		w.Write([]byte(okBody(validContent)))
+	})
+	t.Cleanup(func() { SetJSONSchema(false) })
+
+	Call(context.Background(), "key", "", history())
+	SetJSONSchema(true)
+	Call(context.Background(), "key", "", history())
+
+	if len(formats) != 2 || formats[0]["type"] != "json_object" {
+		t.Fatalf("expected plain json_object by default, got %v", formats)
+	}
+	if formats[1]["type"] != "json_schema" {
+		t.Fatalf("expected json_schema when enabled, got %v", formats[1])
+	}
+	// Round-trip through JSON to compare the schema as the provider sees it.
+	raw, _ := json.Marshal(formats[1]["json_schema"])
+	var got struct {
+		Strict bool `json:"strict"`
+		Schema struct {
+			Required   []string `json:"required"`
+			Properties struct {
+				Action        struct{ Enum []string } `json:"action"`
+				ExtractedData struct {
+					Required []string `json:"required"`
+				} `json:"extracted_data"`
+			} `json:"properties"`
+		} `json:"schema"`
+	}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("decode schema: %v", err)
+	}
+	if !got.Strict || strings.Join(got.Schema.Required, ",") != "reply_to_user,extracted_data,action,flags" {
+		t.Errorf("unexpected top-level schema: %s", raw)
+	}
+	if strings.Join(got.Schema.Properties.Action.Enum, ",") != "continue,handoff,schedule" {
+		t.Errorf("expected the action enum, got %v", got.Schema.Properties.Action.Enum)
+	}
+	if strings.Join(got.Schema.Properties.ExtractedData.Required, ",") != "address,elevator_access,stairs,inventory" {
+		t.Errorf("expected every quote field required, got %v", got.Schema.Properties.ExtractedData.Required)
+	}
+}
+
+func TestCall_SharedCallReportsUsageOnce(t *testing.T) {
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		b, _ := json.Marshal(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]string{"content": validContent}}},
+			"usage":   map[string]int{"prompt_tokens": 100, "completion_tokens": 10},
+		})
+		w.Write(b)
+	})
+
+	results := make(chan *models.LLMResponse, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, _, _ := Call(context.Background(), "key", "", history())
+			results <- resp
+		}()
+		if i == 0 {
+			<-arrived
+		}
+	}
+	time.Sleep(50 * time.Millisecond) // let the second caller join
+	close(release)
+
+	withUsage := 0
+	for i := 0; i < 2; i++ {
+		if (<-results).Usage != nil {
+			withUsage++
+		}
+	}
+	if withUsage != 1 {
+		t.Errorf("expected usage on exactly one of the shared results, got %d", withUsage)
+	}
+}
+
+func TestCall_SharesConcurrentIdenticalCalls(t *testing.T) {
+	var requests atomic.Int32
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		arrived <- struct{}{}
+		<-release
+		b, _ := json.Marshal(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]string{"content": validContent}}},
+			"usage":   map[string]int{"prompt_tokens": 100, "completion_tokens": 10},
+		})
+		w.Write(b)
+	})
+
+	// The first caller gives up mid-call; that mustn't fail the second.
+	canceled, cancel := context.WithCancel(context.Background())
+	type result struct {
+		resp *models.LLMResponse
+		err  error
+	}
+	results := make(chan result, 2)
+	go func() {
+		resp, _, err := Call(canceled, "key", "", history())
+		results <- result{resp, err}
+	}()
+	<-arrived
+	go func() {
+		resp, _, err := Call(context.Background(), "key", "", history())
+		results <- result{resp, err}
+	}()
+	// Let the second caller join the in-flight call before it completes.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if r := <-results; !errors.Is(r.err, context.Canceled) {
+		t.Errorf("expected the canceled caller to return its ctx error, got %v", r.err)
+	}
+	close(release)
+
+	r := <-results
+	if r.err != nil || r.resp.ReplyToUser != "What's the address?" {
+		t.Fatalf("expected the second caller to get the shared response, got %+v, %v", r.resp, r.err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 upstream request for 2 identical calls, got %d", n)
+	}
+	// The canceled caller started the call but never got it, so the usage
+	// goes to the one that did.
+	if r.resp.Usage == nil || r.resp.Usage.PromptTokens != 100 {
+		t.Errorf("expected the usage reported by the caller that got the result, got %+v", r.resp.Usage)
+	}
+
+	// With dedupe off each caller makes its own request.
+	SetDedupe(false)
+	t.Cleanup(func() { SetDedupe(true) })
+	requests.Store(0)
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			Call(context.Background(), "key", "", history())
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 upstream requests with dedupe off, got %d", n)
+	}
+}
@@ -1,34 +1,173 @@
 package llm
 
 import (
+	"cmp"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v3"
+
+	"clearoutspaces/internal/logging"
 )
 
 type systemPromptYAML struct {
-	Identity      string   `yaml:"identity"`
-	BusinessRules []string `yaml:"business_rules"`
-	QuoteFields   []string `yaml:"quote_fields_needed"`
-	Workflow      string   `yaml:"workflow"`
+	Identity      string        `yaml:"identity"`
+	BusinessRules []string      `yaml:"business_rules"`
+	QuoteFields   []string      `yaml:"quote_fields_needed"`
+	Workflow      string        `yaml:"workflow"`
+	Fallbacks     fallbackTexts `yaml:"fallbacks"`
+}
+
+// fallbackTexts are the customer-facing texts used when the model gives no
+// usable reply, from the prompt YAML's optional fallbacks section. Empty
+// fields keep the built-in English defaults.
+type fallbackTexts struct {
+	// TechnicalIssue replaces a reply when the LLM call fails entirely.
+	TechnicalIssue string `yaml:"technical_issue"`
+	// EmptyReply stands in for a response with an empty reply_to_user.
+	EmptyReply string `yaml:"empty_reply"`
 }
 
-var compiledSystemPrompt string
+// promptSet is everything compiled from the prompt file or directory.
+type promptSet struct {
+	prompts   map[string]string // variant name → compiled prompt
+	identity  string            // the first variant's identity line
+	fallbacks fallbackTexts
+}
+
+// DefaultVariant names the prompt loaded by LoadPrompt from a single file.
+const DefaultVariant = "default"
+
+// VariantStore pins conversations to a prompt variant so they keep theirs
+// when variants are added or removed. *database.DB implements it.
+type VariantStore interface {
+	PromptVariant(phone string) (string, error)
+	SetPromptVariant(phone, variant string) error
+}
 
-// LoadPrompt reads and compiles the YAML prompt template at startup.
-// Call once from main(); panics on failure so bad config surfaces immediately.
+var (
+	promptMu    sync.RWMutex
+	promptPath  string            // file or variants directory given to Load*
+	promptDir   bool              // promptPath is a variants directory
+	prompts     map[string]string // variant name → compiled prompt
+	variants    []string          // sorted variant names, for bucketing
+	fallbacks   fallbackTexts
+	promptStore VariantStore
+)
+
+// LoadPrompt reads and compiles the YAML prompt template at startup as the
+// only variant, DefaultVariant. Call once from main(); panics on failure so
+// bad config surfaces immediately.
 func LoadPrompt(path string) {
+	set, err := compilePrompts(path, false)
+	if err != nil {
+		logging.Fatal("llm: load system prompt", "err", err)
+	}
+	setPrompts(path, false, set)
+	slog.Info("llm: system prompt loaded", "path", path)
+}
+
+// LoadPromptVariants compiles every *.yaml in dir as a prompt variant named
+// after its file, e.g. "concise.yaml" is variant "concise". Each phone is
+// bucketed into one variant by hash; see SystemPromptFor.
+func LoadPromptVariants(dir string) {
+	set, err := compilePrompts(dir, true)
+	if err != nil {
+		logging.Fatal("llm: load prompt variants", "err", err)
+	}
+	setPrompts(dir, true, set)
+	slog.Info("llm: prompt variants loaded", "dir", dir, "variants", Variants())
+}
+
+// ReloadPrompt re-reads what LoadPrompt or LoadPromptVariants loaded and
+// swaps in the new prompts, returning the first variant's identity line.
+// On error the current prompts stay.
+func ReloadPrompt() (string, error) {
+	promptMu.RLock()
+	path, dir := promptPath, promptDir
+	promptMu.RUnlock()
+	if path == "" {
+		return "", fmt.Errorf("no system prompt file loaded")
+	}
+
+	set, err := compilePrompts(path, dir)
+	if err != nil {
+		return "", err
+	}
+	setPrompts(path, dir, set)
+
+	slog.Info("llm: system prompt reloaded", "path", path)
+	return set.identity, nil
+}
+
+// compilePrompts compiles the prompt file at path, or each *.yaml in it when
+// dir is set. In a directory the first variant's fallbacks apply to all.
+func compilePrompts(path string, dir bool) (*promptSet, error) {
+	files := []string{path}
+	if dir {
+		var err error
+		if files, err = filepath.Glob(filepath.Join(path, "*.yaml")); err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no *.yaml prompt variants in %s", path)
+		}
+		sort.Strings(files)
+	}
+
+	set := &promptSet{prompts: make(map[string]string, len(files))}
+	for i, f := range files {
+		prompt, identity, fb, err := compilePrompt(f)
+		if err != nil {
+			if dir {
+				err = fmt.Errorf("variant %s: %w", filepath.Base(f), err)
+			}
+			return nil, err
+		}
+		name := DefaultVariant
+		if dir {
+			name = strings.TrimSuffix(filepath.Base(f), ".yaml")
+		}
+		set.prompts[name] = prompt
+		if i == 0 {
+			set.identity, set.fallbacks = identity, fb
+		}
+	}
+	return set, nil
+}
+
+func setPrompts(path string, dir bool, set *promptSet) {
+	names := make([]string, 0, len(set.prompts))
+	for name := range set.prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	promptPath, promptDir, prompts, variants, fallbacks = path, dir, set.prompts, names, set.fallbacks
+}
+
+// compilePrompt reads the YAML template at path and returns the compiled
+// prompt, its identity line and its fallback texts.
+func compilePrompt(path string) (prompt, identity string, fb fallbackTexts, err error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("llm: failed to read system prompt: %v", err)
+		return "", "", fb, fmt.Errorf("failed to read system prompt: %w", err)
 	}
 
 	var p systemPromptYAML
 	if err := yaml.Unmarshal(data, &p); err != nil {
-		log.Fatalf("llm: failed to parse system prompt YAML: %v", err)
+		return "", "", fb, fmt.Errorf("failed to parse system prompt YAML: %w", err)
+	}
+	if strings.TrimSpace(p.Identity) == "" {
+		return "", "", fb, fmt.Errorf("system prompt YAML has no identity")
 	}
 
 	rules := make([]string, len(p.BusinessRules))
@@ -36,7 +175,7 @@ func LoadPrompt(path string) {
 		rules[i] = fmt.Sprintf("- %s", r)
 	}
 
-	compiledSystemPrompt = strings.TrimSpace(fmt.Sprintf(`
+	prompt = strings.TrimSpace(fmt.Sprintf(`
 %s
 
 Business Rules:
@@ -55,7 +194,8 @@ You MUST respond ONLY with a valid JSON object matching this exact schema — no
     "stairs": "<string or 'unknown'>",
     "inventory": "<string or 'unknown'>"
   },
-  "action": "<one of: continue | handoff | schedule>"
+  "action": "<one of: continue | handoff | schedule>",
+  "flags": ["<snake_case name of each business rule the request breaks, e.g. hazardous_material or outside_service_area; [] if none>"]
 }
 `,
 		p.Identity,
@@ -63,16 +203,79 @@ You MUST respond ONLY with a valid JSON object matching this exact schema — no
 		strings.Join(p.QuoteFields, ", "),
 		p.Workflow,
 	))
+	fb = fallbackTexts{
+		TechnicalIssue: strings.TrimSpace(p.Fallbacks.TechnicalIssue),
+		EmptyReply:     strings.TrimSpace(p.Fallbacks.EmptyReply),
+	}
+	return prompt, strings.TrimSpace(p.Identity), fb, nil
+}
+
+// Variants returns the loaded prompt variant names, sorted.
+func Variants() []string {
+	promptMu.RLock()
+	defer promptMu.RUnlock()
+	return append([]string(nil), variants...)
+}
+
+// SetVariantStore persists variant assignments, so a conversation stays on
+// the prompt it started with.
+func SetVariantStore(store VariantStore) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	promptStore = store
+}
+
+// SystemPromptFor returns the compiled prompt of phone's variant: the one
+// its conversation is pinned to if still loaded, else one picked by hashing
+// the phone, which is then pinned.
+func SystemPromptFor(phone string) string {
+	promptMu.RLock()
+	store, names, loaded := promptStore, variants, prompts
+	promptMu.RUnlock()
+	if len(names) == 0 {
+		return ""
+	}
+	if store == nil || phone == "" {
+		return loaded[bucket(phone, names)]
+	}
+
+	pinned, err := store.PromptVariant(phone)
+	if err != nil {
+		slog.Warn("llm: get prompt variant", "phone", phone, "err", err)
+		return loaded[bucket(phone, names)]
+	}
+	if prompt, ok := loaded[pinned]; ok {
+		return prompt
+	}
+	variant := bucket(phone, names)
+	if err := store.SetPromptVariant(phone, variant); err != nil {
+		slog.Warn("llm: set prompt variant", "phone", phone, "err", err)
+	}
+	return loaded[variant]
+}
+
+// bucket deterministically assigns phone to one of names (sorted).
+func bucket(phone string, names []string) string {
+	h := fnv.New32a()
+	h.Write([]byte(phone))
+	return names[h.Sum32()%uint32(len(names))]
+}
 
-	log.Println("llm: system prompt loaded")
+func loadedFallbacks() fallbackTexts {
+	promptMu.RLock()
+	defer promptMu.RUnlock()
+	return fallbacks
 }
 
-// SystemPrompt returns the compiled prompt string.
-func SystemPrompt() string {
-	return compiledSystemPrompt
+// EmptyReply is the text sent in place of an empty assistant reply.
+func EmptyReply() string {
+	return cmp.Or(loadedFallbacks().EmptyReply, defaultEmptyReply)
 }
 
-// SetSystemPromptForTest overrides the compiled prompt. Only call this from tests.
+// SetSystemPromptForTest overrides the prompts with a single default
+// variant. Only call this from tests.
 func SetSystemPromptForTest(prompt string) {
-	compiledSystemPrompt = prompt
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	prompts, variants = map[string]string{DefaultVariant: prompt}, []string{DefaultVariant}
 }
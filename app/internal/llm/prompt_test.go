@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clearoutspaces/internal/models"
+)
+
+func writePrompt(t *testing.T, path, identity string) {
+	t.Helper()
+	yaml := "identity: \"" + identity + "\"\nbusiness_rules:\n  - \"Be nice.\"\nquote_fields_needed:\n  - address\nworkflow: \"Ask one question.\"\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadPrompt(t *testing.T) {
+	prevPath, prevPrompt := promptPath, SystemPromptFor("")
+	t.Cleanup(func() { promptPath = prevPath; SetSystemPromptForTest(prevPrompt) })
+
+	path := filepath.Join(t.TempDir(), "system_prompt.yaml")
+	writePrompt(t, path, "You are version one.")
+	LoadPrompt(path)
+	if !strings.HasPrefix(SystemPromptFor(""), "You are version one.") {
+		t.Fatalf("unexpected initial prompt: %q", SystemPromptFor(""))
+	}
+
+	writePrompt(t, path, "You are version two.")
+	identity, err := ReloadPrompt()
+	if err != nil {
+		t.Fatalf("ReloadPrompt: %v", err)
+	}
+	if identity != "You are version two." {
+		t.Errorf("expected new identity returned, got %q", identity)
+	}
+	if !strings.HasPrefix(SystemPromptFor(""), "You are version two.") {
+		t.Errorf("expected reloaded prompt, got %q", SystemPromptFor(""))
+	}
+
+	// A broken file is rejected and the loaded prompt is kept.
+	if err := os.WriteFile(path, []byte("identity: [unclosed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReloadPrompt(); err == nil {
+		t.Fatal("expected parse error")
+	}
+	if !strings.HasPrefix(SystemPromptFor(""), "You are version two.") {
+		t.Errorf("expected previous prompt kept after failed reload, got %q", SystemPromptFor(""))
+	}
+}
+
+// memVariantStore is an in-memory VariantStore.
+type memVariantStore map[string]string
+
+func (m memVariantStore) PromptVariant(phone string) (string, error) { return m[phone], nil }
+func (m memVariantStore) SetPromptVariant(phone, v string) error     { m[phone] = v; return nil }
+
+func TestBucket_StableAndSpread(t *testing.T) {
+	names := []string{"a", "b"}
+	seen := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		phone := fmt.Sprintf("1416555%04d", i)
+		v := bucket(phone, names)
+		if again := bucket(phone, names); again != v {
+			t.Fatalf("bucket(%s) not stable: %s then %s", phone, v, again)
+		}
+		seen[v] = true
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Errorf("expected phones spread over both variants, got %v", seen)
+	}
+}
+
+func TestSystemPromptFor_PinsVariant(t *testing.T) {
+	prevPath, prevPrompt := promptPath, SystemPromptFor("")
+	t.Cleanup(func() {
+		promptPath, promptDir = prevPath, false
+		SetSystemPromptForTest(prevPrompt)
+		SetVariantStore(nil)
+	})
+
+	dir := t.TempDir()
+	writePrompt(t, filepath.Join(dir, "control.yaml"), "You are control.")
+	writePrompt(t, filepath.Join(dir, "concise.yaml"), "You are concise.")
+	LoadPromptVariants(dir)
+	if got := Variants(); strings.Join(got, ",") != "concise,control" {
+		t.Fatalf("unexpected variants: %v", got)
+	}
+
+	store := memVariantStore{}
+	SetVariantStore(store)
+	phone := "14165551234"
+	first := SystemPromptFor(phone)
+	want := map[string]string{"concise": "You are concise.", "control": "You are control."}[store[phone]]
+	if store[phone] == "" || !strings.HasPrefix(first, want) {
+		t.Fatalf("expected the variant pinned and its prompt returned, got %q pinned and %q", store[phone], first)
+	}
+
+	// A pinned variant wins over the hash while it is still loaded...
+	other := map[string]string{"concise": "control", "control": "concise"}[store[phone]]
+	store[phone] = other
+	if got := SystemPromptFor(phone); !strings.HasPrefix(got, "You are "+other+".") {
+		t.Errorf("expected the pinned %s prompt, got %q", other, got)
+	}
+	// ...and one that was removed is reassigned.
+	store[phone] = "retired"
+	SystemPromptFor(phone)
+	if store[phone] != "concise" && store[phone] != "control" {
+		t.Errorf("expected a retired variant reassigned, got %q", store[phone])
+	}
+}
+
+func TestLoadPrompt_Fallbacks(t *testing.T) {
+	prevPath, prevPrompt := promptPath, SystemPromptFor("")
+	t.Cleanup(func() {
+		promptPath = prevPath
+		SetSystemPromptForTest(prevPrompt)
+		fallbacks = fallbackTexts{}
+	})
+
+	path := filepath.Join(t.TempDir(), "prompt.yaml")
+	writePrompt(t, path, "You are custom.")
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("fallbacks:\n  technical_issue: \"Oops, give us a minute.\"\n")
+	f.Close()
+	LoadPrompt(path)
+
+	if got := fallback().ReplyToUser; got != "Oops, give us a minute." {
+		t.Errorf("expected the custom fallback, got %q", got)
+	}
+	if got := EmptyReply(); got != defaultEmptyReply {
+		t.Errorf("expected the default for an unset fallback, got %q", got)
+	}
+}
+
+func TestBuildRequest_UsesCallerPhoneVariant(t *testing.T) {
+	prevPath, prevPrompt := promptPath, SystemPromptFor("")
+	t.Cleanup(func() {
+		promptPath, promptDir = prevPath, false
+		SetSystemPromptForTest(prevPrompt)
+		SetVariantStore(nil)
+	})
+
+	dir := t.TempDir()
+	writePrompt(t, filepath.Join(dir, "control.yaml"), "You are control.")
+	writePrompt(t, filepath.Join(dir, "concise.yaml"), "You are concise.")
+	LoadPromptVariants(dir)
+	phone := "14165551234"
+	SetVariantStore(memVariantStore{phone: "concise"})
+
+	// A trailing note (e.g. the language lock) carries no ConversationID.
+	history := []models.Message{
+		{ConversationID: phone, Role: "user", Content: "Hi"},
+		{Role: "system", Content: "Always reply in French."},
+	}
+	body, err := buildRequest("m", phone, history, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req deepSeekRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(req.Messages[0].Content, "You are concise.") {
+		t.Errorf("expected the pinned variant's prompt, got %q", req.Messages[0].Content)
+	}
+}
@@ -0,0 +1,75 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"clearoutspaces/internal/models"
+)
+
+// Provider generates the assistant's next turn from phone's conversation
+// history. Like Call, Complete never returns a nil response and also returns
+// the raw message content so it can be stored for diagnosis.
+type Provider interface {
+	Complete(ctx context.Context, apiKey, phone string, history []models.Message) (*models.LLMResponse, string, error)
+}
+
+// Streamer is a Provider that can also stream its response, reporting the
+// reply early as CallStream does.
+type Streamer interface {
+	Provider
+	CompleteStream(ctx context.Context, apiKey, phone string, history []models.Message, onReply func(reply string)) (*models.LLMResponse, string, error)
+}
+
+// Provider names, the LLM_PROVIDER values.
+const (
+	ProviderDeepSeek = "deepseek"
+	ProviderOpenAI   = "openai"
+)
+
+// DeepSeek is the default provider.
+type DeepSeek struct{}
+
+func (DeepSeek) Complete(ctx context.Context, apiKey, phone string, history []models.Message) (*models.LLMResponse, string, error) {
+	return Call(ctx, apiKey, phone, history)
+}
+
+func (DeepSeek) CompleteStream(ctx context.Context, apiKey, phone string, history []models.Message, onReply func(reply string)) (*models.LLMResponse, string, error) {
+	return CallStream(ctx, apiKey, phone, history, onReply)
+}
+
+// OpenAICompatible talks to any OpenAI-style chat completions API that
+// supports JSON response format, e.g. BaseURL "https://api.openai.com/v1".
+type OpenAICompatible struct {
+	BaseURL string
+	Model   string
+}
+
+func (p OpenAICompatible) endpoint() endpoint {
+	return endpoint{url: strings.TrimRight(p.BaseURL, "/") + "/chat/completions", model: p.Model}
+}
+
+func (p OpenAICompatible) Complete(ctx context.Context, apiKey, phone string, history []models.Message) (*models.LLMResponse, string, error) {
+	return complete(ctx, p.endpoint(), apiKey, phone, history)
+}
+
+func (p OpenAICompatible) CompleteStream(ctx context.Context, apiKey, phone string, history []models.Message, onReply func(reply string)) (*models.LLMResponse, string, error) {
+	return completeStream(ctx, p.endpoint(), apiKey, phone, history, onReply)
+}
+
+// NewProvider returns the provider named by LLM_PROVIDER. baseURL and model
+// only apply to ProviderOpenAI.
+func NewProvider(name, baseURL, model string) (Provider, error) {
+	switch name {
+	case ProviderDeepSeek, "":
+		return DeepSeek{}, nil
+	case ProviderOpenAI:
+		if baseURL == "" || model == "" {
+			return nil, fmt.Errorf("llm: provider %q needs a base URL and model", name)
+		}
+		return OpenAICompatible{BaseURL: baseURL, Model: model}, nil
+	default:
+		return nil, fmt.Errorf("llm: unknown provider %q", name)
+	}
+}
@@ -0,0 +1,56 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewProvider(t *testing.T) {
+	cases := []struct {
+		name    string
+		want    Provider
+		wantErr bool
+	}{
+		{"", DeepSeek{}, false},
+		{ProviderDeepSeek, DeepSeek{}, false},
+		{ProviderOpenAI, OpenAICompatible{BaseURL: "https://api.openai.com/v1", Model: "gpt-4o-mini"}, false},
+		{"anthropic", nil, true},
+	}
+	for _, tc := range cases {
+		got, err := NewProvider(tc.name, "https://api.openai.com/v1", "gpt-4o-mini")
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("NewProvider(%q) = %v, %v; want %v, err=%v", tc.name, got, err, tc.want, tc.wantErr)
+		}
+	}
+	if _, err := NewProvider(ProviderOpenAI, "", ""); err == nil {
+		t.Error("expected an error for openai without base URL and model")
+	}
+}
+
+func TestOpenAICompatible_Complete(t *testing.T) {
+	var gotPath, gotModel, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req deepSeekRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		gotPath, gotModel, gotAuth = r.URL.Path, req.Model, r.Header.Get("Authorization")
+		w.Write([]byte(okBody(`{"reply_to_user":"Hi there","extracted_data":{},"action":"continue"}`)))
+	}))
+	t.Cleanup(srv.Close)
+	breaker.reset()
+	t.Cleanup(breaker.reset)
+
+	p := OpenAICompatible{BaseURL: srv.URL + "/v1/", Model: "gpt-test"}
+	resp, _, err := p.Complete(context.Background(), "sk-test", "", nil)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if resp.ReplyToUser != "Hi there" {
+		t.Errorf("unexpected reply %q", resp.ReplyToUser)
+	}
+	if gotPath != "/v1/chat/completions" || gotModel != "gpt-test" || gotAuth != "Bearer sk-test" {
+		t.Errorf("unexpected request: path=%q model=%q auth=%q", gotPath, gotModel, gotAuth)
+	}
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"reflect"
+	"strings"
+
+	"clearoutspaces/internal/models"
+)
+
+// actions are the values the model may return in "action".
+var actions = []string{"continue", "handoff", "schedule"}
+
+// useJSONSchema sends responseSchema as a json_schema response_format
+// instead of plain json_object; see SetJSONSchema.
+var useJSONSchema = false
+
+// responseSchema is the JSON schema of models.LLMResponse, built once from
+// the struct so it can't drift from what parseContent decodes.
+var responseSchema = func() map[string]any {
+	s := schemaFor(reflect.TypeOf(models.LLMResponse{}))
+	s["properties"].(map[string]any)["action"].(map[string]any)["enum"] = actions
+	return s
+}()
+
+// schemaFor describes t's JSON encoding. Only the kinds LLMResponse uses
+// are supported: structs, slices and strings. Every field is required and
+// no others are allowed, as strict structured output demands.
+func schemaFor(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Struct:
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	default:
+		return map[string]any{"type": "string"}
+	}
+	props := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		props[name] = schemaFor(f.Type)
+		required = append(required, name)
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// responseFormat is the request's response_format.
+func responseFormat() map[string]any {
+	tuningMu.RLock()
+	enabled := useJSONSchema
+	tuningMu.RUnlock()
+	if !enabled {
+		return map[string]any{"type": "json_object"}
+	}
+	return map[string]any{
+		"type": "json_schema",
+		"json_schema": map[string]any{
+			"name":   "llm_response",
+			"strict": true,
+			"schema": responseSchema,
+		},
+	}
+}
+
+// SetJSONSchema turns the schema-constrained response_format on or off,
+// from LLM_JSON_SCHEMA.
+func SetJSONSchema(enabled bool) {
+	tuningMu.Lock()
+	defer tuningMu.Unlock()
+	useJSONSchema = enabled
+}
@@ -0,0 +1,149 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"clearoutspaces/internal/metrics"
+	"clearoutspaces/internal/models"
+)
+
+type deepSeekStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *models.LLMUsage `json:"usage"` // on the final chunk, when reported
+}
+
+// CallStream is Call over DeepSeek's streaming API. onReply, if non-nil, is
+// invoked once as soon as the reply_to_user string is complete in the stream,
+// before extracted_data and action have arrived, so the reply can be sent
+// early. The final result is validated exactly like Call's; a malformed chunk
+// or truncated stream returns fallback() with an error.
+func CallStream(ctx context.Context, apiKey, phone string, history []models.Message, onReply func(reply string)) (*models.LLMResponse, string, error) {
+	return completeStream(ctx, deepSeek(), apiKey, phone, history, onReply)
+}
+
+func completeStream(ctx context.Context, ep endpoint, apiKey, phone string, history []models.Message, onReply func(reply string)) (*models.LLMResponse, string, error) {
+	start := time.Now()
+	resp, raw, err := callStream(ctx, ep, apiKey, phone, history, onReply)
+	metrics.ObserveLLMCall(time.Since(start), err)
+	return resp, raw, err
+}
+
+func callStream(ctx context.Context, ep endpoint, apiKey, phone string, history []models.Message, onReply func(reply string)) (*models.LLMResponse, string, error) {
+	reqBody, err := buildRequest(ep.model, phone, history, true)
+	if err != nil {
+		return fallback(), "", err
+	}
+
+	resp, retried, err := post(ctx, ep.url, apiKey, reqBody)
+	if err != nil {
+		return failed(retried), "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return failed(addUsage(retried, bodyUsage(resp.Body))), "", fmt.Errorf("llm: unexpected status %d", resp.StatusCode)
+	}
+
+	var content strings.Builder
+	var usage *models.LLMUsage
+	replied := onReply == nil
+	done := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue // blank separators, comments, keep-alives
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+
+		var chunk deepSeekStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return failed(addUsage(retried, usage)), content.String(), fmt.Errorf("llm: malformed stream chunk: %w", err)
+		}
+		for _, c := range chunk.Choices {
+			content.WriteString(c.Delta.Content)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		if !replied {
+			if reply, ok := partialReply(content.String()); ok {
+				replied = true
+				onReply(reply)
+			}
+		}
+	}
+	raw := content.String()
+	usage = addUsage(retried, usage)
+	if err := scanner.Err(); err != nil {
+		return failed(usage), raw, fmt.Errorf("llm: read stream: %w", err)
+	}
+	if !done {
+		return failed(usage), raw, fmt.Errorf("llm: stream ended without [DONE]")
+	}
+
+	llmResp, err := parseContent(raw)
+	if llmResp == nil {
+		llmResp = fallback()
+	}
+	llmResp.Usage = usage
+	return llmResp, raw, err
+}
+
+// partialReply extracts the reply_to_user value from incomplete JSON once its
+// closing quote has arrived. An empty reply is not reported, since Call would
+// replace it with a default.
+func partialReply(partial string) (string, bool) {
+	reply, ok := partialString(partial, "reply_to_user")
+	return reply, ok && reply != ""
+}
+
+// partialString extracts the string value of key from incomplete or
+// malformed JSON, once its closing quote is present.
+func partialString(partial, key string) (string, bool) {
+	i := strings.Index(partial, `"`+key+`"`)
+	if i < 0 {
+		return "", false
+	}
+	rest := strings.TrimLeft(partial[i+len(key)+2:], " \t\r\n")
+	rest, ok := strings.CutPrefix(rest, ":")
+	if !ok {
+		return "", false
+	}
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	if !strings.HasPrefix(rest, `"`) {
+		return "", false
+	}
+
+	for j := 1; j < len(rest); j++ {
+		switch rest[j] {
+		case '\\':
+			j++ // skip the escaped character
+		case '"':
+			var value string
+			if err := json.Unmarshal([]byte(rest[:j+1]), &value); err != nil {
+				return "", false
+			}
+			return value, true
+		}
+	}
+	return "", false
+}
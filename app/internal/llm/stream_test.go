@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sseBody splits content into n-byte deltas framed as DeepSeek SSE chunks.
+func sseBody(content string, n int) string {
+	var b strings.Builder
+	for len(content) > 0 {
+		k := min(n, len(content))
+		chunk, _ := json.Marshal(map[string]any{
+			"choices": []any{map[string]any{"delta": map[string]string{"content": content[:k]}}},
+		})
+		fmt.Fprintf(&b, "data: %s\n\n", chunk)
+		content = content[k:]
+	}
+	b.WriteString("data: [DONE]\n\n")
+	return b.String()
+}
+
+func TestCallStream_ReportsReplyBeforeStreamEnds(t *testing.T) {
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"stream":true,"stream_options":{"include_usage":true}`) {
+			t.Errorf("expected a streamed request asking for usage, got %s", body)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(sseBody(validContent, 7)))
+	})
+
+	var replies []string
+	resp, raw, err := CallStream(context.Background(), "key", "", history(), func(reply string) {
+		replies = append(replies, reply)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replies) != 1 || replies[0] != "What's the address?" {
+		t.Errorf("expected one early reply, got %q", replies)
+	}
+	if raw != validContent {
+		t.Errorf("expected accumulated raw content, got %q", raw)
+	}
+	if resp.Action != "continue" || resp.ExtractedData.Inventory != "1 couch" {
+		t.Errorf("unexpected final response: %+v", resp)
+	}
+}
+
+func TestCallStream_FallsBackOnMalformedChunk(t *testing.T) {
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: {\"choices\": [\n\ndata: [DONE]\n\n"))
+	})
+
+	resp, _, err := CallStream(context.Background(), "key", "", history(), nil)
+	if err == nil {
+		t.Fatal("expected malformed chunk error")
+	}
+	if !reflect.DeepEqual(*resp, *fallback()) {
+		t.Errorf("expected fallback response, got %+v", resp)
+	}
+}
+
+func TestPartialReply(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{`{"reply_to_user": "Hel`, "", false},
+		{`{"reply_to_user": "Say \"hi`, "", false},
+		{`{"reply_to_user": "Say \"hi\"", "act`, `Say "hi"`, true},
+		{`{"reply_to_user":"",`, "", false},
+	}
+	for _, c := range cases {
+		got, ok := partialReply(c.in)
+		if got != c.want || ok != c.ok {
+			t.Errorf("partialReply(%q) = %q, %v; want %q, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestCallStream_ReportsUsageOnTruncatedStream(t *testing.T) {
+	withServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"{\\\"reply\"}}],\"usage\":{\"prompt_tokens\":300,\"completion_tokens\":2}}\n\n"))
+	})
+
+	resp, _, err := CallStream(context.Background(), "key", "", history(), nil)
+	if err == nil {
+		t.Fatal("expected an error for a stream without [DONE]")
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 300 || resp.Usage.CompletionTokens != 2 {
+		t.Errorf("expected the reported usage kept, got %+v", resp.Usage)
+	}
+}
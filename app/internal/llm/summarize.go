@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"clearoutspaces/internal/metrics"
+	"clearoutspaces/internal/models"
+)
+
+// Summarizer is a Provider that can condense the older part of a long
+// conversation into a short note, so it can stand in for those messages.
+type Summarizer interface {
+	Provider
+	Summarize(ctx context.Context, apiKey, previous string, msgs []models.Message) (string, error)
+}
+
+// summaryPrompt is the system prompt for summary requests. It is separate
+// from the assistant's prompt: the answer is plain text, not the reply JSON.
+const summaryPrompt = "You summarize customer conversations for a junk removal assistant that will continue them. " +
+	"Keep every detail that matters for the quote (address, elevator or stairs, items, dates, preferences) " +
+	"and anything the assistant promised. Reply with plain text only, at most 150 words."
+
+func (DeepSeek) Summarize(ctx context.Context, apiKey, previous string, msgs []models.Message) (string, error) {
+	return summarize(ctx, deepSeek(), apiKey, previous, msgs)
+}
+
+func (p OpenAICompatible) Summarize(ctx context.Context, apiKey, previous string, msgs []models.Message) (string, error) {
+	return summarize(ctx, p.endpoint(), apiKey, previous, msgs)
+}
+
+// summarize asks ep for a summary of msgs, folding in the previous summary
+// when there is one.
+func summarize(ctx context.Context, ep endpoint, apiKey, previous string, msgs []models.Message) (string, error) {
+	start := time.Now()
+	summary, err := callSummary(ctx, ep, apiKey, previous, msgs)
+	metrics.ObserveLLMCall(time.Since(start), err)
+	return summary, err
+}
+
+func callSummary(ctx context.Context, ep endpoint, apiKey, previous string, msgs []models.Message) (string, error) {
+	var b strings.Builder
+	if previous != "" {
+		fmt.Fprintf(&b, "Summary so far:\n%s\n\nLater messages:\n", previous)
+	}
+	for _, m := range msgs {
+		fmt.Fprintf(&b, "%s: %s\n", m.Role, m.Content)
+	}
+	reqBody, err := json.Marshal(deepSeekRequest{
+		Model: ep.model,
+		Messages: []models.LLMMessage{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: b.String()},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("llm: marshal summary request: %w", err)
+	}
+
+	resp, _, err := post(ctx, ep.url, apiKey, reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("llm: unexpected status %d", resp.StatusCode)
+	}
+
+	var dsResp deepSeekResponse
+	if err := json.NewDecoder(resp.Body).Decode(&dsResp); err != nil {
+		return "", fmt.Errorf("llm: decode summary response: %w", err)
+	}
+	if len(dsResp.Choices) == 0 {
+		return "", fmt.Errorf("llm: empty choices")
+	}
+	summary := strings.TrimSpace(dsResp.Choices[0].Message.Content)
+	if summary == "" {
+		return "", fmt.Errorf("llm: empty summary")
+	}
+	return summary, nil
+}
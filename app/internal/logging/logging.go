@@ -0,0 +1,98 @@
+// Package logging configures the process-wide structured logger.
+//
+// Call sites use log/slog directly, keeping the "pkg: message" text as msg and
+// putting variables in fields. Shared keys: phone, wamid, event, err, and
+// trace_id, which the handler adds itself for *Context calls on a ctx
+// carrying WithTraceID.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// level is the default logger's minimum level; see SetLevel.
+var level slog.LevelVar
+
+// Setup installs a JSON handler writing to w at l as the default logger.
+// The standard log package is routed through it as well.
+func Setup(w io.Writer, l slog.Level) {
+	level.Set(l)
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: &level})}))
+}
+
+// SetLevel changes the minimum level of the logger Setup installed, e.g.
+// on a config reload.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// ─── Trace IDs ────────────────────────────────────────────────────────────────
+
+type traceIDKey struct{}
+
+// WithTraceID returns ctx carrying id, which every log record written with
+// that ctx includes as trace_id.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceID returns the id stored by WithTraceID, or "".
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// NewTraceID returns a random 16-hex-digit id.
+func NewTraceID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// contextHandler adds the ctx trace id to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := TraceID(ctx); id != "" {
+		r.AddAttrs(slog.String("trace_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel parses a LOG_LEVEL value: debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", s)
+}
+
+// Fatal logs msg at error level and exits the process.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func withBuffer(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	Setup(&buf, level)
+	return &buf
+}
+
+func TestSetup_JSONWithFields(t *testing.T) {
+	buf := withBuffer(t, slog.LevelInfo)
+
+	slog.Warn("whatsapp: invalid signature", "phone", "14165550000", "event", "invalid_signature", "err", errors.New("bad mac"))
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected one JSON line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"level": "WARN",
+		"msg":   "whatsapp: invalid signature",
+		"phone": "14165550000",
+		"event": "invalid_signature",
+		"err":   "bad mac",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestSetup_RespectsLevelAndRoutesStdLog(t *testing.T) {
+	buf := withBuffer(t, slog.LevelWarn)
+
+	slog.Info("dropped")
+	if buf.Len() != 0 {
+		t.Fatalf("expected info suppressed at warn level, got %q", buf.String())
+	}
+
+	slog.SetLogLoggerLevel(slog.LevelWarn)
+	t.Cleanup(func() { slog.SetLogLoggerLevel(slog.LevelInfo) })
+	log.Print("legacy line")
+	if !strings.Contains(buf.String(), `"msg":"legacy line"`) {
+		t.Errorf("expected std log routed through JSON handler, got %q", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for in, want := range cases {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestSetup_AddsTraceIDFromContext(t *testing.T) {
+	buf := withBuffer(t, slog.LevelInfo)
+
+	ctx := WithTraceID(context.Background(), "abc123")
+	slog.InfoContext(ctx, "whatsapp: traced")
+	slog.Info("whatsapp: untraced")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], `"trace_id":"abc123"`) {
+		t.Errorf("expected trace_id on the ctx line, got %s", lines[0])
+	}
+	if strings.Contains(lines[1], "trace_id") {
+		t.Errorf("expected no trace_id without ctx, got %s", lines[1])
+	}
+	if TraceID(context.Background()) != "" || len(NewTraceID()) != 16 {
+		t.Error("expected empty TraceID without one set and 16-char new ids")
+	}
+}
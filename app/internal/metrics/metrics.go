@@ -0,0 +1,67 @@
+// Package metrics exposes Prometheus instrumentation for the assistant:
+// inbound message volume, DeepSeek call outcomes and latency, and handoffs.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// LLM call outcomes.
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error" // failed or off-schema; see llm_schema_errors_total
+)
+
+var (
+	MessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "messages_received_total",
+		Help: "Inbound WhatsApp messages accepted for processing.",
+	})
+
+	MessagesByType = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inbound_messages_by_type_total",
+		Help: "Inbound WhatsApp messages by type, including unsupported ones.",
+	}, []string{"type"})
+
+	LLMCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llm_calls_total",
+		Help: "DeepSeek calls by outcome.",
+	}, []string{"outcome"})
+
+	LLMSchemaErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llm_schema_errors_total",
+		Help: "DeepSeek responses whose JSON content broke the expected schema, by reason.",
+	}, []string{"reason"})
+
+	LLMCallDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "llm_call_duration_seconds",
+		Help:    "DeepSeek call latency, including retries.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	})
+
+	SlackHandoffs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "slack_handoffs_total",
+		Help: "Conversations handed off to staff via Slack.",
+	})
+)
+
+// ObserveLLMCall records one DeepSeek call; err != nil means it failed or
+// its content broke the schema.
+func ObserveLLMCall(d time.Duration, err error) {
+	outcome := OutcomeOK
+	if err != nil {
+		outcome = OutcomeError
+	}
+	LLMCalls.WithLabelValues(outcome).Inc()
+	LLMCallDuration.Observe(d.Seconds())
+}
+
+// Handler serves the default registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
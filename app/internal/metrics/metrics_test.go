@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveLLMCall_RecordsOutcomeAndDuration(t *testing.T) {
+	ok := testutil.ToFloat64(LLMCalls.WithLabelValues(OutcomeOK))
+	failed := testutil.ToFloat64(LLMCalls.WithLabelValues(OutcomeError))
+
+	ObserveLLMCall(100*time.Millisecond, nil)
+	ObserveLLMCall(2*time.Second, errors.New("llm: unexpected status 500"))
+
+	if got := testutil.ToFloat64(LLMCalls.WithLabelValues(OutcomeOK)) - ok; got != 1 {
+		t.Errorf("expected 1 ok call, got %v", got)
+	}
+	if got := testutil.ToFloat64(LLMCalls.WithLabelValues(OutcomeError)) - failed; got != 1 {
+		t.Errorf("expected 1 error call, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "llm_call_duration_seconds_count 2") {
+		t.Errorf("expected both calls in the duration histogram, got:\n%s", w.Body.String())
+	}
+}
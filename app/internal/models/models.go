@@ -1,8 +1,11 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
-// ─── WhatsApp inbound payload ────────────────────────────────────────────────
+// ─── WhatsApp inbound payload ─────────────────────────────────────────────────
 
 type WAPayload struct {
 	Object string    `json:"object"`
@@ -14,42 +17,239 @@ type WAEntry struct {
 }
 
 type WAChange struct {
+	Field string  `json:"field"` // "messages" for messages and statuses
 	Value WAValue `json:"value"`
 }
 
 type WAValue struct {
+	Metadata WAMetadata  `json:"metadata"`
+	Contacts []WAContact `json:"contacts"`
 	Messages []WAMessage `json:"messages"`
+	Statuses []WAStatus  `json:"statuses"`
+}
+
+// WAStatus is a delivery receipt for a message we sent. Conversation and
+// Pricing say how Meta billed it; they're absent on some updates (e.g. read).
+type WAStatus struct {
+	ID           string                `json:"id"`     // wamid of our outbound message
+	Status       string                `json:"status"` // "sent", "delivered", "read", "failed"
+	Timestamp    string                `json:"timestamp"`
+	RecipientID  string                `json:"recipient_id"`
+	Conversation *WAStatusConversation `json:"conversation,omitempty"`
+	Pricing      *WAPricing            `json:"pricing,omitempty"`
+	Errors       []WAError             `json:"errors,omitempty"` // set when status is "failed"
+}
+
+// WAError is why Meta couldn't deliver a message, e.g. code 131047 when the
+// 24h customer service window has closed.
+type WAError struct {
+	Code    int    `json:"code"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+type WAStatusConversation struct {
+	ID string `json:"id"`
+}
+
+type WAPricing struct {
+	Billable     bool   `json:"billable"`
+	PricingModel string `json:"pricing_model"`
+	Category     string `json:"category"` // "service", "utility", "marketing", "authentication"
+}
+
+// WAContact identifies a message sender; Profile.Name is the display name
+// the customer set in WhatsApp.
+type WAContact struct {
+	Profile WAProfile `json:"profile"`
+	WaID    string    `json:"wa_id"`
+}
+
+type WAProfile struct {
+	Name string `json:"name"`
+}
+
+type WAMetadata struct {
+	DisplayPhoneNumber string `json:"display_phone_number"`
+	PhoneNumberID      string `json:"phone_number_id"` // our business number that received the message
 }
 
 type WAMessage struct {
-	From string  `json:"from"` // phone number, used as conversation ID
-	ID   string  `json:"id"`   // wamid — used for idempotency
-	Type string  `json:"type"` // "text", "image", etc.
-	Text *WAText `json:"text,omitempty"`
+	From        string         `json:"from"`      // phone number, used as conversation ID
+	ID          string         `json:"id"`        // wamid — used for idempotency
+	Timestamp   string         `json:"timestamp"` // unix seconds, as a string
+	Type        string         `json:"type"`      // "text", "interactive", "image", "reaction", etc.
+	Text        *WAText        `json:"text,omitempty"`
+	Interactive *WAInteractive `json:"interactive,omitempty"`
+	Image       *WAImage       `json:"image,omitempty"`
+	Document    *WADocument    `json:"document,omitempty"`
+	Context     *WAContext     `json:"context,omitempty"`
+	Reaction    *WAReaction    `json:"reaction,omitempty"`
+}
+
+// WAReaction is an emoji reaction to an earlier message. An empty Emoji
+// means the customer removed their reaction.
+type WAReaction struct {
+	MessageID string `json:"message_id"` // wamid of the reacted-to message
+	Emoji     string `json:"emoji"`
+}
+
+// WAContext is set when the customer replies to (quotes) an earlier message,
+// or forwards one, in which case ID and From are empty.
+type WAContext struct {
+	ID                  string `json:"id"`   // wamid of the quoted message
+	From                string `json:"from"` // who sent the quoted message
+	Forwarded           bool   `json:"forwarded"`
+	FrequentlyForwarded bool   `json:"frequently_forwarded"` // forwarded through a long chain
+}
+
+// Message.Forwarded values.
+const (
+	Forwarded           = "forwarded"
+	FrequentlyForwarded = "frequently_forwarded"
+)
+
+// ForwardedFlag returns FrequentlyForwarded or Forwarded when Meta marked
+// the message as forwarded, and "" otherwise.
+func (m *WAMessage) ForwardedFlag() string {
+	switch {
+	case m.Context == nil:
+		return ""
+	case m.Context.FrequentlyForwarded:
+		return FrequentlyForwarded
+	case m.Context.Forwarded:
+		return Forwarded
+	}
+	return ""
 }
 
 type WAText struct {
 	Body string `json:"body"`
 }
 
-// ─── Database models ─────────────────────────────────────────────────────────
+// WAImage and WADocument reference media hosted by Meta; the ID resolves to
+// a short-lived download URL via the Graph API.
+type WAImage struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mime_type"`
+	Caption  string `json:"caption"`
+}
+
+type WADocument struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mime_type"`
+	Filename string `json:"filename"`
+	Caption  string `json:"caption"`
+}
+
+// WAInteractive is a customer's tap on a quick-reply button or list row.
+type WAInteractive struct {
+	Type        string         `json:"type"` // "button_reply" | "list_reply"
+	ButtonReply *WAReplyOption `json:"button_reply,omitempty"`
+	ListReply   *WAReplyOption `json:"list_reply,omitempty"`
+}
+
+type WAReplyOption struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"` // list rows only
+}
+
+// ─── Database models ──────────────────────────────────────────────────────────
 
 type Conversation struct {
-	ID        string    `db:"id"`
-	Status    string    `db:"status"` // "ACTIVE" | "PAUSED"
+	ID          string    `db:"id"`
+	Status      string    `db:"status"`       // "ACTIVE" | "PAUSED"
+	Stage       string    `db:"stage"`        // funnel position, see Stage*
+	Language    string    `db:"language"`     // ISO 639-1; "" until detected
+	Timezone    string    `db:"timezone"`     // IANA name; "" if unknown
+	SourceID    string    `db:"source_id"`    // business phone_number_id it arrived on
+	PausedBy    string    `db:"paused_by"`    // staff member who last took over
+	PausedAt    time.Time `db:"paused_at"`    // zero if never paused
+	DisplayName string    `db:"display_name"` // WhatsApp profile name; "" if unknown
+	// HandoffFailedAt is when the last Slack handoff failed; zero once a
+	// handoff has gone through.
+	HandoffFailedAt time.Time `db:"handoff_failed_at"`
+	// PromptVariant is the system prompt variant the conversation is pinned
+	// to; "" until its first LLM call.
+	PromptVariant string `db:"prompt_variant"`
+	// Flags are every business rule flag the LLM has raised on the
+	// conversation, in the order first raised.
+	Flags     []string  `db:"flags"`
 	CreatedAt time.Time `db:"created_at"`
 	UpdatedAt time.Time `db:"updated_at"`
 }
 
+// Conversation stages track the funnel independently of Status, which only
+// says whether the bot or staff is replying. They're listed in funnel order.
+const (
+	StageNew        = "NEW"        // no assistant reply yet
+	StageCollecting = "COLLECTING" // gathering quote details
+	StageQuoted     = "QUOTED"     // handed off to staff for a quote
+	StageScheduled  = "SCHEDULED"  // sent the booking link
+	StageClosed     = "CLOSED"     // done; set by staff
+)
+
+var stageOrder = []string{StageNew, StageCollecting, StageQuoted, StageScheduled, StageClosed}
+
+// StageRank returns a stage's position in the funnel, or -1 if it is unknown.
+func StageRank(stage string) int {
+	for i, s := range stageOrder {
+		if s == stage {
+			return i
+		}
+	}
+	return -1
+}
+
 type Message struct {
 	ID             string    `db:"id"`
 	ConversationID string    `db:"conversation_id"`
 	Role           string    `db:"role"` // "user" | "assistant" | "system"
 	Content        string    `db:"content"`
+	SentAt         time.Time `db:"sent_at"`          // Meta's send time; zero when unknown
+	RawLLMResponse string    `db:"raw_llm_response"` // DeepSeek content as returned; assistant rows only
+	Action         string    `db:"action"`           // LLM action taken; assistant rows only
+	Forwarded      string    `db:"forwarded"`        // Forwarded or FrequentlyForwarded; user rows only
+	ReplyTo        string    `db:"reply_to"`         // wamid the customer quoted or reacted to; user rows only
+	Wamid          string    `db:"wamid"`            // Meta's id for the reply as sent; assistant rows only
 	CreatedAt      time.Time `db:"created_at"`
 }
 
-// ─── LLM contract ────────────────────────────────────────────────────────────
+// ActivityStats counts conversation volume and funnel outcomes since a time.
+// Handoffs and Schedules count distinct conversations, not replies.
+type ActivityStats struct {
+	Since         time.Time `json:"since"`
+	Conversations int       `json:"conversations"` // started in the window
+	Messages      int       `json:"messages"`
+	Handoffs      int       `json:"handoffs"`
+	Schedules     int       `json:"schedules"`
+	// MessageTypes counts inbound messages by WhatsApp type ("text",
+	// "image", "audio", ...), including ones we can't handle.
+	MessageTypes map[string]int `json:"message_types"`
+	// Variants breaks the funnel down by system prompt variant, for
+	// conversations started in the window. Empty without variants.
+	Variants map[string]VariantStats `json:"variants,omitempty"`
+}
+
+// VariantStats counts one prompt variant's conversations and how many of
+// them were handed off or scheduled.
+type VariantStats struct {
+	Conversations int `json:"conversations"`
+	Handoffs      int `json:"handoffs"`
+	Schedules     int `json:"schedules"`
+}
+
+// PendingReply is an assistant draft awaiting staff approval before it is sent.
+type PendingReply struct {
+	ConversationID   string    `db:"conversation_id"`
+	TriggerMessageID string    `db:"trigger_message_id"` // latest user message the draft answers
+	Reply            string    `db:"reply"`
+	CreatedAt        time.Time `db:"created_at"`
+	UpdatedAt        time.Time `db:"updated_at"`
+}
+
+// ─── LLM contract ─────────────────────────────────────────────────────────────
 
 type LLMMessage struct {
 	Role    string `json:"role"`
@@ -60,6 +260,32 @@ type LLMResponse struct {
 	ReplyToUser   string        `json:"reply_to_user"`
 	ExtractedData ExtractedData `json:"extracted_data"`
 	Action        string        `json:"action"` // "continue" | "handoff" | "schedule"
+	// Flags name business rules the request runs into, in snake_case
+	// ("hazardous_material"), so staff are warned. Usually empty.
+	Flags []string `json:"flags"`
+
+	// Usage is the provider's token count for the call that produced this
+	// response, nil when it didn't report one. Not part of the LLM's JSON.
+	Usage *LLMUsage `json:"-"`
+	// DataMissing is set when extracted_data couldn't be read (a salvaged,
+	// repaired or fallback response), so ExtractedData is empty rather than
+	// what the LLM knows. Not part of the LLM's JSON.
+	DataMissing bool `json:"-"`
+}
+
+// LLMUsage is token usage as reported by an OpenAI-style API.
+type LLMUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// ConversationUsage is the LLM usage accumulated for one conversation.
+type ConversationUsage struct {
+	ConversationID   string    `db:"conversation_id" json:"conversation_id"`
+	Calls            int       `db:"calls" json:"calls"`
+	PromptTokens     int       `db:"prompt_tokens" json:"prompt_tokens"`
+	CompletionTokens int       `db:"completion_tokens" json:"completion_tokens"`
+	UpdatedAt        time.Time `db:"updated_at" json:"updated_at"`
 }
 
 type ExtractedData struct {
@@ -69,6 +295,42 @@ type ExtractedData struct {
 	Inventory      string `json:"inventory"`
 }
 
+// QuoteFieldCount is the number of fields the assistant must collect.
+const QuoteFieldCount = 4
+
+// Fields returns the quote fields keyed by their JSON name.
+func (d ExtractedData) Fields() map[string]string {
+	return map[string]string{
+		"address":         d.Address,
+		"elevator_access": d.ElevatorAccess,
+		"stairs":          d.Stairs,
+		"inventory":       d.Inventory,
+	}
+}
+
+// FilledCount returns how many quote fields hold a real value.
+func (d ExtractedData) FilledCount() int {
+	n := 0
+	for _, v := range d.Fields() {
+		if FieldFilled(v) {
+			n++
+		}
+	}
+	return n
+}
+
+// IsComplete reports whether every quote field has been collected.
+func (d ExtractedData) IsComplete() bool {
+	return d.FilledCount() == QuoteFieldCount
+}
+
+// FieldFilled reports whether an extracted value is known — the LLM uses
+// "unknown" as its placeholder for fields it hasn't collected yet.
+func FieldFilled(v string) bool {
+	v = strings.TrimSpace(v)
+	return v != "" && !strings.EqualFold(v, "unknown")
+}
+
 // ─── Slack interactive payload ────────────────────────────────────────────────
 
 type SlackInteractivePayload struct {
@@ -86,3 +348,73 @@ type SlackAction struct {
 	ActionID string `json:"action_id"`
 	Value    string `json:"value"`
 }
+
+// OutboundFailure is a customer message Meta didn't accept, kept for replay.
+type OutboundFailure struct {
+	ID             int64     `db:"id" json:"id"`
+	ConversationID string    `db:"conversation_id" json:"conversation_id"`
+	Body           string    `db:"body" json:"body"`
+	StatusCode     int       `db:"status_code" json:"status_code"` // 0 when no response was received
+	Error          string    `db:"error" json:"error"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// WebhookEvent is a raw inbound webhook body as received, kept for debugging
+// Meta delivery whether or not it held a processable message.
+type WebhookEvent struct {
+	ID             int64     `db:"id" json:"id"`
+	RawBody        string    `db:"raw_body" json:"raw_body"`
+	SignatureValid bool      `db:"signature_valid" json:"signature_valid"`
+	ReceivedAt     time.Time `db:"received_at" json:"received_at"`
+}
+
+// DeferredInbound is a verified webhook body waiting for room in the
+// processing queue, with the trace id it was received under.
+type DeferredInbound struct {
+	Body    string
+	TraceID string
+}
+
+// FailedHandoff is a conversation whose quote never reached Slack.
+type FailedHandoff struct {
+	ConversationID string    `json:"conversation_id"`
+	DisplayName    string    `json:"display_name,omitempty"`
+	Stage          string    `json:"stage"`
+	FailedAt       time.Time `json:"failed_at"`
+}
+
+// MessageStatus is the latest delivery status Meta reported for one of our
+// outbound messages, with the billing conversation and pricing category.
+type MessageStatus struct {
+	WAMID            string    `db:"wamid" json:"wamid"`
+	Status           string    `db:"status" json:"status"`
+	WAConversationID string    `db:"wa_conversation_id" json:"wa_conversation_id"`
+	PricingCategory  string    `db:"pricing_category" json:"pricing_category"`
+	ErrorCode        int       `db:"error_code" json:"error_code,omitempty"` // Meta's delivery error, 0 if none
+	ErrorTitle       string    `db:"error_title" json:"error_title,omitempty"`
+	UpdatedAt        time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// OutboundMessage is a queued customer reply awaiting delivery by the
+// outbound worker. Status is pending, sent or failed.
+type OutboundMessage struct {
+	ID             int64     `db:"id" json:"id"`
+	ConversationID string    `db:"conversation_id" json:"conversation_id"`
+	Body           string    `db:"body" json:"body"`
+	MessageID      string    `db:"message_id" json:"message_id,omitempty"` // assistant message it delivers, if any
+	TraceID        string    `db:"trace_id" json:"trace_id,omitempty"`     // of the inbound request that queued it
+	Status         string    `db:"status" json:"status"`
+	Attempts       int       `db:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time `db:"next_attempt_at" json:"next_attempt_at"`
+	LastError      string    `db:"last_error" json:"last_error"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// ConversationNote is a staff annotation on a conversation.
+type ConversationNote struct {
+	ID             int64     `db:"id" json:"id"`
+	ConversationID string    `db:"conversation_id" json:"conversation_id"`
+	Author         string    `db:"author" json:"author"`
+	Note           string    `db:"note" json:"note"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
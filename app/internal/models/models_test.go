@@ -0,0 +1,22 @@
+package models
+
+import "testing"
+
+func TestExtractedData_IsComplete(t *testing.T) {
+	cases := []struct {
+		name string
+		data ExtractedData
+		want bool
+	}{
+		{"complete", ExtractedData{Address: "1 Main St", ElevatorAccess: "yes", Stairs: "no", Inventory: "couch"}, true},
+		{"partial", ExtractedData{Address: "1 Main St", ElevatorAccess: "yes", Inventory: "couch"}, false},
+		{"all unknown", ExtractedData{Address: "unknown", ElevatorAccess: "Unknown", Stairs: "unknown", Inventory: " unknown "}, false},
+		{"one unknown", ExtractedData{Address: "1 Main St", ElevatorAccess: "yes", Stairs: "unknown", Inventory: "couch"}, false},
+		{"blank counts as missing", ExtractedData{Address: "1 Main St", ElevatorAccess: "yes", Stairs: "  ", Inventory: "couch"}, false},
+	}
+	for _, c := range cases {
+		if got := c.data.IsComplete(); got != c.want {
+			t.Errorf("%s: IsComplete() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
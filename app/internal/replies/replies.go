@@ -0,0 +1,67 @@
+// Package replies holds the static messages sent to customers outside the
+// LLM flow (paused chats, unsupported media, capacity limits), in each
+// language we serve. The texts live in replies.yaml, embedded at build time.
+package replies
+
+import (
+	_ "embed"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"clearoutspaces/internal/language"
+)
+
+// Message ids, the top-level keys of replies.yaml.
+const (
+	UnsupportedType    = "unsupported_type"
+	Paused             = "paused"
+	TooLong            = "too_long"
+	EmptyMessage       = "empty_message"
+	OverCapacityClosed = "over_capacity_closed"
+	OverCapacityQueued = "over_capacity_queued"
+	BookingLink        = "booking_link"
+	HandoffAfterHours  = "handoff_after_hours" // %s is the opening hours
+)
+
+// DefaultLanguage is used when the customer's language is unknown or a
+// message has no translation for it.
+const DefaultLanguage = language.English
+
+//go:embed replies.yaml
+var repliesYAML []byte
+
+// catalog maps message id → language code → text.
+var catalog = mustParse(repliesYAML)
+
+func mustParse(data []byte) map[string]map[string]string {
+	c, err := parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// parse decodes a replies file, requiring every message in DefaultLanguage.
+func parse(data []byte) (map[string]map[string]string, error) {
+	var c map[string]map[string]string
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("replies: parse YAML: %w", err)
+	}
+	for id, texts := range c {
+		if texts[DefaultLanguage] == "" {
+			return nil, fmt.Errorf("replies: %q has no %q text", id, DefaultLanguage)
+		}
+	}
+	return c, nil
+}
+
+// Get returns message id in lang, falling back to DefaultLanguage when lang
+// is empty or untranslated. Unknown ids return "".
+func Get(id, lang string) string {
+	texts := catalog[id]
+	if text := texts[lang]; text != "" {
+		return text
+	}
+	return texts[DefaultLanguage]
+}
@@ -0,0 +1,61 @@
+package replies
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	cases := []struct {
+		id, lang string
+		want     string
+	}{
+		{Paused, "en", "Our team is handling your request directly."},
+		{Paused, "es", "Nuestro equipo está atendiendo su solicitud"},
+		{UnsupportedType, "fr", "Désolé"},
+		{Paused, "", "Our team is handling"},               // unknown language → default
+		{TooLong, "de", "Sorry, that message is too long"}, // untranslated → default
+	}
+	for _, c := range cases {
+		if got := Get(c.id, c.lang); !strings.HasPrefix(got, c.want) {
+			t.Errorf("Get(%q, %q) = %q, want prefix %q", c.id, c.lang, got, c.want)
+		}
+	}
+	if got := Get("no_such_message", "en"); got != "" {
+		t.Errorf("expected empty text for an unknown id, got %q", got)
+	}
+}
+
+func TestCatalog_ServesEveryID(t *testing.T) {
+	for _, id := range []string{UnsupportedType, Paused, TooLong, EmptyMessage, OverCapacityClosed, OverCapacityQueued, BookingLink} {
+		for _, lang := range []string{"en", "es", "fr"} {
+			if catalog[id][lang] == "" {
+				t.Errorf("replies.yaml is missing %s/%s", id, lang)
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := parse([]byte("greeting:\n  en: Hello\n  es: Hola\nbye:\n  en: Bye\n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	prev := catalog
+	catalog = c
+	defer func() { catalog = prev }()
+
+	if got := Get("greeting", "es"); got != "Hola" {
+		t.Errorf("expected the Spanish text, got %q", got)
+	}
+	if got := Get("bye", "es"); got != "Bye" {
+		t.Errorf("expected fallback to English, got %q", got)
+	}
+
+	if _, err := parse([]byte("greeting:\n  es: Hola\n")); err == nil {
+		t.Error("expected an error for a message without default-language text")
+	}
+	if _, err := parse([]byte("greeting: [")); err == nil {
+		t.Error("expected an error for malformed YAML")
+	}
+}
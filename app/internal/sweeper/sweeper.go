@@ -0,0 +1,104 @@
+// Package sweeper runs periodic background maintenance against the database.
+package sweeper
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/events"
+)
+
+// AutoResumeActor is recorded as the actor on StatusChanged events emitted
+// when a forgotten takeover is handed back to the bot.
+const AutoResumeActor = "auto-resume"
+
+// ResumeStale hands every conversation paused before cutoff back to the bot
+// and returns how many were resumed.
+func ResumeStale(db *database.DB, cutoff time.Time) (int, error) {
+	ids, err := db.ListStalePausedConversations(cutoff)
+	if err != nil {
+		return 0, err
+	}
+	resumed := 0
+	for _, id := range ids {
+		if err := db.ResumeConversation(id); err != nil {
+			slog.Error("sweeper: resume", "phone", id, "err", err)
+			continue
+		}
+		slog.Info("sweeper: auto-resumed conversation", "phone", id, "paused_before", cutoff.Format(time.RFC3339), "event", "auto_resume")
+		events.Publish(events.Event{Type: events.StatusChanged, Phone: id, Status: "ACTIVE", Actor: AutoResumeActor})
+		resumed++
+	}
+	return resumed, nil
+}
+
+// RunAutoResume resumes conversations paused for longer than timeout, checking
+// every interval until ctx is cancelled.
+func RunAutoResume(ctx context.Context, db *database.DB, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("sweeper: auto-resume stopped")
+			return
+		case now := <-ticker.C:
+			if _, err := ResumeStale(db, now.Add(-timeout)); err != nil {
+				slog.Error("sweeper: list stale paused conversations", "err", err)
+			}
+		}
+	}
+}
+
+// ArchiveMessages moves messages created before cutoff into the archive and
+// returns how many were moved.
+func ArchiveMessages(db *database.DB, cutoff time.Time) (int, error) {
+	n, err := db.ArchiveOldMessages(cutoff)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		slog.Info("sweeper: archived old messages", "count", n, "before", cutoff.Format(time.RFC3339), "event", "retention")
+	}
+	return n, nil
+}
+
+// PruneWebhookEvents deletes raw webhook bodies received before cutoff and
+// returns how many were deleted. Unlike messages they aren't archived; the
+// transcript already holds what they carried.
+func PruneWebhookEvents(db *database.DB, cutoff time.Time) (int, error) {
+	n, err := db.DeleteWebhookEvents(cutoff)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		slog.Info("sweeper: deleted old webhook events", "count", n, "before", cutoff.Format(time.RFC3339), "event", "retention")
+	}
+	return n, nil
+}
+
+// RunRetention archives messages and deletes webhook events older than
+// maxAge now and then every interval until ctx is cancelled.
+func RunRetention(ctx context.Context, db *database.DB, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	now := time.Now()
+	for {
+		if _, err := ArchiveMessages(db, now.Add(-maxAge)); err != nil {
+			slog.Error("sweeper: archive old messages", "err", err)
+		}
+		if _, err := PruneWebhookEvents(db, now.Add(-maxAge)); err != nil {
+			slog.Error("sweeper: delete old webhook events", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			slog.Info("sweeper: retention stopped")
+			return
+		case now = <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package sweeper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"clearoutspaces/internal/database"
+	"clearoutspaces/internal/models"
+)
+
+func TestResumeStale_OnlyResumesOldTakeovers(t *testing.T) {
+	db := database.Init(":memory:")
+	for _, phone := range []string{"14165550001", "14165550002", "14165550003"} {
+		if err := db.UpsertConversation(phone); err != nil {
+			t.Fatalf("upsert: %v", err)
+		}
+	}
+	_ = db.PauseConversation("14165550001", "staff")
+	_ = db.PauseConversation("14165550002", "staff")
+
+	// Nothing was paused before an hour ago.
+	if n, err := ResumeStale(db, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected nothing resumed yet, got %d (%v)", n, err)
+	}
+
+	// A cutoff in the future makes both takeovers stale.
+	n, err := ResumeStale(db, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("ResumeStale: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 conversations resumed, got %d", n)
+	}
+	for _, phone := range []string{"14165550001", "14165550002", "14165550003"} {
+		if status, _ := db.GetConversationStatus(phone); status != "ACTIVE" {
+			t.Errorf("expected %s ACTIVE, got %q", phone, status)
+		}
+	}
+}
+
+func TestRunAutoResume_StopsOnCancel(t *testing.T) {
+	db := database.Init(":memory:")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunAutoResume(ctx, db, time.Millisecond, time.Hour)
+		close(done)
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunAutoResume did not stop after cancel")
+	}
+}
+
+func TestArchiveMessages_MovesOnlyBeforeCutoff(t *testing.T) {
+	db := database.Init(":memory:")
+	if err := db.UpsertConversation("14165550004"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertMessage(&models.Message{ID: "m1", ConversationID: "14165550004", Role: "user", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := ArchiveMessages(db, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected nothing archived yet, got %d (%v)", n, err)
+	}
+	if n, err := ArchiveMessages(db, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected 1 message archived, got %d (%v)", n, err)
+	}
+	if exists, _ := db.MessageExists("m1"); !exists {
+		t.Error("expected the archived message to still count as processed")
+	}
+}
+
+func TestPruneWebhookEvents_DeletesOnlyBeforeCutoff(t *testing.T) {
+	db := database.Init(":memory:")
+	if err := db.InsertWebhookEvent(`{"object":"whatsapp_business_account"}`, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := PruneWebhookEvents(db, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected nothing deleted yet, got %d (%v)", n, err)
+	}
+	if n, err := PruneWebhookEvents(db, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected 1 event deleted, got %d (%v)", n, err)
+	}
+	if events, _ := db.ListWebhookEvents(10); len(events) != 0 {
+		t.Errorf("expected no events left, got %d", len(events))
+	}
+}
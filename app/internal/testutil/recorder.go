@@ -0,0 +1,122 @@
+// Package testutil holds shared test infrastructure. Import it from _test.go
+// files only.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Request is one request a RecordingServer received. JSON is the decoded
+// body, nil when it isn't a JSON object.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+	JSON   map[string]any
+}
+
+// RecordingServer is an httptest.Server that captures every request before
+// answering it, standing in for Meta, Slack or DeepSeek.
+type RecordingServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewRecordingServer starts a server that records each request and then
+// hands it to respond, whose r.Body is still readable. A nil respond answers
+// 200 with an empty body. The server closes when the test ends.
+func NewRecordingServer(t testing.TB, respond http.HandlerFunc) *RecordingServer {
+	t.Helper()
+	s := &RecordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body}
+		_ = json.Unmarshal(body, &req.JSON)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		if respond != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			respond(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Requests returns a copy of everything received so far, oldest first.
+func (s *RecordingServer) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Last returns the most recent request, failing the test if there is none.
+func (s *RecordingServer) Last(t testing.TB) Request {
+	t.Helper()
+	reqs := s.Requests()
+	if len(reqs) == 0 {
+		t.Fatal("testutil: no requests recorded")
+	}
+	return reqs[len(reqs)-1]
+}
+
+// Sends returns the WhatsApp messages posted to a Meta stub, leaving out
+// read receipts and media lookups.
+func (s *RecordingServer) Sends() []Request {
+	var sends []Request
+	for _, r := range s.Requests() {
+		if r.Method == http.MethodPost && r.JSON["status"] != "read" {
+			sends = append(sends, r)
+		}
+	}
+	return sends
+}
+
+// LastSend returns the text of the last WhatsApp message posted to a Meta
+// stub, failing the test if none was sent or it wasn't a text message.
+func (s *RecordingServer) LastSend(t testing.TB) string {
+	t.Helper()
+	sends := s.Sends()
+	if len(sends) == 0 {
+		t.Fatal("testutil: no WhatsApp message sent")
+	}
+	text, _ := sends[len(sends)-1].JSON["text"].(map[string]any)
+	body, ok := text["body"].(string)
+	if !ok {
+		t.Fatalf("testutil: last send is not a text message: %s", sends[len(sends)-1].Body)
+	}
+	return body
+}
+
+// LastHandoff returns the mrkdwn of the first section block in the last
+// Slack post, failing the test if nothing was posted.
+func (s *RecordingServer) LastHandoff(t testing.TB) string {
+	t.Helper()
+	last := s.Last(t)
+	blocks, _ := last.JSON["blocks"].([]any)
+	for _, b := range blocks {
+		block, _ := b.(map[string]any)
+		if block["type"] != "section" {
+			continue
+		}
+		if text, ok := block["text"].(map[string]any); ok {
+			if mrkdwn, ok := text["text"].(string); ok {
+				return mrkdwn
+			}
+		}
+	}
+	t.Fatalf("testutil: last Slack post has no section text: %s", last.Body)
+	return ""
+}
@@ -0,0 +1,37 @@
+package testutil
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRecordingServer(t *testing.T) {
+	srv := NewRecordingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	})
+
+	post := func(path, body string) {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	post("/v18.0/123/messages", `{"messaging_product":"whatsapp","status":"read","message_id":"wamid.in"}`)
+	post("/v18.0/123/messages", `{"messaging_product":"whatsapp","type":"text","text":{"body":"Hello!"}}`)
+	post("/hook", `{"text":"New Quote","blocks":[{"type":"section","text":{"type":"mrkdwn","text":"*Phone:* 14165551234"}}]}`)
+
+	if n := len(srv.Requests()); n != 3 {
+		t.Fatalf("expected 3 requests recorded, got %d", n)
+	}
+	if sends := srv.Sends(); len(sends) != 2 || sends[0].Path != "/v18.0/123/messages" {
+		t.Errorf("expected read receipts left out of sends, got %+v", sends)
+	}
+	if got := srv.LastHandoff(t); got != "*Phone:* 14165551234" {
+		t.Errorf("unexpected handoff text %q", got)
+	}
+	if last := srv.Last(t); last.Method != http.MethodPost || last.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected last request: %+v", last)
+	}
+}
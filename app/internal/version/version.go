@@ -0,0 +1,28 @@
+// Package version holds build metadata injected at link time:
+//
+//	go build -ldflags "-X clearoutspaces/internal/version.Version=v1.4.0 \
+//	  -X clearoutspaces/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X clearoutspaces/internal/version.BuiltAt=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import "runtime"
+
+// Set via -ldflags; the defaults mark a local, uninjected build.
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	BuiltAt = "unknown"
+)
+
+// Info is the build metadata served by GET /version.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuiltAt   string `json:"built_at"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the running binary's build metadata.
+func Get() Info {
+	return Info{Version: Version, Commit: Commit, BuiltAt: BuiltAt, GoVersion: runtime.Version()}
+}